@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mattmckeon/splitflap/internal/mbta"
+)
+
+const BluebikesGbfsBaseUrl = "https://gbfs.bluebikes.com/gbfs/en/"
+
+// BikeStation is the current availability at a Bluebikes station near a stop.
+type BikeStation struct {
+	Name      string  `json:"name"`
+	Distance  float64 `json:"distance_meters"`
+	Bikes     int     `json:"bikes_available"`
+	Docks     int     `json:"docks_available"`
+	IsRenting bool    `json:"is_renting"`
+}
+
+// StopLocator is an interface for looking up the coordinates of a stop.
+type StopLocator interface {
+	LocateStop(place string) (lat, lon float64, err error)
+}
+
+// LocateStop is an implementation of the StopLocator LocateStop method that
+// fetches the stop from the MBTA APIv3 stops endpoint.
+func (s *MbtaServiceImpl) LocateStop(place string) (float64, float64, error) {
+	stops, err := s.mbta.Stops(mbta.Filter("id", place))
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(stops) == 0 {
+		return 0, 0, fmt.Errorf("unknown stop %q", place)
+	}
+	return stops[0].Latitude, stops[0].Longitude, nil
+}
+
+// gbfsStationInformation is the part of the GBFS station_information feed we
+// use.
+type gbfsStationInformation struct {
+	Data struct {
+		Stations []struct {
+			StationId string  `json:"station_id"`
+			Name      string  `json:"name"`
+			Lat       float64 `json:"lat"`
+			Lon       float64 `json:"lon"`
+		} `json:"stations"`
+	} `json:"data"`
+}
+
+// gbfsStationStatus is the part of the GBFS station_status feed we use.
+type gbfsStationStatus struct {
+	Data struct {
+		Stations []struct {
+			StationId         string `json:"station_id"`
+			NumBikesAvailable int    `json:"num_bikes_available"`
+			NumDocksAvailable int    `json:"num_docks_available"`
+			IsInstalled       int    `json:"is_installed"`
+			IsRenting         int    `json:"is_renting"`
+		} `json:"stations"`
+	} `json:"data"`
+}
+
+// BluebikesProvider fetches bike availability from the Bluebikes GBFS feed.
+// Station information rarely changes, so it's cached for InfoTtl; station
+// status is fetched on every call.
+type BluebikesProvider struct {
+	BaseUrl string
+	// Radius is the maximum distance in meters of stations from a stop.
+	Radius float64
+	// Limit is the maximum number of stations returned for a stop.
+	Limit   int
+	InfoTtl time.Duration
+	client  *http.Client
+
+	mu        sync.Mutex
+	info      *gbfsStationInformation
+	infoFetch time.Time
+}
+
+// NewBluebikesProvider creates and returns a new BluebikesProvider that finds
+// up to three stations within 400 meters of a stop.
+func NewBluebikesProvider(httpClient *http.Client) *BluebikesProvider {
+	return &BluebikesProvider{
+		BaseUrl: BluebikesGbfsBaseUrl,
+		Radius:  400,
+		Limit:   3,
+		InfoTtl: time.Hour,
+		client:  httpClient,
+	}
+}
+
+// bluebikes provides the Bluebikes panel on boards, if enabled by $BLUEBIKES.
+var bluebikes *BluebikesProvider
+
+// NearbyStations returns the installed stations within the provider's radius
+// of the given coordinates, nearest first.
+func (p *BluebikesProvider) NearbyStations(lat, lon float64) ([]BikeStation, error) {
+	info, err := p.stationInformation()
+	if err != nil {
+		return nil, err
+	}
+	var status gbfsStationStatus
+	if err := p.get("station_status.json", &status); err != nil {
+		return nil, err
+	}
+
+	type location struct {
+		name     string
+		distance float64
+	}
+	nearby := map[string]location{}
+	for _, station := range info.Data.Stations {
+		if d := distanceMeters(lat, lon, station.Lat, station.Lon); d <= p.Radius {
+			nearby[station.StationId] = location{station.Name, d}
+		}
+	}
+	stations := []BikeStation{}
+	for _, s := range status.Data.Stations {
+		loc, ok := nearby[s.StationId]
+		if !ok || s.IsInstalled == 0 {
+			continue
+		}
+		stations = append(stations, BikeStation{
+			Name:      loc.name,
+			Distance:  math.Round(loc.distance),
+			Bikes:     s.NumBikesAvailable,
+			Docks:     s.NumDocksAvailable,
+			IsRenting: s.IsRenting != 0,
+		})
+	}
+	sort.Slice(stations, func(i, j int) bool {
+		return stations[i].Distance < stations[j].Distance
+	})
+	if len(stations) > p.Limit {
+		stations = stations[:p.Limit]
+	}
+	return stations, nil
+}
+
+// stationInformation returns the cached station information feed, refreshing
+// it if it's older than InfoTtl.
+func (p *BluebikesProvider) stationInformation() (*gbfsStationInformation, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.info != nil && time.Since(p.infoFetch) < p.InfoTtl {
+		return p.info, nil
+	}
+	info := new(gbfsStationInformation)
+	if err := p.get("station_information.json", info); err != nil {
+		return nil, err
+	}
+	p.info, p.infoFetch = info, time.Now()
+	return info, nil
+}
+
+// get fetches the named GBFS feed and decodes it into v.
+func (p *BluebikesProvider) get(feed string, v interface{}) error {
+	resp, err := p.client.Get(p.BaseUrl + feed)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New("Bluebikes error: " + resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// distanceMeters returns the great-circle distance between two points using
+// the haversine formula.
+func distanceMeters(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadius = 6371000
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := rad(lat2 - lat1)
+	dLon := rad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
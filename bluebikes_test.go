@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func TestNearbyBikeStations(t *testing.T) {
+	defer gock.Off()
+
+	gock.New(MbtaApiV3BaseUrl).
+		Get("/stops").
+		MatchParam("filter[id]", "place-north").
+		Reply(200).
+		File("testdata/stop-north.json")
+	gock.New(BluebikesGbfsBaseUrl).
+		Get("/station_information.json").
+		Reply(200).
+		File("testdata/bluebikes-station-information.json")
+	gock.New(BluebikesGbfsBaseUrl).
+		Get("/station_status.json").
+		Times(2).
+		Reply(200).
+		File("testdata/bluebikes-station-status.json")
+
+	httpClient := &http.Client{}
+	gock.InterceptClient(httpClient)
+
+	lat, lon, err := NewMbtaServiceImpl(httpClient).LocateStop("place-north")
+	assert.NoError(t, err)
+
+	provider := NewBluebikesProvider(httpClient)
+	stations, err := provider.NearbyStations(lat, lon)
+	assert.NoError(t, err)
+	expected := []BikeStation{
+		{"North Station - Valenti Way at Causeway St", 57, 4, 15, true},
+		{"Boston Garden - Legends Way", 89, 0, 15, true},
+	}
+	assert.Equal(t, expected, stations)
+
+	// Station information is cached, so only the status is fetched again.
+	_, err = provider.NearbyStations(lat, lon)
+	assert.NoError(t, err)
+	assert.True(t, gock.IsDone())
+}
+
+func TestDistanceMeters(t *testing.T) {
+	// North Station to South Station is about 1.5km as the crow flies.
+	d := distanceMeters(42.365577, -71.06129, 42.352271, -71.055242)
+	assert.InDelta(t, 1560, d, 20)
+}
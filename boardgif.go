@@ -0,0 +1,177 @@
+package main
+
+import (
+	"image/gif"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// flapChars is the order of the characters on each flap. A flap changing
+// from one character to another shows all the characters in between.
+const flapChars = " ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789:.-/&'()+,"
+
+// flapStep is the number of characters a flap advances between GIF frames.
+const flapStep = 3
+
+// GIF frame delays, in hundredths of a second.
+const (
+	flapDelay = 6
+	holdDelay = 300
+)
+
+// flapFrames returns the lines of the intermediate frames shown while a board
+// flips from one set of lines to another of the same size. Each character
+// advances through flapChars until it reaches its new value; characters that
+// aren't on the flaps change straight away.
+func flapFrames(from, to []string) [][]string {
+	type cell struct {
+		row, col    int
+		start, dist int
+	}
+	n := len(flapChars)
+	var cells []cell
+	frames := 0
+	grids := make([][]rune, len(to))
+	for row := range to {
+		grids[row] = []rune(to[row])
+		old := []rune(from[row])
+		for col, r := range grids[row] {
+			start, end := strings.IndexRune(flapChars, old[col]), strings.IndexRune(flapChars, r)
+			if start < 0 || end < 0 || start == end {
+				continue
+			}
+			dist := (end - start + n) % n
+			cells = append(cells, cell{row, col, start, dist})
+			if steps := (dist + flapStep - 1) / flapStep; steps-1 > frames {
+				frames = steps - 1
+			}
+		}
+	}
+
+	result := make([][]string, frames)
+	for f := 1; f <= frames; f++ {
+		frame := make([][]rune, len(grids))
+		for row := range grids {
+			frame[row] = append([]rune(nil), grids[row]...)
+		}
+		for _, c := range cells {
+			if f*flapStep < c.dist {
+				frame[c.row][c.col] = rune(flapChars[(c.start+f*flapStep)%n])
+			}
+		}
+		result[f-1] = make([]string, len(frame))
+		for row := range frame {
+			result[f-1][row] = string(frame[row])
+		}
+	}
+	return result
+}
+
+// WriteBoardGif writes an animated GIF that flips from a blank board through
+// each of the given board states in turn.
+func WriteBoardGif(w io.Writer, states []*DepartureBoard) error {
+	anim := &gif.GIF{}
+	prev := boardLines(&DepartureBoard{}, boardImageRows)
+	for _, state := range states {
+		lines := boardLines(state, boardImageRows)
+		for _, frame := range flapFrames(prev, lines) {
+			anim.Image = append(anim.Image, drawBoard(frame, boardImageScale))
+			anim.Delay = append(anim.Delay, flapDelay)
+		}
+		anim.Image = append(anim.Image, drawBoard(lines, boardImageScale))
+		anim.Delay = append(anim.Delay, holdDelay)
+		prev = lines
+	}
+	return gif.EncodeAll(w, anim)
+}
+
+// BoardHistory keeps the last few distinct states of the board for each of
+// the most recently fetched stops, for animating the changes. It's safe for
+// concurrent use.
+type BoardHistory struct {
+	Size int
+
+	mu     sync.Mutex
+	states map[string][]*DepartureBoard
+	recent recentKeys
+}
+
+// NewBoardHistory returns a BoardHistory that keeps size states for each of
+// the maxStops most recently fetched stops.
+func NewBoardHistory(size, maxStops int) *BoardHistory {
+	return &BoardHistory{
+		Size:   size,
+		states: map[string][]*DepartureBoard{},
+		recent: recentKeys{Max: maxStops},
+	}
+}
+
+// boardHistory records the boards fetched by the web server.
+var boardHistory = NewBoardHistory(4, maxTrackedBoards)
+
+// Record adds the board as the latest state for the stop, unless it looks the
+// same as the previous state, dropping the history of the least recently
+// fetched stop if there are then too many.
+func (h *BoardHistory) Record(stop string, board *DepartureBoard) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if oldest, ok := h.recent.use(stop); ok {
+		delete(h.states, oldest)
+	}
+	states := h.states[stop]
+	if len(states) > 0 &&
+		strings.Join(boardLines(states[len(states)-1], boardImageRows), "\n") ==
+			strings.Join(boardLines(board, boardImageRows), "\n") {
+		return
+	}
+	states = append(states, board)
+	if len(states) > h.Size {
+		states = states[len(states)-h.Size:]
+	}
+	h.states[stop] = states
+}
+
+// States returns the recorded states for the stop, oldest first.
+func (h *BoardHistory) States(stop string) []*DepartureBoard {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]*DepartureBoard(nil), h.states[stop]...)
+}
+
+// queryBoard returns the definition of the board for the request's ?stop=,
+// by default the first of the defined boards, with its filter overridden by
+// the query string.
+func queryBoard(c *gin.Context, defs []BoardDefinition) (BoardDefinition, error) {
+	def := defs[0]
+	if stop := c.Query("stop"); stop != "" {
+		def = BoardDefinition{Title: c.DefaultQuery("title", stop), Stop: stop, Filter: def.Filter}
+		for _, d := range defs {
+			if d.Stop == stop {
+				def = d
+			}
+		}
+	}
+	filter, err := ParseFilter(c, def.Filter)
+	def.Filter = filter
+	return def, err
+}
+
+// RenderBoardGif fetches the board for the request's ?stop= (by default the
+// first of the defined boards) and responds with an animated GIF of its
+// recent states, ending with the current one.
+func RenderBoardGif(c *gin.Context, client MbtaService, defs []BoardDefinition) {
+	def, err := queryBoard(c, defs)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	FetchBoard(c, client, def)
+	c.Header("Content-Type", "image/gif")
+	if err := WriteBoardGif(c.Writer, boardHistory.States(def.Stop)); err != nil {
+		c.Error(err)
+	}
+}
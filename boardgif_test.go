@@ -0,0 +1,69 @@
+package main
+
+import (
+	"image/gif"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlapFrames(t *testing.T) {
+	// " " to "Z" is 26 flaps, so 8 intermediate frames at 3 flaps a frame.
+	// "A" to "C" only needs one, and "é" isn't on the flaps so changes at once.
+	frames := flapFrames([]string{" A?"}, []string{"ZCé"})
+	assert.Len(t, frames, 8)
+	assert.Equal(t, []string{"CCé"}, frames[0])
+	assert.Equal(t, []string{"XCé"}, frames[7])
+
+	assert.Empty(t, flapFrames([]string{"SAME"}, []string{"SAME"}))
+}
+
+func TestBoardHistory(t *testing.T) {
+	history := NewBoardHistory(2, 2)
+	first := &DepartureBoard{Title: "Back Bay"}
+	history.Record("place-bbsta", first)
+	history.Record("place-bbsta", &DepartureBoard{Title: "Back Bay"})
+	assert.Equal(t, []*DepartureBoard{first}, history.States("place-bbsta"))
+
+	second := &DepartureBoard{Title: "Back Bay", Departures: []Departure{{TimeLabel: "5:05PM"}}}
+	third := &DepartureBoard{Title: "Back Bay", Departures: []Departure{{TimeLabel: "5:20PM"}}}
+	history.Record("place-bbsta", second)
+	history.Record("place-bbsta", third)
+	assert.Equal(t, []*DepartureBoard{second, third}, history.States("place-bbsta"))
+	assert.Empty(t, history.States("place-north"))
+
+	// Only the most recently fetched stops are kept.
+	history.Record("place-north", first)
+	history.Record("place-sstat", first)
+	assert.Empty(t, history.States("place-bbsta"))
+	assert.Equal(t, []*DepartureBoard{first}, history.States("place-north"))
+}
+
+func TestRenderBoardGif(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	service := &MbtaServiceTest{"testdata/predictions-backbay.json"}
+	router.GET("/board/:stop", func(c *gin.Context) {
+		RenderBoard(c, service, Filter{})
+	})
+	router.GET("/board.gif", func(c *gin.Context) {
+		RenderBoardGif(c, service, DefaultBoards)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/board.gif?stop=place-gif-test&direction=both", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/gif", w.Header().Get("Content-Type"))
+	anim, err := gif.DecodeAll(w.Body)
+	if assert.NoError(t, err) {
+		assert.True(t, len(anim.Image) > 1)
+		assert.Equal(t, holdDelay, anim.Delay[len(anim.Delay)-1])
+		width, height, _, _ := boardImageSize(boardImageRows+1, boardImageScale)
+		assert.Equal(t, width, anim.Image[0].Bounds().Dx())
+		assert.Equal(t, height, anim.Image[0].Bounds().Dy())
+	}
+	assert.Len(t, boardHistory.States("place-gif-test"), 1)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"strings"
+)
+
+// Board images are drawn as a grid of split-flap cells, one per character,
+// with the board's title on the first row and departures below it. By default
+// boardImageRows departures are drawn at boardImageScale times the size of
+// the font.
+const (
+	boardImageRows  = 6
+	boardImageScale = 2
+)
+
+// boardImageSize returns the size of the image drawBoard draws for the given
+// number of lines at the given scale, along with the size of each cell.
+func boardImageSize(lines, scale int) (width, height, cellWidth, cellHeight int) {
+	cellWidth = (glyphWidth + 3) * scale
+	cellHeight = (glyphHeight + 5) * scale
+	margin := 4 * scale
+	return 2*margin + gridWidth*cellWidth, 2*margin + lines*cellHeight, cellWidth, cellHeight
+}
+
+// Palette indexes of the colors used in board images.
+const (
+	colorBackground = iota
+	colorFlap
+	colorText
+	colorTitle
+)
+
+// boardPalette matches the colors of the web boards.
+var boardPalette = color.Palette{
+	colorBackground: color.Black,
+	colorFlap:       color.RGBA{0x22, 0x22, 0x22, 0xff},
+	colorText:       color.RGBA{0xf1, 0xf4, 0x42, 0xff},
+	colorTitle:      color.White,
+}
+
+// boardLines returns the text of each row of a board image showing the given
+// number of departures, as board text padded to the width of the grid format.
+func boardLines(board *DepartureBoard, rowCount int) []string {
+	lines := []string{gridCell(board.Title, gridWidth)}
+	rows := []string{}
+	if board.Error != nil {
+		rows = append(rows, gridCell(board.Error.Error(), gridWidth))
+	}
+	for _, d := range board.Departures {
+		rows = append(rows, gridRow(d))
+	}
+	for i := 0; i < rowCount; i++ {
+		if i < len(rows) {
+			lines = append(lines, rows[i])
+		} else {
+			lines = append(lines, strings.Repeat(" ", gridWidth))
+		}
+	}
+	return lines
+}
+
+// drawBoard draws the lines of a board image at the given scale.
+func drawBoard(lines []string, scale int) *image.Paletted {
+	width, height, cellWidth, cellHeight := boardImageSize(len(lines), scale)
+	margin := (width - gridWidth*cellWidth) / 2
+	img := image.NewPaletted(image.Rect(0, 0, width, height), boardPalette)
+	for row, line := range lines {
+		ink := uint8(colorText)
+		if row == 0 {
+			ink = colorTitle
+		}
+		y := margin + row*cellHeight
+		for col, r := range []rune(line) {
+			x := margin + col*cellWidth
+			fillRect(img, x+1, y+1, cellWidth-2, cellHeight-2, colorFlap)
+			drawText(img, x+scale, y+2*scale, string(r), scale, ink)
+			// The split between the top and bottom flaps.
+			fillRect(img, x+1, y+cellHeight/2, cellWidth-2, 1, colorBackground)
+		}
+	}
+	return img
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SvgTheme is the set of colors a board is drawn in as SVG.
+type SvgTheme struct {
+	Background string
+	Flap       string
+	Text       string
+	Title      string
+}
+
+// svgThemes are the themes that can be picked with ?theme=. "dark" matches
+// the web boards and the PNG and GIF images, and "light" is black on white
+// for e-paper displays and printed documentation.
+var svgThemes = map[string]SvgTheme{
+	"dark":  {Background: "#000000", Flap: "#222222", Text: "#f1f442", Title: "#ffffff"},
+	"light": {Background: "#ffffff", Flap: "#eeeeee", Text: "#000000", Title: "#000000"},
+}
+
+// maxSvgSize is the largest width or height, in pixels, an SVG board can be
+// asked for, and maxSvgRows the most departures it can show.
+const (
+	maxSvgSize = 10000
+	maxSvgRows = 20
+)
+
+// WriteBoardSvg writes the lines of a board image to w as SVG, laid out like
+// drawBoard at a scale of 1 and drawn in the theme. The image is width by
+// height pixels; if either is zero it's scaled to keep the board's aspect
+// ratio, and if both are it's the size of the layout.
+func WriteBoardSvg(w io.Writer, lines []string, theme SvgTheme, width, height int) error {
+	viewWidth, viewHeight, cellWidth, cellHeight := boardImageSize(len(lines), 1)
+	margin := (viewWidth - gridWidth*cellWidth) / 2
+	switch {
+	case width == 0 && height == 0:
+		width, height = viewWidth, viewHeight
+	case height == 0:
+		height = width * viewHeight / viewWidth
+	case width == 0:
+		width = height * viewWidth / viewHeight
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n",
+		width, height, viewWidth, viewHeight)
+	fmt.Fprintf(bw, `<rect width="%d" height="%d" fill="%s"/>`+"\n", viewWidth, viewHeight, theme.Background)
+	fmt.Fprintf(bw, `<g font-family="VT323, monospace" font-size="%d" text-anchor="middle">`+"\n", glyphHeight+3)
+	for row, line := range lines {
+		ink := theme.Text
+		if row == 0 {
+			ink = theme.Title
+		}
+		y := margin + row*cellHeight
+		for col, r := range []rune(line) {
+			x := margin + col*cellWidth
+			fmt.Fprintf(bw, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s"/>`,
+				x+1, y+1, cellWidth-2, cellHeight-2, theme.Flap)
+			if r != ' ' {
+				fmt.Fprintf(bw, `<text x="%d" y="%d" fill="%s">`, x+cellWidth/2, y+cellHeight-3, ink)
+				xml.EscapeText(bw, []byte(string(r)))
+				bw.WriteString("</text>")
+			}
+		}
+		// The split between the top and bottom flaps, across the whole row.
+		fmt.Fprintf(bw, "\n"+`<rect x="%d" y="%d" width="%d" height="0.5" fill="%s"/>`+"\n",
+			margin, y+cellHeight/2, gridWidth*cellWidth, theme.Background)
+	}
+	bw.WriteString("</g>\n</svg>\n")
+	return bw.Flush()
+}
+
+// svgSizeParam returns the request's query parameter with the given name as a
+// size between 1 and max, or 0 if it's not set.
+func svgSizeParam(c *gin.Context, name string, max int) (int, error) {
+	value := c.Query(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > max {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return n, nil
+}
+
+// RenderBoardSvg fetches the board for the request's ?stop= (by default the
+// first of the defined boards) and responds with it as SVG. ?theme= picks one
+// of svgThemes, ?width= and ?height= the size in pixels, and ?rows= the number
+// of departures shown.
+func RenderBoardSvg(c *gin.Context, client MbtaService, defs []BoardDefinition) {
+	def, err := queryBoard(c, defs)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	themeName := c.DefaultQuery("theme", "dark")
+	theme, ok := svgThemes[themeName]
+	if !ok {
+		c.String(http.StatusBadRequest, fmt.Sprintf("invalid theme %q", themeName))
+		return
+	}
+	width, err := svgSizeParam(c, "width", maxSvgSize)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	height, err := svgSizeParam(c, "height", maxSvgSize)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	rows, err := svgSizeParam(c, "rows", maxSvgRows)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if rows == 0 {
+		rows = boardImageRows
+	}
+
+	board := FetchBoard(c, client, def)
+	c.Header("Content-Type", "image/svg+xml")
+	if err := WriteBoardSvg(c.Writer, boardLines(board, rows), theme, width, height); err != nil {
+		c.Error(err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// svgImage is the part of an SVG board the tests look at.
+type svgImage struct {
+	Width   int    `xml:"width,attr"`
+	Height  int    `xml:"height,attr"`
+	ViewBox string `xml:"viewBox,attr"`
+	Rects   []struct {
+		Fill string `xml:"fill,attr"`
+	} `xml:"rect"`
+	Texts []string `xml:"g>text"`
+}
+
+func parseSvg(t *testing.T, data []byte) svgImage {
+	var img svgImage
+	if !assert.NoError(t, xml.Unmarshal(data, &img)) {
+		t.FailNow()
+	}
+	return img
+}
+
+func TestWriteBoardSvg(t *testing.T) {
+	lines := []string{gridCell("A&B", gridWidth), gridCell("<5", gridWidth)}
+	viewWidth, viewHeight, _, _ := boardImageSize(len(lines), 1)
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteBoardSvg(&buf, lines, svgThemes["light"], 0, 0))
+	img := parseSvg(t, buf.Bytes())
+	assert.Equal(t, viewWidth, img.Width)
+	assert.Equal(t, viewHeight, img.Height)
+	assert.Equal(t, "#ffffff", img.Rects[0].Fill)
+	// Spaces are blank flaps, and the text is escaped.
+	assert.Equal(t, []string{"A", "&", "B", "<", "5"}, img.Texts)
+
+	// Sizes keep the aspect ratio unless both are given.
+	buf.Reset()
+	assert.NoError(t, WriteBoardSvg(&buf, lines, svgThemes["dark"], viewWidth*2, 0))
+	img = parseSvg(t, buf.Bytes())
+	assert.Equal(t, viewWidth*2, img.Width)
+	assert.Equal(t, viewHeight*2, img.Height)
+
+	buf.Reset()
+	assert.NoError(t, WriteBoardSvg(&buf, lines, svgThemes["dark"], 100, 50))
+	img = parseSvg(t, buf.Bytes())
+	assert.Equal(t, 100, img.Width)
+	assert.Equal(t, 50, img.Height)
+}
+
+func TestRenderBoardSvg(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	service := &MbtaServiceTest{"testdata/predictions-backbay.json"}
+	router.GET("/board.svg", func(c *gin.Context) {
+		RenderBoardSvg(c, service, DefaultBoards)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/board.svg?stop=place-bbsta&title=Back+Bay&rows=3&width=820", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/svg+xml", w.Header().Get("Content-Type"))
+	img := parseSvg(t, w.Body.Bytes())
+	// The title and three rows.
+	viewWidth, viewHeight, _, _ := boardImageSize(4, 1)
+	assert.Equal(t, fmt.Sprintf("0 0 %d %d", viewWidth, viewHeight), img.ViewBox)
+	assert.Equal(t, 820, img.Width)
+	assert.Equal(t, 820*viewHeight/viewWidth, img.Height)
+	assert.Equal(t, "#000000", img.Rects[0].Fill)
+	text := strings.Join(img.Texts, "")
+	assert.True(t, strings.HasPrefix(text, "BACKBAY5:05PMPROVIDENCE"), text)
+
+	for _, query := range []string{"theme=neon", "width=0", "height=big", "rows=50", "direction=sideways"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/board.svg?"+query, nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattmckeon/splitflap/internal/mbta"
+)
+
+// StaleError is returned along with the last good result when fetching fresh
+// data fails. Boards show the result with a banner saying how old it is.
+type StaleError struct {
+	Err   error
+	Since time.Time
+}
+
+// Error implements the Golang error interface for StaleError.
+func (e *StaleError) Error() string {
+	return fmt.Sprintf("showing data from %s: %v", e.Since.Format(time.Kitchen), e.Err)
+}
+
+// maxCacheEntries limits the number of requests a CachingService keeps the
+// results of, so that requests for made-up stops and windows can't use up
+// memory. The least recently used are dropped first.
+const maxCacheEntries = 100
+
+// CachingService wraps an MbtaService to protect the API during upstream
+// outages. After a failed fetch, the failure is cached for ErrorTtl (jittered
+// by up to half either way, so the boards don't all retry at once) and
+// repeated without asking the API again. While failing, the last good result
+// is returned with a *StaleError if there is one no older than StaleTtl.
+type CachingService struct {
+	MbtaService
+	ErrorTtl time.Duration
+	StaleTtl time.Duration
+	// Spans is where SpanSchedules fetches from, if not the wrapped service,
+	// such as a SchedulePrecomputer.
+	Spans SpanService
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	recent  recentKeys
+	stats   CacheStats
+}
+
+// CacheStats counts the requests made to a CachingService and how many of them
+// were answered from the cache without calling the wrapped service.
+type CacheStats struct {
+	Requests int64 `json:"requests"`
+	Hits     int64 `json:"hits"`
+}
+
+// HitRate returns the fraction of requests that were cache hits.
+func (s CacheStats) HitRate() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Requests)
+}
+
+// cacheEntry is the state of a single cached request.
+type cacheEntry struct {
+	value      interface{}
+	fetched    time.Time
+	err        error
+	retryAfter time.Time
+}
+
+// NewCachingService returns a CachingService wrapping service that caches
+// failures for 30 seconds and falls back on results up to an hour old.
+func NewCachingService(service MbtaService) *CachingService {
+	return &CachingService{
+		MbtaService: service,
+		ErrorTtl:    30 * time.Second,
+		StaleTtl:    time.Hour,
+		entries:     map[string]*cacheEntry{},
+		recent:      recentKeys{Max: maxCacheEntries},
+	}
+}
+
+// Unwrap returns the wrapped service, so callers can use its optional
+// capabilities such as OutageService directly.
+func (s *CachingService) Unwrap() MbtaService {
+	return s.MbtaService
+}
+
+// Stats returns the cache's hit counts so far.
+func (s *CachingService) Stats() CacheStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// ListDepartures is an implementation of the MbtaService ListDepartures method
+// that calls the wrapped service unless it failed recently.
+func (s *CachingService) ListDepartures(place string, filter Filter) ([]Departure, error) {
+	key := fmt.Sprintf("departures %s %+v", place, filter)
+	value, err := s.get(key, func() (interface{}, error) {
+		return s.MbtaService.ListDepartures(place, filter)
+	})
+	departures, _ := value.([]Departure)
+	return departures, err
+}
+
+// BatchPredictions is an implementation of the PredictionBatcher
+// BatchPredictions method that calls the wrapped service unless it failed
+// recently. It returns errNoBatching if the wrapped service isn't a
+// PredictionBatcher.
+func (s *CachingService) BatchPredictions(places []string) (map[string][]*mbta.Prediction, error) {
+	batcher, ok := s.MbtaService.(PredictionBatcher)
+	if !ok {
+		return nil, errNoBatching
+	}
+	key := "predictions " + strings.Join(places, ",")
+	value, err := s.get(key, func() (interface{}, error) {
+		return batcher.BatchPredictions(places)
+	})
+	batch, _ := value.(map[string][]*mbta.Prediction)
+	return batch, err
+}
+
+// SpanSchedules is an implementation of the SpanService SpanSchedules method
+// that calls s.Spans, or the wrapped service, unless it failed recently. It
+// returns errNoSpans if neither is a SpanService.
+func (s *CachingService) SpanSchedules(place, route string, day time.Time) ([]*mbta.Schedule, error) {
+	spans := s.Spans
+	if spans == nil {
+		var ok bool
+		if spans, ok = unwrapService(s.MbtaService).(SpanService); !ok {
+			return nil, errNoSpans
+		}
+	}
+	key := fmt.Sprintf("span %s %s %s", place, route, day.Format("2006-01-02"))
+	value, err := s.get(key, func() (interface{}, error) {
+		return spans.SpanSchedules(place, route, day)
+	})
+	schedules, _ := value.([]*mbta.Schedule)
+	return schedules, err
+}
+
+// get returns the result of fetch for key, or the cached failure and last
+// good value if fetch failed within the entry's ErrorTtl.
+func (s *CachingService) get(key string, fetch func() (interface{}, error)) (interface{}, error) {
+	s.mu.Lock()
+	now := clock()
+	entry, ok := s.entries[key]
+	if !ok {
+		s.dropExpired(now)
+		entry = &cacheEntry{}
+		s.entries[key] = entry
+	}
+	if oldest, ok := s.recent.use(key); ok {
+		delete(s.entries, oldest)
+	}
+	s.stats.Requests++
+	if entry.err == nil || !now.Before(entry.retryAfter) {
+		// Fetch without holding the lock so that a slow API doesn't hold up
+		// the other boards.
+		s.mu.Unlock()
+		value, err := fetch()
+		s.mu.Lock()
+		if err == nil {
+			entry.value, entry.fetched, entry.err = value, now, nil
+		} else {
+			jitter := time.Duration(rand.Int63n(int64(s.ErrorTtl) + 1))
+			entry.err, entry.retryAfter = err, now.Add(s.ErrorTtl/2+jitter)
+			log.Printf("cache: %s failed, retrying after %v: %v", key, entry.retryAfter, err)
+			statusTracker.RecordError("cache", err)
+		}
+	} else {
+		s.stats.Hits++
+	}
+	defer s.mu.Unlock()
+	if entry.err == nil {
+		return entry.value, nil
+	}
+	if entry.value != nil && now.Sub(entry.fetched) <= s.StaleTtl {
+		return entry.value, &StaleError{Err: entry.err, Since: entry.fetched}
+	}
+	return nil, entry.err
+}
+
+// dropExpired drops the entries that have neither a failure to repeat nor a
+// result recent enough to fall back on. The caller must hold s.mu.
+func (s *CachingService) dropExpired(now time.Time) {
+	for key, entry := range s.entries {
+		if !now.Before(entry.retryAfter) && now.Sub(entry.fetched) > s.StaleTtl {
+			delete(s.entries, key)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingService loads its current fixture and counts the calls made to it.
+type countingService struct {
+	fixture string
+	calls   int
+}
+
+func (s *countingService) ListDepartures(place string, filter Filter) ([]Departure, error) {
+	s.calls++
+	return (&MbtaServiceTest{s.fixture}).ListDepartures(place, filter)
+}
+
+func TestNegativeCaching(t *testing.T) {
+	defer func() { clock = time.Now }()
+	now := time.Date(2018, 9, 9, 15, 0, 0, 0, time.UTC)
+	clock = func() time.Time { return now }
+
+	upstream := &countingService{fixture: "testdata/error-429.json"}
+	service := NewCachingService(upstream)
+
+	// With nothing to fall back on, the error is returned as is, and repeated
+	// without calling upstream until it expires.
+	_, err := service.ListDepartures("place-sstat", Filter{})
+	assert.EqualError(t, err, "MBTA API error: You have exceeded your allowed usage rate.")
+	_, err = service.ListDepartures("place-sstat", Filter{})
+	assert.Error(t, err)
+	assert.Equal(t, 1, upstream.calls)
+
+	now = now.Add(service.ErrorTtl * 3 / 2)
+	upstream.fixture = "testdata/predictions.json"
+	fresh, err := service.ListDepartures("place-sstat", Filter{})
+	assert.NoError(t, err)
+	assert.Len(t, fresh, 6)
+	assert.Equal(t, 2, upstream.calls)
+	fetched := now
+
+	// Once it's failing again, the last good departures are returned as stale.
+	now = now.Add(time.Minute)
+	upstream.fixture = "testdata/error-429.json"
+	stale, err := service.ListDepartures("place-sstat", Filter{})
+	assert.Equal(t, fresh, stale)
+	if assert.IsType(t, &StaleError{}, err) {
+		assert.Equal(t, fetched, err.(*StaleError).Since)
+	}
+	assert.Equal(t, 3, upstream.calls)
+	assert.Equal(t, CacheStats{Requests: 4, Hits: 1}, service.Stats())
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/", nil)
+	board := FetchBoard(c, service, BoardDefinition{Title: "South Station", Stop: "place-sstat"})
+	assert.NoError(t, board.Error)
+	assert.Equal(t, fresh, board.Departures)
+	assert.True(t, fetched.Equal(board.StaleSince))
+	assert.Equal(t, 3, upstream.calls)
+}
+
+func TestCacheIsBounded(t *testing.T) {
+	defer func() { clock = time.Now }()
+	now := time.Date(2018, 9, 9, 15, 0, 0, 0, time.UTC)
+	clock = func() time.Time { return now }
+
+	upstream := &countingService{fixture: "testdata/predictions.json"}
+	service := NewCachingService(upstream)
+	for i := 0; i < maxCacheEntries*2; i++ {
+		service.ListDepartures("place-sstat", Filter{Window: time.Duration(i) * time.Minute})
+	}
+	assert.Len(t, service.entries, maxCacheEntries)
+
+	// Results too old to fall back on are dropped, and not shown as stale.
+	now = now.Add(service.StaleTtl + time.Minute)
+	upstream.fixture = "testdata/error-429.json"
+	departures, err := service.ListDepartures("place-sstat", Filter{Window: 199 * time.Minute})
+	assert.Nil(t, departures)
+	assert.EqualError(t, err, "MBTA API error: You have exceeded your allowed usage rate.")
+	service.ListDepartures("place-north", Filter{})
+	assert.Len(t, service.entries, 2)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Board responses carry headers letting a CDN in front of the server cache
+// them for cacheMaxAge, tagged with surrogate keys: "boards" for pages showing
+// the defined boards and "board-<stop id>" for those showing a single stop.
+// Browsers are told to revalidate every time, so only the CDN holds on to
+// them. A CachePurger watching the defined boards as they're fetched for
+// requests purges their keys through a webhook soon after they change, so the
+// CDN doesn't keep serving departures that are out of date.
+
+// cacheMaxAge is how long a CDN may cache board responses, set with
+// $CACHE_MAX_AGE.
+var cacheMaxAge = 15 * time.Second
+
+// cachePurger, if set, is told about every board fetched, set with
+// $PURGE_WEBHOOK_URL.
+var cachePurger *CachePurger
+
+// boardSurrogateKey returns the surrogate key of the pages showing a stop.
+func boardSurrogateKey(stop string) string {
+	return "board-" + stop
+}
+
+// CacheHeaders returns the gin middleware that adds caching headers to the
+// successful board responses it handles, unless the handler set them itself.
+// Requests for a stop, through either the :stop parameter, in any format, or
+// ?stop=, are keyed by it, and the others as "boards". Errors aren't cached,
+// so that the CDN asks again on the next request.
+func CacheHeaders(maxAge time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := "boards"
+		if stop := stopParam(c); stop != "" {
+			key = boardSurrogateKey(stop)
+		} else if stop := c.Query("stop"); stop != "" {
+			key = boardSurrogateKey(stop)
+		}
+		c.Writer = &cacheHeaderWriter{ResponseWriter: c.Writer, headers: map[string]string{
+			"Cache-Control": fmt.Sprintf("public, max-age=0, s-maxage=%d", int(maxAge/time.Second)),
+			"Surrogate-Key": key,
+		}}
+	}
+}
+
+// cacheHeaderWriter adds its headers to the response as it's written, if the
+// status is 200 OK and they aren't already set.
+type cacheHeaderWriter struct {
+	gin.ResponseWriter
+	headers map[string]string
+}
+
+// addHeaders adds the headers if the response hasn't been written yet.
+func (w *cacheHeaderWriter) addHeaders() {
+	if w.Written() || w.Status() != http.StatusOK {
+		return
+	}
+	for name, value := range w.headers {
+		if w.Header().Get(name) == "" {
+			w.Header().Set(name, value)
+		}
+	}
+}
+
+func (w *cacheHeaderWriter) WriteHeaderNow() {
+	w.addHeaders()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *cacheHeaderWriter) Write(data []byte) (int, error) {
+	w.addHeaders()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *cacheHeaderWriter) WriteString(s string) (int, error) {
+	w.addHeaders()
+	return w.ResponseWriter.WriteString(s)
+}
+
+// CachePurger purges the CDN's copies of the defined boards when they change,
+// by POSTing {"surrogate_keys": [...]} to a webhook with the keys also in the
+// Surrogate-Key header, as most CDNs' purge APIs take them. It doesn't fetch
+// the boards itself: it's told about them as they're fetched for requests, so
+// boards nobody is looking at cost nothing.
+type CachePurger struct {
+	Url    string
+	client *http.Client
+	defs   map[string]bool
+
+	mu      sync.Mutex
+	shown   map[string]string
+	pending map[string]bool
+}
+
+// NewCachePurger creates and returns a new CachePurger for the defined boards.
+func NewCachePurger(url string, httpClient *http.Client, defs []BoardDefinition) *CachePurger {
+	p := &CachePurger{
+		Url:     url,
+		client:  httpClient,
+		defs:    map[string]bool{},
+		shown:   map[string]string{},
+		pending: map[string]bool{},
+	}
+	for _, def := range defs {
+		p.defs[purgerKey(def)] = true
+	}
+	return p
+}
+
+// purgerKey returns the key of a board definition in a CachePurger: its stop
+// and filter, which decide the departures shown.
+func purgerKey(def BoardDefinition) string {
+	return fmt.Sprintf("%s %+v", def.Stop, def.Filter)
+}
+
+// Run purges the boards that changed every interval until done is closed.
+func (p *CachePurger) Run(interval time.Duration, done <-chan struct{}) {
+	poll(interval, done, p.Flush)
+}
+
+// Observe records a board freshly fetched for the definition and, if it's one
+// of the defined boards and differs from the one observed before, marks its
+// keys to be purged by the next Flush. Boards seen for the first time aren't
+// purged.
+func (p *CachePurger) Observe(def BoardDefinition, board *DepartureBoard) {
+	key := purgerKey(def)
+	if !p.defs[key] {
+		return
+	}
+	lines := strings.Join(boardLines(board, boardImageRows), "\n")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	last, seen := p.shown[key]
+	p.shown[key] = lines
+	if seen && last != lines {
+		p.pending[boardSurrogateKey(def.Stop)] = true
+	}
+}
+
+// Flush purges the keys of the boards that changed since the last flush.
+// They're kept to try again if the purge fails.
+func (p *CachePurger) Flush() {
+	p.mu.Lock()
+	var keys []string
+	for key := range p.pending {
+		keys = append(keys, key)
+	}
+	p.mu.Unlock()
+	if len(keys) == 0 {
+		return
+	}
+	sort.Strings(keys)
+	keys = append(keys, "boards")
+	if err := p.Purge(keys); err != nil {
+		log.Printf("cache purge: %v", err)
+		return
+	}
+	p.mu.Lock()
+	for _, key := range keys {
+		delete(p.pending, key)
+	}
+	p.mu.Unlock()
+}
+
+// Purge asks the CDN to drop the responses with the given surrogate keys.
+func (p *CachePurger) Purge(keys []string) error {
+	body, err := json.Marshal(map[string][]string{"surrogate_keys": keys})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", p.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Surrogate-Key", strings.Join(keys, " "))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New("purge webhook error: " + resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func TestCacheHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(CacheHeaders(30 * time.Second))
+	ok := func(c *gin.Context) { c.String(http.StatusOK, "") }
+	router.GET("/", ok)
+	router.GET("/board/:stop", ok)
+	router.GET("/board.gif", ok)
+
+	for path, key := range map[string]string{
+		"/":                           "boards",
+		"/board/place-bbsta":          "board-place-bbsta",
+		"/board/place-bbsta.json":     "board-place-bbsta",
+		"/board.gif?stop=place-north": "board-place-north",
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, "public, max-age=0, s-maxage=30", w.Header().Get("Cache-Control"), path)
+		assert.Equal(t, key, w.Header().Get("Surrogate-Key"), path)
+	}
+}
+
+func TestCacheHeadersOnlyOnSuccess(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(CacheHeaders(30 * time.Second))
+	router.GET("/board/:stop", func(c *gin.Context) {
+		c.String(http.StatusBadRequest, "invalid window")
+	})
+	router.GET("/board/:stop/og.png", func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=60")
+		c.String(http.StatusOK, "")
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/board/place-bbsta?window=soon", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Empty(t, w.Header().Get("Cache-Control"))
+	assert.Empty(t, w.Header().Get("Surrogate-Key"))
+
+	// Handlers can set their own lifetimes.
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/board/place-bbsta/og.png", nil))
+	assert.Equal(t, "public, max-age=60", w.Header().Get("Cache-Control"))
+	assert.Equal(t, "board-place-bbsta", w.Header().Get("Surrogate-Key"))
+}
+
+func TestCachePurgerPurgesChangedBoards(t *testing.T) {
+	defer gock.Off()
+	gock.New("https://cdn.example.com").
+		Post("/purge").
+		MatchHeader("Surrogate-Key", "^board-place-sstat boards$").
+		JSON(map[string][]string{"surrogate_keys": {"board-place-sstat", "boards"}}).
+		Reply(200)
+
+	httpClient := &http.Client{}
+	gock.InterceptClient(httpClient)
+
+	def := BoardDefinition{Title: "South Station", Stop: "place-sstat"}
+	purger := NewCachePurger("https://cdn.example.com/purge", httpClient, []BoardDefinition{def})
+	fetch := func(fixture string, def BoardDefinition) {
+		board := newBoard(def)
+		board.setDepartures((&MbtaServiceTest{fixture}).ListDepartures(def.Stop, def.Filter))
+		purger.Observe(def, board)
+	}
+
+	// Nothing is purged until a board is seen to change.
+	fetch("testdata/predictions.json", def)
+	fetch("testdata/predictions.json", def)
+	purger.Flush()
+	assert.False(t, gock.IsDone())
+
+	// Boards other than the defined ones are ignored.
+	both := def
+	both.Filter.Direction = "both"
+	fetch("testdata/predictions-delayed.json", both)
+	purger.Flush()
+	assert.False(t, gock.IsDone())
+
+	fetch("testdata/predictions-delayed.json", def)
+	purger.Flush()
+	assert.True(t, gock.IsDone())
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"time"
+)
+
+// runOnce implements the "once" subcommand, which fetches the departures for a
+// single stop from the given service, prints them to out and exits. Any
+// departures that could be parsed are printed even if an error is returned.
+func runOnce(service MbtaService, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("once", flag.ContinueOnError)
+	stop := flags.String("stop", "place-north", "MBTA stop or station ID")
+	filter := filterFlags(flags)
+	format := flags.String("format", "text", "output format (json or text)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := ValidateFormat(*format); err != nil {
+		return err
+	}
+
+	departures, err := service.ListDepartures(*stop, *filter)
+	if departures != nil {
+		if werr := WriteMessages(out, boardNotices(*stop, departures), *format); werr != nil {
+			return werr
+		}
+		if werr := WriteDepartures(out, departures, *format); werr != nil {
+			return werr
+		}
+	}
+	return err
+}
+
+// runDaemon implements the "daemon" subcommand, which polls the given service
+// until done is closed and atomically rewrites the output file with the
+// current board after each fetch. If a fetch fails the error is logged and
+// the previous file is left in place, so consumers always see the last good
+// board. It only returns an error if the arguments are invalid.
+func runDaemon(service MbtaService, args []string, done <-chan struct{}) error {
+	flags := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	stop := flags.String("stop", "place-north", "MBTA stop or station ID")
+	filter := filterFlags(flags)
+	output := flags.String("output", "", "path of the file to write")
+	format := flags.String("format", "json", "output format (json, text or grid)")
+	interval := flags.Duration("interval", 30*time.Second, "time between fetches")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *output == "" {
+		return errors.New("--output is required")
+	}
+	if err := ValidateFormat(*format); err != nil {
+		return err
+	}
+
+	poll(*interval, done, func() {
+		departures, err := service.ListDepartures(*stop, *filter)
+		if departures == nil {
+			log.Printf("daemon: fetch failed: %v", err)
+			return
+		}
+		var buf bytes.Buffer
+		if err := WriteMessages(&buf, boardNotices(*stop, departures), *format); err != nil {
+			log.Printf("daemon: %v", err)
+			return
+		}
+		if err := WriteDepartures(&buf, departures, *format); err != nil {
+			log.Printf("daemon: %v", err)
+			return
+		}
+		if err := writeFileAtomic(*output, buf.Bytes()); err != nil {
+			log.Printf("daemon: %v", err)
+		}
+	})
+	return nil
+}
+
+// boardNotices returns the operator messages for the stop followed by the
+// current item of the rotating slot, for the outputs that show them ahead of
+// the departures.
+func boardNotices(stop string, departures []Departure) []string {
+	now := clock()
+	notices := boardMessages.Active(stop, now)
+	board := &DepartureBoard{Departures: departures}
+	if r := NewRotation(rotationItems(board, now), rotationInterval, now); r != nil {
+		notices = append(notices, r.Item)
+	}
+	return notices
+}
+
+// filterFlags registers the flags shared by the subcommands for narrowing down
+// the departures shown, and returns the Filter they populate.
+func filterFlags(flags *flag.FlagSet) *Filter {
+	filter := new(Filter)
+	flags.DurationVar(&filter.Window, "window", 0,
+		"only show departures within this long from now (0 for no limit)")
+	flags.StringVar(&filter.Direction, "direction", "",
+		"direction of departures to show: outbound (default), inbound or both")
+	return filter
+}
+
+// BoardUpdate is a single line of the JSON Lines output of the "stream"
+// subcommand. Recordings also have the filter of the board.
+type BoardUpdate struct {
+	Time       time.Time   `json:"time"`
+	Stop       string      `json:"stop"`
+	Filter     *Filter     `json:"filter,omitempty"`
+	Departures []Departure `json:"departures"`
+	Messages   []string    `json:"messages,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// runStream implements the "stream" subcommand, which polls the given service
+// until done is closed and writes a BoardUpdate line to out whenever the
+// departures, messages or error differ from the previous fetch.
+func runStream(service MbtaService, args []string, out io.Writer, done <-chan struct{}) error {
+	flags := flag.NewFlagSet("stream", flag.ContinueOnError)
+	stop := flags.String("stop", "place-north", "MBTA stop or station ID")
+	filter := filterFlags(flags)
+	interval := flags.Duration("interval", 30*time.Second, "time between fetches")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(out)
+	var last *BoardUpdate
+	var writeErr error
+	poll(*interval, done, func() {
+		if writeErr != nil {
+			return
+		}
+		departures, err := service.ListDepartures(*stop, *filter)
+		update := &BoardUpdate{
+			Time:       clock(),
+			Stop:       *stop,
+			Departures: departures,
+			Messages:   boardNotices(*stop, departures),
+		}
+		if err != nil {
+			update.Error = err.Error()
+		}
+		if last != nil && reflect.DeepEqual(last.Departures, update.Departures) &&
+			reflect.DeepEqual(last.Messages, update.Messages) &&
+			last.Error == update.Error {
+			return
+		}
+		last = update
+		writeErr = enc.Encode(update)
+	})
+	return writeErr
+}
+
+// poll calls fetch immediately and then once every interval until done is
+// closed.
+func poll(interval time.Duration, done <-chan struct{}, fetch func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		fetch()
+		// Check done on its own first, since select picks at random when
+		// the ticker has also fired.
+		select {
+		case <-done:
+			return
+		default:
+		}
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// interrupted returns a channel that is closed when the process receives
+// SIGINT or SIGTERM, for use as the done channel of the long-running modes.
+func interrupted() <-chan struct{} {
+	done := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(done)
+	}()
+	return done
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory as
+// path and renames it into place, so readers never observe a partial write.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
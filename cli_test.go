@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnceText(t *testing.T) {
+	var out bytes.Buffer
+	err := runOnce(&MbtaServiceTest{"testdata/predictions.json"},
+		[]string{"--stop", "place-sstat"}, &out)
+	assert.NoError(t, err)
+
+	expected := "TIME     DESTINATION     TRACK  STATUS\n" +
+		"11:50AM  Readville       TBD    \n" +
+		"11:50AM  Readville       10     Now boarding\n" +
+		"12:40PM  Worcester       TBD    On time\n" +
+		"12:50PM  Readville       TBD    On time\n" +
+		"1:05PM   Providence      TBD    On time\n" +
+		"1:20PM   Forge Park/495  TBD    On time\n"
+	assert.Equal(t, expected, out.String())
+}
+
+func TestOnceJson(t *testing.T) {
+	var out bytes.Buffer
+	err := runOnce(&MbtaServiceTest{"testdata/predictions.json"},
+		[]string{"--format", "json"}, &out)
+	assert.NoError(t, err)
+
+	var departures []Departure
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &departures))
+	assert.Len(t, departures, 6)
+	assert.Equal(t, Departure{TimeLabel: "11:50AM", Route: "CR-Fairmount", Line: "Fairmount Line", Trip: "CR-Sunday-Spring-18-2761", Destination: "Readville", Track: "10", Status: "Now boarding", Direction: "Outbound", Time: at("2018-09-09T11:50:00-04:00")}, departures[1])
+}
+
+func TestOnceError(t *testing.T) {
+	var out bytes.Buffer
+	err := runOnce(&MbtaServiceTest{"testdata/error-429.json"}, nil, &out)
+	assert.EqualError(t, err, "MBTA API error: You have exceeded your allowed usage rate.")
+	assert.Empty(t, out.String())
+
+	err = runOnce(&MbtaServiceTest{"testdata/predictions.json"},
+		[]string{"--format", "xml"}, &out)
+	assert.EqualError(t, err, `unknown output format "xml"`)
+}
+
+func TestDaemon(t *testing.T) {
+	dir, err := ioutil.TempDir("", "splitflap")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	done := make(chan struct{})
+	close(done)
+	output := filepath.Join(dir, "board.txt")
+	err = runDaemon(&MbtaServiceTest{"testdata/predictions.json"},
+		[]string{"--output", output, "--format", "grid"}, done)
+	assert.NoError(t, err)
+
+	contents, err := ioutil.ReadFile(output)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+	assert.Len(t, lines, 6)
+	assert.Equal(t, "11:50AM READVILLE          10    NOW BOARDING", lines[1])
+	assert.Equal(t, "1:20PM  FORGE PARK/495     TBD   ON TIME     ", lines[5])
+
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1, "temporary files should be cleaned up")
+}
+
+func TestDaemonKeepsLastGoodBoard(t *testing.T) {
+	dir, err := ioutil.TempDir("", "splitflap")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	output := filepath.Join(dir, "board.json")
+	assert.NoError(t, ioutil.WriteFile(output, []byte("[]\n"), 0644))
+
+	done := make(chan struct{})
+	close(done)
+	// Failed fetches are logged rather than ending the daemon with an error.
+	err = runDaemon(&MbtaServiceTest{"testdata/error-429.json"},
+		[]string{"--output", output}, done)
+	assert.NoError(t, err)
+
+	contents, err := ioutil.ReadFile(output)
+	assert.NoError(t, err)
+	assert.Equal(t, "[]\n", string(contents))
+}
+
+// sequenceService returns each of its fixtures in turn, then closes done.
+type sequenceService struct {
+	fixtures []string
+	done     chan struct{}
+}
+
+func (s *sequenceService) ListDepartures(place string, filter Filter) ([]Departure, error) {
+	fixture := s.fixtures[0]
+	s.fixtures = s.fixtures[1:]
+	if len(s.fixtures) == 0 {
+		close(s.done)
+	}
+	return (&MbtaServiceTest{fixture}).ListDepartures(place, filter)
+}
+
+func TestStreamOnlyEmitsChanges(t *testing.T) {
+	defer func() { clock = time.Now }()
+	clock = func() time.Time { return at("2018-09-09T11:45:00-04:00") }
+	service := &sequenceService{
+		fixtures: []string{
+			"testdata/predictions.json",
+			"testdata/predictions.json",
+			"testdata/error-429.json",
+			"testdata/predictions.json",
+		},
+		done: make(chan struct{}),
+	}
+	var out bytes.Buffer
+	err := runStream(service, []string{"--stop", "place-sstat", "--interval", "1ms"},
+		&out, service.done)
+	assert.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	assert.Len(t, lines, 3)
+	var updates []BoardUpdate
+	for _, line := range lines {
+		var update BoardUpdate
+		assert.NoError(t, json.Unmarshal([]byte(line), &update))
+		updates = append(updates, update)
+	}
+	assert.Equal(t, "place-sstat", updates[0].Stop)
+	assert.True(t, at("2018-09-09T11:45:00-04:00").Equal(updates[0].Time))
+	assert.Len(t, updates[0].Departures, 6)
+	assert.Empty(t, updates[0].Error)
+	assert.Nil(t, updates[1].Departures)
+	assert.Equal(t, "MBTA API error: You have exceeded your allowed usage rate.", updates[1].Error)
+	assert.Len(t, updates[2].Departures, 6)
+}
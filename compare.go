@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// validLayout reports whether layout is a valid board layout: "" for the
+// predicted times only, or "compare" to show the scheduled times and delays
+// as well.
+func validLayout(layout string) bool {
+	switch layout {
+	case "", "compare":
+		return true
+	default:
+		return false
+	}
+}
+
+// ScheduledLabel returns the scheduled departure time as shown on boards, or
+// an empty string if it's unknown.
+func (d Departure) ScheduledLabel() string {
+	if d.ScheduledTime.IsZero() {
+		return ""
+	}
+	return serviceTimeLabel(d.ScheduledTime)
+}
+
+// DelayLabel returns how far the predicted departure time is from the
+// scheduled one, to the nearest minute, e.g. "+4 min" or "-1 min". It's empty
+// unless both times are known.
+func (d Departure) DelayLabel() string {
+	if d.Time.IsZero() || d.ScheduledTime.IsZero() {
+		return ""
+	}
+	minutes := int(d.Time.Sub(d.ScheduledTime).Round(time.Minute) / time.Minute)
+	if minutes == 0 {
+		return "0 min"
+	}
+	return fmt.Sprintf("%+d min", minutes)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelayLabel(t *testing.T) {
+	scheduled := at("2018-09-10T17:00:00-04:00")
+	for predicted, label := range map[string]string{
+		"2018-09-10T17:04:10-04:00": "+4 min",
+		"2018-09-10T16:59:00-04:00": "-1 min",
+		"2018-09-10T17:00:20-04:00": "0 min",
+	} {
+		d := Departure{Time: at(predicted), ScheduledTime: scheduled}
+		assert.Equal(t, label, d.DelayLabel(), predicted)
+		assert.Equal(t, "5:00PM", d.ScheduledLabel())
+	}
+	assert.Equal(t, "", Departure{Time: scheduled}.DelayLabel())
+	assert.Equal(t, "", Departure{Time: scheduled}.ScheduledLabel())
+}
+
+func TestCompareLayout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	LoadTemplates(router, "templates")
+	router.GET("/board/:stop", func(c *gin.Context) {
+		RenderBoard(c, &MbtaServiceTest{"testdata/predictions-delayed.json"}, Filter{})
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/board/place-sstat", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "<th>Scheduled</th>")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/board/place-sstat?layout=compare", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<th>Scheduled</th><th>Predicted</th><th>Delay</th>")
+	assert.Contains(t, w.Body.String(), `<td class="scheduled">9:05AM</td>`)
+	// html/template escapes the plus sign.
+	assert.Contains(t, w.Body.String(), `<td class="delay">&#43;90 min</td>`)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/board/place-sstat?layout=wide", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, `invalid layout "wide"`, w.Body.String())
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+)
+
+// devMode is set by the --dev flag. Templates are reloaded from disk on every
+// request, static assets aren't cached by the browser, errors are shown in
+// full and, in binaries built with the dev tag, the fixture routes are enabled.
+var devMode bool
+
+// devMiddleware returns the middleware used in development mode: it stops
+// browsers caching responses, so edits to static assets show up on reload,
+// and replaces panics (including template errors) with a page showing the
+// error and stack trace.
+func devMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "no-store")
+		defer func() {
+			if err := recover(); err != nil {
+				stack := debug.Stack()
+				log.Printf("panic: %v\n%s", err, stack)
+				c.Data(http.StatusInternalServerError, "text/plain; charset=utf-8",
+					[]byte(fmt.Sprintf("%v\n\n%s", err, stack)))
+				c.Abort()
+			}
+		}()
+		c.Next()
+	}
+}
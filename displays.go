@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v2"
+)
+
+// Remote displays, such as a Raspberry Pi driving a screen or a split-flap
+// display, connect to /display over a WebSocket and exchange JSON
+// displayMessages:
+//
+//  1. The display registers with its name: {"type": "register", "name": "lobby"}
+//  2. The server replies {"type": "registered", "heartbeat_seconds": 30}, or
+//     {"type": "error"} and closes the connection if the name is unknown.
+//  3. The server pushes {"type": "frame"} messages with the rendered board
+//     whenever it changes.
+//  4. The display sends {"type": "heartbeat"} at least every
+//     heartbeat_seconds, or it's disconnected.
+//
+// All of the board logic stays on the server, so displays only need to draw
+// what they're sent.
+
+// DisplayConfig is the configuration of a remote display. Format is "grid"
+// (the default) to send frames as lines of the character grid, or "png" to
+// also send an image of the board. Rows is the number of departures shown.
+type DisplayConfig struct {
+	Name   string            `yaml:"name"`
+	Board  TenantBoardConfig `yaml:"board"`
+	Format string            `yaml:"format"`
+	Rows   int               `yaml:"rows"`
+}
+
+// LoadDisplays reads and validates a YAML file with a list of displays.
+func LoadDisplays(path string) ([]DisplayConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		Displays []DisplayConfig `yaml:"displays"`
+	}
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	seen := map[string]bool{}
+	for i, d := range config.Displays {
+		if d.Name == "" || seen[d.Name] {
+			return nil, fmt.Errorf("%s: display names must be set and unique", path)
+		}
+		seen[d.Name] = true
+		switch d.Format {
+		case "":
+			config.Displays[i].Format = "grid"
+		case "grid", "png":
+		default:
+			return nil, fmt.Errorf("%s: display %q: invalid format %q", path, d.Name, d.Format)
+		}
+		if d.Rows <= 0 {
+			config.Displays[i].Rows = boardImageRows
+		}
+		if _, err := (TenantConfig{Boards: []TenantBoardConfig{d.Board}}).Definitions(); err != nil {
+			return nil, fmt.Errorf("%s: display %q: %v", path, d.Name, err)
+		}
+	}
+	return config.Displays, nil
+}
+
+// displayMessage is a message of the display protocol, in either direction.
+// Frames have the Lines of the board as they'd appear in the grid format,
+// starting with the title, any Notices to show with them, and an Image if the
+// display asked for PNGs.
+type displayMessage struct {
+	Type             string    `json:"type"`
+	Name             string    `json:"name,omitempty"`
+	Error            string    `json:"error,omitempty"`
+	HeartbeatSeconds int       `json:"heartbeat_seconds,omitempty"`
+	Time             time.Time `json:"time,omitzero"`
+	Lines            []string  `json:"lines,omitempty"`
+	Notices          []string  `json:"notices,omitempty"`
+	Image            []byte    `json:"image,omitempty"`
+}
+
+// DisplayStatus describes a display, which is connected if Connected is set.
+type DisplayStatus struct {
+	Name      string    `json:"name"`
+	Connected time.Time `json:"connected,omitzero"`
+	LastSeen  time.Time `json:"last_seen,omitzero"`
+}
+
+// displayHub is the hub serving the displays configured in $DISPLAYS, if any,
+// for the status page.
+var displayHub *DisplayHub
+
+// DisplayHub serves frames to the remote displays, refreshing them every
+// Interval from the service. Each board is fetched at most once an Interval
+// however many displays show it. Displays that go Heartbeat without sending
+// anything are disconnected.
+type DisplayHub struct {
+	Interval  time.Duration
+	Heartbeat time.Duration
+	service   MbtaService
+	displays  map[string]DisplayConfig
+
+	mu        sync.Mutex
+	connected map[*wsConn]*DisplayStatus
+
+	fetchMu sync.Mutex
+	boards  map[string]*fetchedBoard
+}
+
+// fetchedBoard is a board fetched for the displays, and when it was fetched.
+type fetchedBoard struct {
+	board   *DepartureBoard
+	fetched time.Time
+}
+
+// NewDisplayHub creates and returns a hub for the configured displays.
+func NewDisplayHub(service MbtaService, displays []DisplayConfig) *DisplayHub {
+	h := &DisplayHub{
+		Interval:  15 * time.Second,
+		Heartbeat: 30 * time.Second,
+		service:   service,
+		displays:  map[string]DisplayConfig{},
+		connected: map[*wsConn]*DisplayStatus{},
+		boards:    map[string]*fetchedBoard{},
+	}
+	for _, d := range displays {
+		h.displays[d.Name] = d
+	}
+	return h
+}
+
+// Statuses returns the status of every configured display, connected or not,
+// sorted by name.
+func (h *DisplayHub) Statuses() []DisplayStatus {
+	statuses := h.Connected()
+	connected := map[string]bool{}
+	for _, s := range statuses {
+		connected[s.Name] = true
+	}
+	for name := range h.displays {
+		if !connected[name] {
+			statuses = append(statuses, DisplayStatus{Name: name})
+		}
+	}
+	sort.SliceStable(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// Connected returns the connected displays, sorted by name.
+func (h *DisplayHub) Connected() []DisplayStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	statuses := []DisplayStatus{}
+	for _, s := range h.connected {
+		statuses = append(statuses, *s)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// Serve runs the display protocol on a connection until the display
+// disconnects or stops sending heartbeats.
+func (h *DisplayHub) Serve(conn *wsConn) {
+	defer conn.Close()
+	display, err := h.register(conn)
+	if err != nil {
+		log.Printf("displays: %v", err)
+		h.send(conn, displayMessage{Type: "error", Error: err.Error()})
+		return
+	}
+	now := clock()
+	h.mu.Lock()
+	h.connected[conn] = &DisplayStatus{Name: display.Name, Connected: now, LastSeen: now}
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		delete(h.connected, conn)
+		h.mu.Unlock()
+	}()
+	if err := h.send(conn, displayMessage{Type: "registered", Name: display.Name,
+		HeartbeatSeconds: int(h.Heartbeat / time.Second)}); err != nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, err := h.read(conn); err != nil {
+				return
+			}
+			h.mu.Lock()
+			h.connected[conn].LastSeen = clock()
+			h.mu.Unlock()
+		}
+	}()
+	var last *displayMessage
+	poll(h.Interval, done, func() {
+		frame := h.frame(display)
+		if last != nil && reflect.DeepEqual(last.Lines, frame.Lines) &&
+			reflect.DeepEqual(last.Notices, frame.Notices) {
+			return
+		}
+		last = &frame
+		if err := h.send(conn, frame); err != nil {
+			// Closing the connection ends the reader, and with it the poll.
+			conn.Close()
+		}
+	})
+}
+
+// register reads the display's registration and returns its configuration.
+func (h *DisplayHub) register(conn *wsConn) (DisplayConfig, error) {
+	m, err := h.read(conn)
+	if err != nil {
+		return DisplayConfig{}, err
+	}
+	if m.Type != "register" {
+		return DisplayConfig{}, fmt.Errorf("expected register, got %q", m.Type)
+	}
+	display, ok := h.displays[m.Name]
+	if !ok {
+		return DisplayConfig{}, fmt.Errorf("unknown display %q", m.Name)
+	}
+	return display, nil
+}
+
+// read reads the next message from the display, waiting at most a heartbeat.
+func (h *DisplayHub) read(conn *wsConn) (displayMessage, error) {
+	var m displayMessage
+	conn.conn.SetReadDeadline(time.Now().Add(h.Heartbeat))
+	data, err := conn.ReadMessage()
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, errors.New("invalid message: " + err.Error())
+	}
+	return m, nil
+}
+
+// send writes a message to the display.
+func (h *DisplayHub) send(conn *wsConn, m displayMessage) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(data)
+}
+
+// board returns the board for the definition, fetching it from the service
+// unless it was fetched for another display within the last Interval.
+func (h *DisplayHub) board(def BoardDefinition) *DepartureBoard {
+	key := fmt.Sprintf("%+v", def)
+	now := clock()
+	h.fetchMu.Lock()
+	defer h.fetchMu.Unlock()
+	if b, ok := h.boards[key]; ok && now.Sub(b.fetched) < h.Interval {
+		return b.board
+	}
+	board := newBoard(def)
+	board.setDepartures(h.service.ListDepartures(def.Stop, def.Filter))
+	h.boards[key] = &fetchedBoard{board, now}
+	return board
+}
+
+// frame renders the display's board.
+func (h *DisplayHub) frame(display DisplayConfig) displayMessage {
+	defs, _ := (TenantConfig{Boards: []TenantBoardConfig{display.Board}}).Definitions()
+	def := defs[0]
+	board := h.board(def)
+	frame := displayMessage{
+		Type:    "frame",
+		Time:    clock(),
+		Lines:   boardLines(board, display.Rows),
+		Notices: boardNotices(def.Stop, board.Departures),
+	}
+	if display.Format == "png" {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, drawBoard(frame.Lines, boardImageScale)); err != nil {
+			log.Printf("displays: %v", err)
+		} else {
+			frame.Image = buf.Bytes()
+		}
+	}
+	return frame
+}
+
+// RegisterDisplayRoutes adds the WebSocket endpoint for remote displays to
+// the router and, if token is set, a list of the connected displays that
+// requires it.
+func RegisterDisplayRoutes(router gin.IRouter, token string, hub *DisplayHub) {
+	router.GET("/display", func(c *gin.Context) {
+		conn, err := upgradeWebsocket(c.Writer, c.Request)
+		if err != nil {
+			log.Printf("displays: %v", err)
+			return
+		}
+		hub.Serve(conn)
+	})
+	if token != "" {
+		router.GET("/api/v1/displays", requireToken(token), func(c *gin.Context) {
+			c.JSON(http.StatusOK, hub.Connected())
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// dialDisplay opens a WebSocket connection to the server's /display endpoint.
+func dialDisplay(t *testing.T, server *httptest.Server) *wsConn {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	conn.Write([]byte("GET /display HTTP/1.1\r\nHost: splitflap\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	resp, err := http.ReadResponse(rw.Reader, nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	if !assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode) {
+		t.FailNow()
+	}
+	if !assert.Equal(t, wsAcceptKey(key), resp.Header.Get("Sec-WebSocket-Accept")) {
+		t.FailNow()
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &wsConn{conn: conn, rw: rw, client: true}
+}
+
+func exchange(t *testing.T, conn *wsConn, m displayMessage) displayMessage {
+	data, _ := json.Marshal(m)
+	if !assert.NoError(t, conn.WriteMessage(data)) {
+		t.FailNow()
+	}
+	return receive(t, conn)
+}
+
+func receive(t *testing.T, conn *wsConn) displayMessage {
+	data, err := conn.ReadMessage()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	var m displayMessage
+	if !assert.NoError(t, json.Unmarshal(data, &m)) {
+		t.FailNow()
+	}
+	return m
+}
+
+func newDisplayServer(t *testing.T) (*httptest.Server, *DisplayHub) {
+	displays, err := LoadDisplays("testdata/displays.yaml")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	hub := NewDisplayHub(&MbtaServiceTest{"testdata/predictions.json"}, displays)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	RegisterDisplayRoutes(router, "secret", hub)
+	return httptest.NewServer(router), hub
+}
+
+func TestLoadDisplays(t *testing.T) {
+	displays, err := LoadDisplays("testdata/displays.yaml")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	if !assert.Len(t, displays, 2) {
+		t.FailNow()
+	}
+	assert.Equal(t, "grid", displays[0].Format)
+	assert.Equal(t, boardImageRows, displays[0].Rows)
+	assert.Equal(t, "png", displays[1].Format)
+	assert.Equal(t, 3, displays[1].Rows)
+}
+
+func TestDisplayReceivesFrames(t *testing.T) {
+	defer func() { clock = time.Now }()
+	clock = func() time.Time { return at("2018-09-10T17:00:00-04:00") }
+	server, hub := newDisplayServer(t)
+	defer server.Close()
+
+	conn := dialDisplay(t, server)
+	defer conn.Close()
+	m := exchange(t, conn, displayMessage{Type: "register", Name: "lobby"})
+	assert.Equal(t, "registered", m.Type)
+	assert.Equal(t, 30, m.HeartbeatSeconds)
+
+	frame := receive(t, conn)
+	assert.Equal(t, "frame", frame.Type)
+	if !assert.Len(t, frame.Lines, 1+boardImageRows) {
+		t.FailNow()
+	}
+	assert.Contains(t, frame.Lines[0], "TRAINS FROM SOUTH STATION")
+	assert.Nil(t, frame.Image)
+
+	assert.Equal(t, []DisplayStatus{{Name: "lobby",
+		Connected: clock(), LastSeen: clock()}}, hub.Connected())
+	assert.Equal(t, []DisplayStatus{{Name: "lobby", Connected: clock(), LastSeen: clock()},
+		{Name: "platform"}}, hub.Statuses())
+
+	w := httptest.NewRecorder()
+	router := gin.New()
+	RegisterDisplayRoutes(router, "secret", hub)
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/displays", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestPngDisplayReceivesImages(t *testing.T) {
+	server, _ := newDisplayServer(t)
+	defer server.Close()
+
+	conn := dialDisplay(t, server)
+	defer conn.Close()
+	assert.Equal(t, "registered", exchange(t, conn, displayMessage{Type: "register", Name: "platform"}).Type)
+	frame := receive(t, conn)
+	assert.Len(t, frame.Lines, 4)
+	assert.True(t, strings.HasPrefix(string(frame.Image), "\x89PNG"))
+}
+
+func TestUnknownDisplayIsRejected(t *testing.T) {
+	server, hub := newDisplayServer(t)
+	defer server.Close()
+
+	conn := dialDisplay(t, server)
+	defer conn.Close()
+	m := exchange(t, conn, displayMessage{Type: "register", Name: "attic"})
+	assert.Equal(t, "error", m.Type)
+	assert.Equal(t, `unknown display "attic"`, m.Error)
+	_, err := conn.ReadMessage()
+	assert.Error(t, err)
+	assert.Empty(t, hub.Connected())
+}
+
+func TestDisplaysShareFetches(t *testing.T) {
+	defer func() { clock = time.Now }()
+	now := at("2018-09-10T17:00:00-04:00")
+	clock = func() time.Time { return now }
+	displays, err := LoadDisplays("testdata/displays.yaml")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	upstream := &countingService{fixture: "testdata/predictions.json"}
+	hub := NewDisplayHub(upstream, displays)
+
+	// Two displays of the same board only fetch it once an interval.
+	lobby := displays[0]
+	hub.frame(lobby)
+	hub.frame(lobby)
+	assert.Equal(t, 1, upstream.calls)
+	now = now.Add(hub.Interval)
+	hub.frame(lobby)
+	assert.Equal(t, 2, upstream.calls)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mattmckeon/splitflap/internal/mbta"
+)
+
+// Results of the doctor's checks. Warnings are problems that don't stop the
+// server working, such as running without an API key.
+const (
+	checkPass = "PASS"
+	checkWarn = "WARN"
+	checkFail = "FAIL"
+)
+
+// doctorCheck is the result of one of the doctor's checks.
+type doctorCheck struct {
+	Name   string
+	Result string
+	Detail string
+}
+
+// doctorConfigFiles are the environment variables naming configuration files
+// the doctor validates, with the function loading each.
+var doctorConfigFiles = []struct {
+	env  string
+	load func(path string) error
+}{
+	{"RULES", func(path string) error { _, err := LoadRules(path); return err }},
+	{"DISPLAYS", func(path string) error { _, err := LoadDisplays(path); return err }},
+	{"TENANTS", func(path string) error { _, err := LoadTenants(path); return err }},
+	{"MERGED_BOARDS", func(path string) error { _, err := LoadMergedBoards(path); return err }},
+	{"GTFS_STOPS", func(path string) error { _, err := LoadFareZones(path); return err }},
+}
+
+// runDoctor implements the "doctor" subcommand, which checks that the server
+// can run with the current environment and prints a report of each check to
+// out: that the MBTA API can be reached and accepts the API key, that the
+// boards' stops exist, that the templates parse and the static assets are in
+// place, that the configuration files are valid and, with --notify, that a
+// test notification can be sent. It returns an error if any check failed.
+func runDoctor(service *MbtaServiceImpl, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	templates := flags.String("templates", "templates", "directory of the HTML templates")
+	static := flags.String("static", "static", "directory of the static assets")
+	notify := flags.Bool("notify", false, "send a test notification to $NOTIFY_WEBHOOK_URL")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var checks []doctorCheck
+	checks = append(checks, checkMbta(service, doctorStops())...)
+	checks = append(checks, checkTemplates(*templates), checkStatic(*static))
+	for _, file := range doctorConfigFiles {
+		if path := os.Getenv(file.env); path != "" {
+			checks = append(checks, resultOf("$"+file.env, path, file.load(path)))
+		}
+	}
+	if url := os.Getenv("NOTIFY_WEBHOOK_URL"); url != "" && *notify {
+		err := ConfiguredNotifier(url).Notify(Notification{
+			Title:   "splitflap doctor",
+			Message: "This is a test notification.",
+		})
+		checks = append(checks, resultOf("Notifications", "test notification sent", err))
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	failed := 0
+	for _, c := range checks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", c.Result, c.Name, c.Detail)
+		if c.Result == checkFail {
+			failed++
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	fmt.Fprintf(out, "\nAll %d checks passed\n", len(checks))
+	return nil
+}
+
+// resultOf returns a passing check with the detail if err is nil, and a
+// failing one with the error otherwise.
+func resultOf(name, detail string, err error) doctorCheck {
+	if err != nil {
+		return doctorCheck{name, checkFail, err.Error()}
+	}
+	return doctorCheck{name, checkPass, detail}
+}
+
+// doctorStops returns the stops the server is configured to show: the main
+// page's boards and any in $PRECOMPUTE_STOPS and $OUTAGE_STATIONS.
+func doctorStops() []string {
+	stops := []string{}
+	seen := map[string]bool{}
+	add := func(stop string) {
+		if stop = strings.TrimSpace(stop); stop != "" && !seen[stop] {
+			seen[stop] = true
+			stops = append(stops, stop)
+		}
+	}
+	for _, def := range DefaultBoards {
+		add(def.Stop)
+	}
+	for _, env := range []string{"PRECOMPUTE_STOPS", "OUTAGE_STATIONS"} {
+		for _, stop := range strings.Split(os.Getenv(env), ",") {
+			add(stop)
+		}
+	}
+	return stops
+}
+
+// checkMbta checks that the API can be reached and accepts the API key, and
+// that the stops exist, with a single request for the stops.
+func checkMbta(service *MbtaServiceImpl, stops []string) []doctorCheck {
+	found, err := service.mbta.Stops(mbta.Filter("id", stops...))
+	var apiErr *mbta.Error
+	if e, ok := err.(*mbta.Error); ok {
+		apiErr = e
+	}
+	switch {
+	case apiErr != nil && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden):
+		return []doctorCheck{
+			{"MBTA API", checkPass, "reachable"},
+			{"API key", checkFail, "rejected: " + err.Error()},
+		}
+	case err != nil:
+		return []doctorCheck{{"MBTA API", checkFail, err.Error()}}
+	}
+
+	checks := []doctorCheck{{"MBTA API", checkPass, "reachable"}}
+	key := doctorCheck{"API key", checkPass, "accepted"}
+	if os.Getenv("API_KEY") == "" {
+		key = doctorCheck{"API key", checkWarn, "$API_KEY isn't set, so requests are heavily rate limited"}
+	}
+	if limit, ok := service.mbta.RateLimit(); ok {
+		key.Detail += fmt.Sprintf(" (limit %d requests)", limit.Limit)
+	}
+	checks = append(checks, key)
+	names := map[string]string{}
+	for _, s := range found {
+		names[s.Id] = s.Name
+	}
+	for _, stop := range stops {
+		if name, ok := names[stop]; ok {
+			checks = append(checks, doctorCheck{"Stop " + stop, checkPass, name})
+		} else {
+			checks = append(checks, doctorCheck{"Stop " + stop, checkFail, "unknown stop ID"})
+		}
+	}
+	return checks
+}
+
+// checkTemplates checks that the HTML templates in dir parse.
+func checkTemplates(dir string) doctorCheck {
+	t, err := template.New("").Funcs(TemplateFuncs).ParseGlob(filepath.Join(dir, "*.tmpl.html"))
+	if err != nil {
+		return resultOf("Templates", "", err)
+	}
+	for _, name := range []string{"index.tmpl.html", "schedule.tmpl.html", "train.tmpl.html"} {
+		if t.Lookup(name) == nil {
+			return resultOf("Templates", "", errors.New("missing "+name))
+		}
+	}
+	return resultOf("Templates", fmt.Sprintf("%d templates in %s", len(t.Templates())-1, dir), nil)
+}
+
+// checkStatic checks that the static assets the pages load are in dir.
+func checkStatic(dir string) doctorCheck {
+	assets := []string{"main.css", "descrambler.js"}
+	for _, name := range assets {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			return resultOf("Static assets", "", err)
+		}
+	}
+	return resultOf("Static assets", fmt.Sprintf("%d assets in %s", len(assets), dir), nil)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func newDoctorTestService() *MbtaServiceImpl {
+	httpClient := &http.Client{}
+	gock.InterceptClient(httpClient)
+	return NewMbtaServiceImplWithKey(httpClient, "")
+}
+
+func TestDoctor(t *testing.T) {
+	defer gock.Off()
+	defer os.Unsetenv("PRECOMPUTE_STOPS")
+	defer os.Unsetenv("RULES")
+	os.Setenv("PRECOMPUTE_STOPS", "place-bbsta, place-nowhere")
+	os.Setenv("RULES", "testdata/rules.yaml")
+	gock.New(MbtaApiV3BaseUrl).
+		Get("/stops").
+		MatchParam("filter[id]", "^place-north,place-sstat,place-bbsta,place-nowhere$").
+		Reply(200).
+		SetHeader("x-ratelimit-limit", "20").
+		JSON(map[string]interface{}{"data": []interface{}{
+			map[string]interface{}{"type": "stop", "id": "place-north", "attributes": map[string]interface{}{"name": "North Station"}},
+			map[string]interface{}{"type": "stop", "id": "place-sstat", "attributes": map[string]interface{}{"name": "South Station"}},
+			map[string]interface{}{"type": "stop", "id": "place-bbsta", "attributes": map[string]interface{}{"name": "Back Bay"}},
+		}})
+
+	var out bytes.Buffer
+	err := runDoctor(newDoctorTestService(), nil, &out)
+	assert.EqualError(t, err, "1 of 9 checks failed")
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	for i, prefix := range []string{
+		"PASS  MBTA API",
+		"WARN  API key",
+		"PASS  Stop place-north   North Station",
+		"PASS  Stop place-sstat   South Station",
+		"PASS  Stop place-bbsta   Back Bay",
+		"FAIL  Stop place-nowhere  unknown stop ID",
+		"PASS  Templates",
+		"PASS  Static assets",
+		"PASS  $RULES",
+	} {
+		if assert.True(t, i < len(lines)) {
+			assert.True(t, strings.HasPrefix(strings.Join(strings.Fields(lines[i]), " "),
+				strings.Join(strings.Fields(prefix), " ")), lines[i])
+		}
+	}
+	assert.Contains(t, out.String(), "(limit 20 requests)")
+}
+
+func TestDoctorApiFailures(t *testing.T) {
+	defer gock.Off()
+	gock.New(MbtaApiV3BaseUrl).
+		Get("/stops").
+		Reply(403).
+		JSON(map[string]interface{}{"errors": []interface{}{
+			map[string]interface{}{"status": "403", "code": "forbidden", "detail": "Invalid API key"},
+		}})
+
+	var out bytes.Buffer
+	assert.Error(t, runDoctor(newDoctorTestService(), nil, &out))
+	assert.Contains(t, out.String(), "FAIL  API key")
+	assert.Contains(t, out.String(), "Invalid API key")
+
+	gock.New(MbtaApiV3BaseUrl).
+		Get("/stops").
+		Reply(502)
+	out.Reset()
+	assert.Error(t, runDoctor(newDoctorTestService(), nil, &out))
+	assert.True(t, strings.HasPrefix(out.String(), "FAIL  MBTA API"), out.String())
+	assert.Contains(t, out.String(), "MBTA API error: HTTP 502")
+}
+
+func TestDoctorAssets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "doctor")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	assert.Equal(t, checkFail, checkStatic(dir).Result)
+	assert.Equal(t, checkFail, checkTemplates(dir).Result)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "index.tmpl.html"), []byte("{{.Broken"), 0644))
+	check := checkTemplates(dir)
+	assert.Equal(t, checkFail, check.Result)
+	assert.Contains(t, check.Detail, "index.tmpl.html")
+
+	files, _ := filepath.Glob("templates/*.tmpl.html")
+	assert.Equal(t, doctorCheck{"Templates", checkPass, fmt.Sprintf("%d templates in templates", len(files))},
+		checkTemplates("templates"))
+}
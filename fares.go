@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// crZonePrefix is the prefix of the GTFS zone_id of commuter rail fare zones,
+// e.g. "CR-zone-4".
+const crZonePrefix = "CR-zone-"
+
+// zoneFares are the one-way commuter rail fares to or from Boston (zone 1A),
+// by zone. Fares between two outer zones are lower; these are only a hint for
+// visitors.
+var zoneFares = map[string]string{
+	"1A": "$2.40",
+	"1":  "$6.50",
+	"2":  "$7.00",
+	"3":  "$8.00",
+	"4":  "$8.75",
+	"5":  "$9.75",
+	"6":  "$10.50",
+	"7":  "$11.00",
+	"8":  "$12.25",
+	"9":  "$12.75",
+	"10": "$13.25",
+}
+
+// FareZones maps commuter rail stop names, which the trip headsigns shown as
+// destinations use, to their fare zones, e.g. "Worcester" to "8".
+type FareZones map[string]string
+
+// fareZones are the fare zones loaded from $GTFS_STOPS, if set.
+var fareZones FareZones
+
+// LoadFareZones reads the fare zones from a GTFS stops.txt file.
+func LoadFareZones(path string) (FareZones, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zones, err := ParseFareZones(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return zones, nil
+}
+
+// ParseFareZones reads the fare zones from GTFS stops.txt data. Stops without
+// a commuter rail zone are left out.
+func ParseFareZones(in io.Reader) (FareZones, error) {
+	r := csv.NewReader(in)
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	name, zone := -1, -1
+	for i, column := range header {
+		switch strings.TrimPrefix(column, "\ufeff") {
+		case "stop_name":
+			name = i
+		case "zone_id":
+			zone = i
+		}
+	}
+	if name < 0 || zone < 0 {
+		return nil, errors.New("stop_name and zone_id columns are required")
+	}
+	r.FieldsPerRecord = len(header)
+	zones := FareZones{}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return zones, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(record[zone], crZonePrefix) {
+			continue
+		}
+		if _, ok := zones[record[name]]; !ok {
+			zones[record[name]] = strings.TrimPrefix(record[zone], crZonePrefix)
+		}
+	}
+}
+
+// Zone returns the fare zone of the named stop, or "" if it's unknown.
+func (z FareZones) Zone(stop string) string {
+	return z[stop]
+}
+
+// validFares reports whether fares is a valid board fares option: "" for
+// none, "zone" for a fare zone column, or "estimate" for both the zone and a
+// fare estimate.
+func validFares(fares string) bool {
+	switch fares {
+	case "", "zone", "estimate":
+		return true
+	default:
+		return false
+	}
+}
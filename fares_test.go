@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadFareZones(t *testing.T) {
+	zones, err := LoadFareZones("testdata/gtfs-stops.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, FareZones{
+		"South Station":  "1A",
+		"Readville":      "2",
+		"Worcester":      "8",
+		"Providence":     "8",
+		"Forge Park/495": "6",
+	}, zones)
+
+	_, err = ParseFareZones(strings.NewReader("stop_id,stop_name\nplace-sstat,South Station\n"))
+	assert.EqualError(t, err, "stop_name and zone_id columns are required")
+}
+
+func TestFareColumns(t *testing.T) {
+	defer func() { fareZones = nil }()
+	var err error
+	fareZones, err = LoadFareZones("testdata/gtfs-stops.txt")
+	assert.NoError(t, err)
+
+	departures, err := (&MbtaServiceTest{"testdata/predictions.json"}).ListDepartures("", Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, "Readville", departures[0].Destination)
+	assert.Equal(t, "2", departures[0].Zone)
+	assert.Equal(t, "$7.00", departures[0].Fare)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	LoadTemplates(router, "templates")
+	router.GET("/board/:stop", func(c *gin.Context) {
+		RenderBoard(c, &MbtaServiceTest{"testdata/predictions.json"}, Filter{})
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/board/place-sstat", nil))
+	assert.NotContains(t, w.Body.String(), "<th>Zone</th>")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/board/place-sstat?fares=zone", nil))
+	assert.Contains(t, w.Body.String(), "<th>Zone</th>")
+	assert.NotContains(t, w.Body.String(), "<th>Fare</th>")
+	assert.Contains(t, w.Body.String(), `<td class="zone">8</td>`)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/board/place-sstat?fares=estimate", nil))
+	assert.Contains(t, w.Body.String(), "<th>Fare</th>")
+	assert.Contains(t, w.Body.String(), `<td class="fare">$12.25</td>`)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/board/place-sstat?fares=free", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,46 @@
+//go:build dev
+// +build dev
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterFixtureRoutes adds /fixture/:name, which renders the boards from the
+// named JSON file in dir using MbtaServiceTest. The .json extension may be
+// left off. Fixture boards aren't recorded for the status page, the board
+// history or the delay statistics.
+func RegisterFixtureRoutes(router gin.IRouter, dir string, boards []BoardDefinition) {
+	router.GET("/fixture/:name", func(c *gin.Context) {
+		path, err := fixturePath(dir, c.Param("name"))
+		if err != nil {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		c.Set("fixture", true)
+		Render(c, &MbtaServiceTest{path}, boards)
+	})
+}
+
+// fixturePath returns the path of the named fixture in dir, or an error if
+// there's no such file. Names can't refer to files outside dir.
+func fixturePath(dir, name string) (string, error) {
+	if filepath.Ext(name) == "" {
+		name += ".json"
+	}
+	if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid fixture %q", name)
+	}
+	path := filepath.Join(dir, name)
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		return "", fmt.Errorf("no fixture %q", name)
+	}
+	return path, nil
+}
@@ -0,0 +1,45 @@
+//go:build dev
+// +build dev
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFixtureRoutes(t *testing.T) {
+	defer func(t *StatusTracker, h *BoardHistory) {
+		statusTracker, boardHistory = t, h
+	}(statusTracker, boardHistory)
+	statusTracker, boardHistory = NewStatusTracker(), NewBoardHistory(4, maxTrackedBoards)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	LoadTemplates(router, "templates")
+	RegisterFixtureRoutes(router, "testdata", DefaultBoards)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/fixture/predictions-delayed", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<caption>South Station Information</caption>")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/fixture/error-429.json", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "You have exceeded your allowed usage rate.")
+
+	// Fixture boards aren't mistaken for live ones.
+	assert.Empty(t, statusTracker.Boards())
+	assert.Empty(t, boardHistory.States("place-sstat"))
+
+	for _, name := range []string{"missing", "..", "..%2Fmain.go", ".hidden"} {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/fixture/"+name, nil))
+		assert.Equal(t, http.StatusNotFound, w.Code, name)
+	}
+}
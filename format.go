@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+)
+
+// Column widths, in characters, of the fixed character grid written by the
+// "grid" format.
+const (
+	gridTimeWidth        = 7
+	gridDestinationWidth = 18
+	gridTrackWidth       = 5
+	gridStatusWidth      = 12
+)
+
+// ValidateFormat returns an error if format isn't one understood by
+// WriteDepartures.
+func ValidateFormat(format string) error {
+	switch format {
+	case "json", "text", "grid":
+		return nil
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+// WriteDepartures writes departures to w in the given format: "json" for an
+// indented JSON array, "text" for an aligned table like the one on the
+// departure board, or "grid" for a fixed-width uppercase character grid
+// suitable for driving a physical display.
+func WriteDepartures(w io.Writer, departures []Departure, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(departures)
+	case "text":
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "TIME\tDESTINATION\tTRACK\tSTATUS")
+		for _, d := range departures {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", d.TimeLabel, d.Destination, d.Track, d.Status)
+		}
+		return tw.Flush()
+	case "grid":
+		for _, d := range departures {
+			if _, err := fmt.Fprintln(w, gridRow(d)); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return ValidateFormat(format)
+	}
+}
+
+// gridWidth is the width, in characters, of a row in the "grid" format.
+const gridWidth = gridTimeWidth + gridDestinationWidth + gridTrackWidth + gridStatusWidth + 3
+
+// gridRow formats a departure as a row of the "grid" format.
+func gridRow(d Departure) string {
+	return gridCell(d.TimeLabel, gridTimeWidth) + " " +
+		gridCell(d.Destination, gridDestinationWidth) + " " +
+		gridCell(d.Track, gridTrackWidth) + " " +
+		gridCell(d.Status, gridStatusWidth)
+}
+
+// gridCell formats s as board text, truncated or padded to exactly width
+// characters.
+func gridCell(s string, width int) string {
+	s = truncate(width, boardText(s))
+	if n := utf8.RuneCountInString(s); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return s
+}
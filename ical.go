@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// icalTimeFormat is the format of UTC date-times in iCalendar data.
+const icalTimeFormat = "20060102T150405Z"
+
+// icalLineLength is the length, in bytes, iCalendar content lines are folded
+// at.
+const icalLineLength = 75
+
+// icalEscaper escapes the characters with special meanings in iCalendar text
+// values.
+var icalEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+
+// WriteCalendar writes the departures to w as an iCalendar named title, with
+// an event for each departure at its predicted time, so they can be followed
+// from a calendar app. Departures without a time are left out.
+func WriteCalendar(w io.Writer, title string, departures []Departure) error {
+	bw := bufio.NewWriter(w)
+	stamp := clock().UTC().Format(icalTimeFormat)
+	icalLine(bw, "BEGIN", "VCALENDAR")
+	icalLine(bw, "VERSION", "2.0")
+	icalLine(bw, "PRODID", "-//splitflap//departure board//EN")
+	icalLine(bw, "X-WR-CALNAME", icalEscaper.Replace(title))
+	for _, d := range departures {
+		if d.Time.IsZero() {
+			continue
+		}
+		start := d.Time.UTC().Format(icalTimeFormat)
+		uid := d.Trip
+		if uid == "" {
+			uid = start + "-" + d.Destination
+		}
+		location := title
+		if d.Track != "" && d.Track != "TBD" {
+			location = fmt.Sprintf("%s, track %s", title, d.Track)
+		}
+		icalLine(bw, "BEGIN", "VEVENT")
+		icalLine(bw, "UID", icalEscaper.Replace(uid+"@splitflap"))
+		icalLine(bw, "DTSTAMP", stamp)
+		icalLine(bw, "DTSTART", start)
+		icalLine(bw, "DTEND", d.Time.Add(time.Minute).UTC().Format(icalTimeFormat))
+		icalLine(bw, "SUMMARY", icalEscaper.Replace("Train to "+d.Destination))
+		icalLine(bw, "LOCATION", icalEscaper.Replace(location))
+		if d.Status != "" {
+			icalLine(bw, "DESCRIPTION", icalEscaper.Replace(d.Status))
+		}
+		icalLine(bw, "END", "VEVENT")
+	}
+	icalLine(bw, "END", "VCALENDAR")
+	return bw.Flush()
+}
+
+// icalLine writes an iCalendar content line, folded so that no line is longer
+// than icalLineLength bytes. Errors are left for the writer's Flush.
+func icalLine(w *bufio.Writer, name, value string) {
+	line := name + ":" + value
+	limit := icalLineLength
+	for len(line) > limit {
+		// Fold on a rune boundary, so UTF-8 sequences aren't split.
+		i := limit
+		for i > 0 && !utf8.RuneStart(line[i]) {
+			i--
+		}
+		w.WriteString(line[:i] + "\r\n ")
+		line = line[i:]
+		// Continuation lines start with a space, which counts toward their
+		// length.
+		limit = icalLineLength - 1
+	}
+	w.WriteString(line + "\r\n")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteCalendar(t *testing.T) {
+	defer func() { clock = time.Now }()
+	clock = func() time.Time { return at("2018-09-10T17:00:00-04:00") }
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteCalendar(&buf, "Back Bay", []Departure{
+		{TimeLabel: "5:05PM", Trip: "t1", Destination: "Providence", Track: "1", Status: "On time", Time: at("2018-09-10T17:05:00-04:00")},
+		{TimeLabel: "5:20PM", Destination: "Worcester, MA", Track: "TBD", Time: at("2018-09-10T17:20:00-04:00")},
+		{TimeLabel: "", Destination: "Nowhere"},
+	}))
+	assert.Equal(t, strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//splitflap//departure board//EN",
+		"X-WR-CALNAME:Back Bay",
+		"BEGIN:VEVENT",
+		"UID:t1@splitflap",
+		"DTSTAMP:20180910T210000Z",
+		"DTSTART:20180910T210500Z",
+		"DTEND:20180910T210600Z",
+		"SUMMARY:Train to Providence",
+		"LOCATION:Back Bay\\, track 1",
+		"DESCRIPTION:On time",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:20180910T212000Z-Worcester\\, MA@splitflap",
+		"DTSTAMP:20180910T210000Z",
+		"DTSTART:20180910T212000Z",
+		"DTEND:20180910T212100Z",
+		"SUMMARY:Train to Worcester\\, MA",
+		"LOCATION:Back Bay",
+		"END:VEVENT",
+		"END:VCALENDAR",
+		"",
+	}, "\r\n"), buf.String())
+}
+
+func TestCalendarLinesAreFolded(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, WriteCalendar(&buf, strings.Repeat("é", 100), nil))
+	lines := strings.Split(buf.String(), "\r\n")
+	assert.Equal(t, "X-WR-CALNAME:"+strings.Repeat("é", 31), lines[3])
+	for _, line := range lines {
+		assert.True(t, len(line) <= icalLineLength, line)
+	}
+	unfolded := strings.Replace(buf.String(), "\r\n ", "", -1)
+	assert.Contains(t, unfolded, "X-WR-CALNAME:"+strings.Repeat("é", 100)+"\r\n")
+}
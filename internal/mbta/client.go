@@ -0,0 +1,237 @@
+// Package mbta is a client for the MBTA APIv3 (https://api-v3.mbta.com/).
+// Each endpoint we use has a typed method on Client that takes Options to
+// filter, include and sort the results.
+package mbta
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dghubble/sling"
+	"github.com/google/jsonapi"
+)
+
+// BaseUrl is the root of the MBTA APIv3.
+const BaseUrl = "https://api-v3.mbta.com/"
+
+// Error is returned for error responses from the API. StatusCode is the HTTP
+// status, or zero for error payloads that didn't come from a response.
+type Error struct {
+	StatusCode int `json:"-"`
+	Errors     []struct {
+		Status string `json:"status"`
+		Source struct {
+			Parameter string `json:"parameter"`
+		} `json:"source"`
+		Detail string `json:"detail"`
+		Code   string `json:"code"`
+	} `json:"errors"`
+}
+
+// Error implements the Golang error interface for Error.
+func (e Error) Error() string {
+	switch len(e.Errors) {
+	case 0:
+		return fmt.Sprintf("MBTA API error: HTTP %d", e.StatusCode)
+	case 1:
+		return fmt.Sprintf("MBTA API error: %v", e.Errors[0].Detail)
+	default:
+		return fmt.Sprintf("MBTA API error: %+v", e.Errors)
+	}
+}
+
+// RateLimit is the API's rate limit budget as of the last response.
+type RateLimit struct {
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+}
+
+// Client wraps the Sling request handle and underlying http client.
+type Client struct {
+	sling  *sling.Sling
+	client *http.Client
+
+	mu        sync.Mutex
+	rateLimit *RateLimit
+}
+
+// NewClient creates a Client that makes requests with httpClient. If apiKey
+// isn't empty it's sent with every request.
+func NewClient(httpClient *http.Client, apiKey string) *Client {
+	base := sling.New().Client(httpClient).Base(BaseUrl)
+	if apiKey != "" {
+		base.Set("x-api-key", apiKey)
+	}
+	return &Client{
+		sling:  base,
+		client: httpClient,
+	}
+}
+
+// Predictions lists predictions from the predictions endpoint. The API
+// requires a stop, route or trip filter.
+func (c *Client) Predictions(opts ...Option) ([]*Prediction, error) {
+	var predictions []*Prediction
+	return predictions, c.list("predictions", opts, &predictions)
+}
+
+// Schedules lists scheduled stops from the schedules endpoint. The API
+// requires a stop, route or trip filter.
+func (c *Client) Schedules(opts ...Option) ([]*Schedule, error) {
+	var schedules []*Schedule
+	return schedules, c.list("schedules", opts, &schedules)
+}
+
+// Alerts lists service alerts from the alerts endpoint.
+func (c *Client) Alerts(opts ...Option) ([]*Alert, error) {
+	var alerts []*Alert
+	return alerts, c.list("alerts", opts, &alerts)
+}
+
+// Stops lists stops from the stops endpoint.
+func (c *Client) Stops(opts ...Option) ([]*Stop, error) {
+	var stops []*Stop
+	return stops, c.list("stops", opts, &stops)
+}
+
+// Routes lists routes from the routes endpoint.
+func (c *Client) Routes(opts ...Option) ([]*Route, error) {
+	var routes []*Route
+	return routes, c.list("routes", opts, &routes)
+}
+
+// Vehicles lists vehicle positions from the vehicles endpoint.
+func (c *Client) Vehicles(opts ...Option) ([]*Vehicle, error) {
+	var vehicles []*Vehicle
+	return vehicles, c.list("vehicles", opts, &vehicles)
+}
+
+// Facilities lists station amenities from the facilities endpoint.
+func (c *Client) Facilities(opts ...Option) ([]*Facility, error) {
+	var facilities []*Facility
+	return facilities, c.list("facilities", opts, &facilities)
+}
+
+// LiveFacilities lists the real-time state of facilities from the
+// live_facilities endpoint.
+func (c *Client) LiveFacilities(opts ...Option) ([]*LiveFacility, error) {
+	var live []*LiveFacility
+	return live, c.list("live_facilities", opts, &live)
+}
+
+// list requests the given API path with the query parameters set by opts and
+// decodes the response into v, as Decode does.
+func (c *Client) list(path string, opts []Option, v interface{}) error {
+	query := url.Values{}
+	for _, opt := range opts {
+		opt(query)
+	}
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	// Dump the request to logs for debugging. This goes to stderr so it
+	// doesn't end up mixed into the output of the command line modes.
+	req, err := c.sling.New().Get(path).Request()
+	if err != nil {
+		return err
+	}
+	log.Printf("request: %v", req.URL)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	c.updateRateLimit(resp.Header)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// Error bodies from in front of the API (e.g. a 502 from the load
+		// balancer) aren't JSON, so a body we can't decode still gives an
+		// Error with the status code.
+		apiError := &Error{StatusCode: resp.StatusCode}
+		json.NewDecoder(resp.Body).Decode(apiError)
+		return apiError
+	}
+	return decodeInto(resp.Body, v)
+}
+
+// RateLimit returns the rate limit budget reported with the last response, or
+// false if no response has reported one yet.
+func (c *Client) RateLimit() (RateLimit, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rateLimit == nil {
+		return RateLimit{}, false
+	}
+	return *c.rateLimit, true
+}
+
+// updateRateLimit records the rate limit budget from a response's headers, if
+// it has them. Reset is sent in seconds since the epoch.
+func (c *Client) updateRateLimit(header http.Header) {
+	limit, err := strconv.Atoi(header.Get("x-ratelimit-limit"))
+	if err != nil {
+		return
+	}
+	remaining, _ := strconv.Atoi(header.Get("x-ratelimit-remaining"))
+	reset, _ := strconv.ParseInt(header.Get("x-ratelimit-reset"), 10, 64)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rateLimit = &RateLimit{
+		Limit:     limit,
+		Remaining: remaining,
+		Reset:     time.Unix(reset, 0),
+	}
+}
+
+// Decode reads a JSONAPI payload such as a canned API response into v, which
+// must be a pointer to a slice of pointers to one of the resource types. If
+// the payload is an error response, its *Error is returned instead.
+func Decode(in io.Reader, v interface{}) error {
+	var doc struct {
+		jsonapi.ManyPayload
+		Error
+	}
+	if err := json.NewDecoder(in).Decode(&doc); err != nil {
+		return err
+	}
+	if len(doc.Errors) > 0 {
+		return &doc.Error
+	}
+	return setResources(v, func(t reflect.Type) ([]interface{}, error) {
+		return unmarshalNodes(&doc.ManyPayload, t)
+	})
+}
+
+// decodeInto unmarshals a JSONAPI payload with UnmarshalPayload and stores the
+// resources in v, a pointer to a slice of resource pointers.
+func decodeInto(in io.Reader, v interface{}) error {
+	return setResources(v, func(t reflect.Type) ([]interface{}, error) {
+		return UnmarshalPayload(in, t)
+	})
+}
+
+// setResources calls unmarshal with the type of the elements of v, a pointer
+// to a slice of resource pointers, and stores the resources it returns in v.
+func setResources(v interface{}, unmarshal func(reflect.Type) ([]interface{}, error)) error {
+	slice := reflect.ValueOf(v).Elem()
+	models, err := unmarshal(slice.Type().Elem())
+	if err != nil {
+		return err
+	}
+	resources := reflect.MakeSlice(slice.Type(), len(models), len(models))
+	for i, model := range models {
+		resources.Index(i).Set(reflect.ValueOf(model))
+	}
+	slice.Set(resources)
+	return nil
+}
@@ -0,0 +1,87 @@
+package mbta
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func TestOptions(t *testing.T) {
+	defer gock.Off()
+	gock.New(BaseUrl).
+		Get("/predictions").
+		MatchParam("filter[stop]", "^place-north,place-sstat$").
+		MatchParam("include", "^route,trip$").
+		MatchParam("sort", "^departure_time$").
+		MatchHeader("x-api-key", "^secret$").
+		Reply(200).
+		SetHeader("x-ratelimit-limit", "1000").
+		SetHeader("x-ratelimit-remaining", "998").
+		SetHeader("x-ratelimit-reset", "1536606000").
+		File("testdata/predictions-malformed.json")
+
+	httpClient := &http.Client{}
+	gock.InterceptClient(httpClient)
+
+	client := NewClient(httpClient, "secret")
+	_, ok := client.RateLimit()
+	assert.False(t, ok)
+	predictions, err := client.Predictions(
+		Filter("stop", "place-north", "place-sstat"),
+		Include("route", "trip"),
+		Sort("departure_time"))
+	assert.NoError(t, err)
+	assert.Len(t, predictions, 4)
+	assert.True(t, gock.IsDone())
+
+	rateLimit, ok := client.RateLimit()
+	assert.True(t, ok)
+	assert.Equal(t, RateLimit{Limit: 1000, Remaining: 998, Reset: time.Unix(1536606000, 0)}, rateLimit)
+}
+
+func TestErrorResponse(t *testing.T) {
+	defer gock.Off()
+	gock.New(BaseUrl).
+		Get("/alerts").
+		Reply(429).
+		File("testdata/error-429.json")
+	gock.New(BaseUrl).
+		Get("/stops").
+		Reply(502).
+		BodyString("<html>Bad Gateway</html>")
+
+	httpClient := &http.Client{}
+	gock.InterceptClient(httpClient)
+	client := NewClient(httpClient, "")
+
+	alerts, err := client.Alerts()
+	assert.Nil(t, alerts)
+	assert.EqualError(t, err, "MBTA API error: You have exceeded your allowed usage rate.")
+	assert.Equal(t, 429, err.(*Error).StatusCode)
+
+	stops, err := client.Stops(Filter("id", "place-north"))
+	assert.Nil(t, stops)
+	assert.EqualError(t, err, "MBTA API error: HTTP 502")
+}
+
+func TestDecode(t *testing.T) {
+	f, err := os.Open("testdata/error-429.json")
+	if err != nil {
+		assert.FailNow(t, "Failed to open test fixture")
+	}
+	defer f.Close()
+
+	var routes []*Route
+	err = Decode(f, &routes)
+	assert.EqualError(t, err, "MBTA API error: You have exceeded your allowed usage rate.")
+
+	err = Decode(strings.NewReader(`{"data": [{"type": "route", "id": "CR-Fitchburg",
+		"attributes": {"type": 2, "long_name": "Fitchburg Line"}}]}`), &routes)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Route{{Id: "CR-Fitchburg", Type: 2, LongName: "Fitchburg Line"}}, routes)
+}
@@ -0,0 +1,34 @@
+package mbta
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Option sets a query parameter on a request. Options are applied in order,
+// so a later option for the same parameter replaces an earlier one.
+type Option func(query url.Values)
+
+// Filter restricts the results to resources whose field matches any of the
+// given values, e.g. Filter("stop", "place-north", "place-sstat").
+func Filter(field string, values ...string) Option {
+	return func(query url.Values) {
+		query.Set("filter["+field+"]", strings.Join(values, ","))
+	}
+}
+
+// Include asks for the given relationships to be sideloaded, so that they're
+// populated in the returned resources rather than only having their IDs.
+func Include(relationships ...string) Option {
+	return func(query url.Values) {
+		query.Set("include", strings.Join(relationships, ","))
+	}
+}
+
+// Sort orders the results by the given attributes. Prefix an attribute with
+// "-" to sort in descending order.
+func Sort(attributes ...string) Option {
+	return func(query url.Values) {
+		query.Set("sort", strings.Join(attributes, ","))
+	}
+}
@@ -0,0 +1,225 @@
+package mbta
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"io"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/google/jsonapi"
+)
+
+// PayloadMetrics counts the problems found in MBTA API payloads that were
+// worked around rather than failing the whole request. They're published with
+// the other expvars at /debug/vars.
+var PayloadMetrics = expvar.NewMap("payload")
+
+// UnmarshalPayload is a tolerant version of jsonapi.UnmarshalManyPayload. The
+// jsonapi library fails the whole payload if any resource doesn't match our
+// structs, so before unmarshalling we drop relationships to resources of an
+// unexpected type and attributes of an unexpected JSON type. If the payload
+// still fails, each resource is unmarshalled on its own and the ones that
+// fail are dropped. Everything dropped is logged and counted in
+// PayloadMetrics.
+func UnmarshalPayload(in io.Reader, t reflect.Type) ([]interface{}, error) {
+	payload := new(jsonapi.ManyPayload)
+	if err := json.NewDecoder(in).Decode(payload); err != nil {
+		return nil, err
+	}
+	return unmarshalNodes(payload, t)
+}
+
+// unmarshalNodes does the work of UnmarshalPayload on a decoded payload.
+// Besides the problems UnmarshalPayload works around, it prunes everything
+// that t doesn't use (attributes and relationships without a field, and
+// included resources of other types) so that the jsonapi library, which
+// decodes the payload again, has less to do.
+func unmarshalNodes(payload *jsonapi.ManyPayload, t reflect.Type) ([]interface{}, error) {
+	schema := schemaFor(t.Elem())
+	for _, node := range payload.Data {
+		sanitizeNode(node, schema)
+	}
+	included := payload.Included[:0]
+	for _, node := range payload.Included {
+		if _, ok := schema[node.Type]; ok {
+			sanitizeNode(node, schema)
+			included = append(included, node)
+		}
+	}
+	payload.Included = included
+
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buf)
+	buf.Reset()
+	if err := json.NewEncoder(buf).Encode(payload); err != nil {
+		return nil, err
+	}
+	models, err := jsonapi.UnmarshalManyPayload(buf, t)
+	if err == nil {
+		return models, nil
+	}
+
+	models = []interface{}{}
+	for _, node := range payload.Data {
+		buf.Reset()
+		if err := json.NewEncoder(buf).Encode(&jsonapi.OnePayload{Data: node, Included: payload.Included}); err != nil {
+			return nil, err
+		}
+		model := reflect.New(t.Elem())
+		if err := jsonapi.UnmarshalPayload(buf, model.Interface()); err != nil {
+			log.Printf("payload: dropping %s %s: %v", node.Type, node.ID, err)
+			PayloadMetrics.Add("dropped_resources", 1)
+			continue
+		}
+		models = append(models, model.Interface())
+	}
+	return models, nil
+}
+
+// bufferPool holds the buffers that sanitized payloads are encoded into.
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// resourceSchema describes the jsonapi fields of a resource struct, keyed by
+// attribute or relationship name. Relations map to the related struct type.
+type resourceSchema struct {
+	Type      reflect.Type
+	Attrs     map[string]reflect.Type
+	Relations map[string]reflect.Type
+}
+
+// schemaCache maps each resource struct type to the schema of the payloads it
+// can be unmarshalled from, so struct tags are only parsed once per type.
+var schemaCache sync.Map
+
+// schemaFor returns the schemas of t, a struct with jsonapi tags, and all the
+// types it's related to, keyed by JSONAPI resource type.
+func schemaFor(t reflect.Type) map[string]*resourceSchema {
+	if schema, ok := schemaCache.Load(t); ok {
+		return schema.(map[string]*resourceSchema)
+	}
+	schema := map[string]*resourceSchema{}
+	addToSchema(schema, t)
+	schemaCache.Store(t, schema)
+	return schema
+}
+
+// addToSchema adds t, a struct with jsonapi tags, and the types of all its
+// relationships to schema.
+func addToSchema(schema map[string]*resourceSchema, t reflect.Type) {
+	rs := &resourceSchema{
+		Type:      t,
+		Attrs:     map[string]reflect.Type{},
+		Relations: map[string]reflect.Type{},
+	}
+	var related []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		args := strings.Split(t.Field(i).Tag.Get("jsonapi"), ",")
+		if len(args) < 2 {
+			continue
+		}
+		switch args[0] {
+		case "primary":
+			if _, ok := schema[args[1]]; ok {
+				return
+			}
+			schema[args[1]] = rs
+		case "attr":
+			rs.Attrs[args[1]] = t.Field(i).Type
+		case "relation":
+			r := t.Field(i).Type
+			for r.Kind() == reflect.Ptr || r.Kind() == reflect.Slice {
+				r = r.Elem()
+			}
+			rs.Relations[args[1]] = r
+			related = append(related, r)
+		}
+	}
+	for _, r := range related {
+		addToSchema(schema, r)
+	}
+}
+
+// sanitizeNode removes the relationships and attributes of node that would
+// make the jsonapi library reject it, if its type is in the schema, along
+// with those that don't have a field.
+func sanitizeNode(node *jsonapi.Node, schema map[string]*resourceSchema) {
+	rs, ok := schema[node.Type]
+	if !ok {
+		return
+	}
+	for name, value := range node.Attributes {
+		t, ok := rs.Attrs[name]
+		if !ok {
+			delete(node.Attributes, name)
+			continue
+		}
+		if !attributeFits(value, t) {
+			log.Printf("payload: ignoring %s attribute %q of %s %s",
+				reflect.TypeOf(value), name, node.Type, node.ID)
+			PayloadMetrics.Add("invalid_attributes", 1)
+			delete(node.Attributes, name)
+		}
+	}
+	for name, value := range node.Relationships {
+		related, ok := rs.Relations[name]
+		if !ok {
+			delete(node.Relationships, name)
+			continue
+		}
+		relationship, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		data, ok := relationship["data"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if typ, _ := data["type"].(string); schema[typ] == nil || schema[typ].Type != related {
+			log.Printf("payload: ignoring %s relationship %q of %s %s",
+				data["type"], name, node.Type, node.ID)
+			PayloadMetrics.Add("unknown_relationship_types", 1)
+			delete(node.Relationships, name)
+		}
+	}
+}
+
+// attributeFits reports whether the jsonapi library can unmarshal an attribute
+// value decoded from JSON into a field of type t. Null values always fit.
+func attributeFits(value interface{}, t reflect.Type) bool {
+	switch value.(type) {
+	case nil:
+		return true
+	case string:
+		return t.Kind() == reflect.String
+	case bool:
+		return t.Kind() == reflect.Bool
+	case float64:
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			return true
+		}
+		return false
+	case []interface{}:
+		if t == reflect.TypeOf([]string{}) {
+			for _, v := range value.([]interface{}) {
+				if _, ok := v.(string); !ok {
+					return false
+				}
+			}
+			return true
+		}
+		return t == reflect.TypeOf([]interface{}{})
+	case map[string]interface{}:
+		return t == reflect.TypeOf(map[string]interface{}{})
+	default:
+		return false
+	}
+}
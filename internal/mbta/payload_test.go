@@ -0,0 +1,65 @@
+package mbta
+
+import (
+	"bytes"
+	"expvar"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// metric returns the current value of a PayloadMetrics counter.
+func metric(name string) int64 {
+	if v, ok := PayloadMetrics.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestUnmarshalPayload(t *testing.T) {
+	before := map[string]int64{}
+	names := []string{"dropped_resources", "invalid_attributes", "unknown_relationship_types"}
+	for _, name := range names {
+		before[name] = metric(name)
+	}
+
+	f, err := os.Open("testdata/predictions-malformed.json")
+	if err != nil {
+		assert.FailNow(t, "Failed to open test fixture")
+	}
+	defer f.Close()
+	var predictions []*Prediction
+	assert.NoError(t, Decode(f, &predictions))
+
+	// The vehicle is dropped, the numeric status is cleared and the route
+	// that's really a facility is left unset.
+	ids := []string{}
+	for _, p := range predictions {
+		ids = append(ids, p.Id)
+	}
+	assert.Equal(t, []string{"good", "numeric-status", "facility-route", "no-trip"}, ids)
+	assert.Equal(t, "", predictions[1].Status)
+	assert.Nil(t, predictions[2].Route)
+	assert.Nil(t, predictions[3].Trip)
+
+	assert.Equal(t, int64(1), metric("dropped_resources")-before["dropped_resources"])
+	assert.Equal(t, int64(3), metric("invalid_attributes")-before["invalid_attributes"])
+	assert.Equal(t, int64(1), metric("unknown_relationship_types")-before["unknown_relationship_types"])
+}
+
+func BenchmarkDecode(b *testing.B) {
+	buf, err := ioutil.ReadFile("testdata/predictions.json")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var predictions []*Prediction
+		if err := Decode(bytes.NewReader(buf), &predictions); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
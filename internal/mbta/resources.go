@@ -0,0 +1,131 @@
+package mbta
+
+import "strings"
+
+// The resource types below only define the fields we need to unmarshal from
+// the JSONAPI responses. Add fields as they're needed.
+
+// Prediction represents a predicted arrival or departure.
+type Prediction struct {
+	Id            string    `jsonapi:"primary,prediction"`
+	ArrivalTime   string    `jsonapi:"attr,arrival_time"`
+	DepartureTime string    `jsonapi:"attr,departure_time"`
+	StopSequence  int       `jsonapi:"attr,stop_sequence"`
+	Status        string    `jsonapi:"attr,status"`
+	Revenue       string    `jsonapi:"attr,revenue"`
+	Route         *Route    `jsonapi:"relation,route,omitempty"`
+	Trip          *Trip     `jsonapi:"relation,trip,omitempty"`
+	Stop          *Stop     `jsonapi:"relation,stop,omitempty"`
+	Schedule      *Schedule `jsonapi:"relation,schedule,omitempty"`
+}
+
+// Route represents a route, such as a commuter rail line.
+type Route struct {
+	Id             string   `jsonapi:"primary,route"`
+	Type           int      `jsonapi:"attr,type"`
+	LongName       string   `jsonapi:"attr,long_name"`
+	DirectionNames []string `jsonapi:"attr,direction_names"`
+}
+
+// Schedule represents a scheduled arrival or departure. PickupType and
+// DropOffType are the GTFS pickup and drop-off types, saying whether
+// passengers can board and leave the train at the stop.
+type Schedule struct {
+	Id            string `jsonapi:"primary,schedule"`
+	DepartureTime string `jsonapi:"attr,departure_time"`
+	PickupType    int    `jsonapi:"attr,pickup_type"`
+	DropOffType   int    `jsonapi:"attr,drop_off_type"`
+	Route         *Route `jsonapi:"relation,route,omitempty"`
+	Trip          *Trip  `jsonapi:"relation,trip,omitempty"`
+}
+
+// Stop represents a stop, station or platform.
+type Stop struct {
+	Id           string  `jsonapi:"primary,stop"`
+	Name         string  `jsonapi:"attr,name"`
+	PlatformCode string  `jsonapi:"attr,platform_code"`
+	Latitude     float64 `jsonapi:"attr,latitude"`
+	Longitude    float64 `jsonapi:"attr,longitude"`
+	// ParentStation is the station a platform belongs to, if any. Unless
+	// it's included only its Id is set.
+	ParentStation *Stop `jsonapi:"relation,parent_station,omitempty"`
+}
+
+// Trip represents a single journey along a route.
+type Trip struct {
+	Id          string `jsonapi:"primary,trip"`
+	Headsign    string `jsonapi:"attr,headsign"`
+	DirectionId int    `jsonapi:"attr,direction_id"`
+}
+
+// Vehicle represents the current position of a train.
+type Vehicle struct {
+	Id            string  `jsonapi:"primary,vehicle"`
+	Label         string  `jsonapi:"attr,label"`
+	CurrentStatus string  `jsonapi:"attr,current_status"`
+	Latitude      float64 `jsonapi:"attr,latitude"`
+	Longitude     float64 `jsonapi:"attr,longitude"`
+	UpdatedAt     string  `jsonapi:"attr,updated_at"`
+	Route         *Route  `jsonapi:"relation,route,omitempty"`
+	Trip          *Trip   `jsonapi:"relation,trip,omitempty"`
+	Stop          *Stop   `jsonapi:"relation,stop,omitempty"`
+}
+
+// Alert represents a service alert. Cause is why it was issued, e.g.
+// "SIGNAL_PROBLEM", and InformedEntity the routes, trips and stops it's about,
+// as objects with "route", "trip" and "stop" keys.
+type Alert struct {
+	Id             string        `jsonapi:"primary,alert"`
+	Effect         string        `jsonapi:"attr,effect"`
+	Cause          string        `jsonapi:"attr,cause"`
+	Header         string        `jsonapi:"attr,header"`
+	ShortHeader    string        `jsonapi:"attr,short_header"`
+	InformedEntity []interface{} `jsonapi:"attr,informed_entity"`
+}
+
+// Facility represents a station amenity such as a parking garage.
+type Facility struct {
+	Id         string        `jsonapi:"primary,facility"`
+	LongName   string        `jsonapi:"attr,long_name"`
+	Type       string        `jsonapi:"attr,type"`
+	Properties []interface{} `jsonapi:"attr,properties"`
+}
+
+// LiveFacility represents the real-time state of a facility. It has the same
+// ID as the facility it describes.
+type LiveFacility struct {
+	Id         string        `jsonapi:"primary,live_facility"`
+	Properties []interface{} `jsonapi:"attr,properties"`
+}
+
+// DirectionName returns the name of the trip's direction on the route, or
+// false if either is missing or the route doesn't name the trip's direction.
+func DirectionName(route *Route, trip *Trip) (string, bool) {
+	if route == nil || trip == nil ||
+		trip.DirectionId < 0 || trip.DirectionId >= len(route.DirectionNames) {
+		return "", false
+	}
+	return route.DirectionNames[trip.DirectionId], true
+}
+
+// NotAvailable is the GTFS pickup or drop-off type for a stop where
+// passengers can't board or leave the train.
+const NotAvailable = 1
+
+// PickupAllowed reports whether passengers can board at the scheduled stop.
+// It's true if the schedule is missing, since most stops allow boarding.
+func (s *Schedule) PickupAllowed() bool {
+	return s == nil || s.PickupType != NotAvailable
+}
+
+// greenLinePrefix is the prefix of the route ids of the Green Line branches.
+const greenLinePrefix = "Green-"
+
+// GreenLineBranch returns the letter of the Green Line branch the route is,
+// such as "B" for the Boston College branch, or false if it isn't one.
+func GreenLineBranch(route *Route) (string, bool) {
+	if route == nil || !strings.HasPrefix(route.Id, greenLinePrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(route.Id, greenLinePrefix), true
+}
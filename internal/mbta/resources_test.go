@@ -0,0 +1,37 @@
+package mbta
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirectionName(t *testing.T) {
+	route := &Route{DirectionNames: []string{"Outbound", "Inbound"}}
+	name, ok := DirectionName(route, &Trip{DirectionId: 1})
+	assert.True(t, ok)
+	assert.Equal(t, "Inbound", name)
+
+	_, ok = DirectionName(route, nil)
+	assert.False(t, ok)
+	_, ok = DirectionName(route, &Trip{DirectionId: 2})
+	assert.False(t, ok)
+}
+
+func TestGreenLineBranch(t *testing.T) {
+	branch, ok := GreenLineBranch(&Route{Id: "Green-B"})
+	assert.True(t, ok)
+	assert.Equal(t, "B", branch)
+
+	_, ok = GreenLineBranch(&Route{Id: "CR-Worcester"})
+	assert.False(t, ok)
+	_, ok = GreenLineBranch(nil)
+	assert.False(t, ok)
+}
+
+func TestPickupAllowed(t *testing.T) {
+	assert.True(t, (&Schedule{}).PickupAllowed())
+	assert.False(t, (&Schedule{PickupType: NotAvailable}).PickupAllowed())
+	var missing *Schedule
+	assert.True(t, missing.PickupAllowed())
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mattmckeon/splitflap/internal/mbta"
+)
+
+// LastTrainFinder is an interface for services that know which trips are the
+// last departures of the service day from a stop.
+type LastTrainFinder interface {
+	LastTrips(place string) (map[string]bool, error)
+}
+
+// lastTripErrorTtl is how long a failure to fetch a stop's last trips is
+// repeated without asking the API again, like CachingService's ErrorTtl.
+const lastTripErrorTtl = 30 * time.Second
+
+// lastTripCache holds the last trips of the current service day from each
+// stop, since they only change once a day, and recent failures to fetch them.
+type lastTripCache struct {
+	mu       sync.Mutex
+	day      time.Time
+	trips    map[string]map[string]bool
+	failures map[string]*cacheEntry
+}
+
+// reset empties the cache if it's for a service day other than day. The
+// caller must hold c.mu.
+func (c *lastTripCache) reset(day time.Time) {
+	if !c.day.Equal(day) {
+		c.day = day
+		c.trips = map[string]map[string]bool{}
+		c.failures = map[string]*cacheEntry{}
+	}
+}
+
+// LastTrips is an implementation of the LastTrainFinder LastTrips method that
+// finds the last trips in the stop's schedule for the current service day,
+// fetched once a day from s.Schedules, if set, or the MBTA APIv3 schedules
+// endpoint. Failures are repeated for lastTripErrorTtl before asking again.
+func (s *MbtaServiceImpl) LastTrips(place string) (map[string]bool, error) {
+	now := clock()
+	day := serviceDay(now)
+	s.lastTrips.mu.Lock()
+	s.lastTrips.reset(day)
+	if trips, ok := s.lastTrips.trips[place]; ok {
+		s.lastTrips.mu.Unlock()
+		return trips, nil
+	}
+	if failure, ok := s.lastTrips.failures[place]; ok && now.Before(failure.retryAfter) {
+		s.lastTrips.mu.Unlock()
+		return nil, failure.err
+	}
+	s.lastTrips.mu.Unlock()
+
+	var dated DatedScheduleService = s
+	if s.Schedules != nil {
+		dated = s.Schedules
+	}
+	schedules, err := dated.SchedulesOn(place, day)
+
+	s.lastTrips.mu.Lock()
+	defer s.lastTrips.mu.Unlock()
+	s.lastTrips.reset(day)
+	if err != nil {
+		s.lastTrips.failures[place] = &cacheEntry{err: err, retryAfter: now.Add(lastTripErrorTtl)}
+		return nil, err
+	}
+	trips := LastTrips(schedules)
+	s.lastTrips.trips[place] = trips
+	delete(s.lastTrips.failures, place)
+	return trips, nil
+}
+
+// LastTrips returns the IDs of the trips making the last departure on each
+// line in each direction in the schedules.
+func LastTrips(schedules []*mbta.Schedule) map[string]bool {
+	trips := map[string]bool{}
+	for _, span := range Spans(schedules) {
+		trips[span.LastTrip] = true
+	}
+	return trips
+}
+
+// lastTripsFor returns the last trips from the stop if the service can find
+// them. Failures are logged, and the board is shown without them.
+func lastTripsFor(client MbtaService, place string) map[string]bool {
+	finder, ok := unwrapService(client).(LastTrainFinder)
+	if !ok {
+		return nil
+	}
+	trips, err := finder.LastTrips(place)
+	if err != nil {
+		log.Printf("last trains: %v", err)
+	}
+	return trips
+}
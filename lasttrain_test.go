@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func TestLastTrips(t *testing.T) {
+	schedules, err := (&MbtaServiceTest{"testdata/schedules-backbay.json"}).
+		SchedulesOn("place-bbsta", time.Time{})
+	assert.NoError(t, err)
+	// The inbound Providence trip arriving at 11:50PM has no departure.
+	assert.Equal(t, map[string]bool{"t2": true, "t4": true, "t7": true, "t9": true},
+		LastTrips(schedules))
+}
+
+func TestLastTrainIsMarked(t *testing.T) {
+	defer gock.Off()
+	defer func() { clock = time.Now }()
+	clock = func() time.Time {
+		return time.Date(2018, 9, 10, 17, 0, 0, 0, serviceTimeZone)
+	}
+
+	gock.New(MbtaApiV3BaseUrl).
+		Get("/predictions").
+		Times(2).
+		Reply(200).
+		File("testdata/predictions-backbay.json")
+	// The schedule is only fetched once a day.
+	gock.New(MbtaApiV3BaseUrl).
+		Get("/schedules").
+		MatchParam("filter[stop]", "place-bbsta").
+		MatchParam("filter[date]", "2018-09-10").
+		Reply(200).
+		File("testdata/schedules-backbay.json")
+
+	httpClient := &http.Client{}
+	gock.InterceptClient(httpClient)
+	service := NewMbtaServiceImpl(httpClient)
+
+	for i := 0; i < 2; i++ {
+		departures, err := service.ListDepartures("place-bbsta", Filter{Direction: "both"})
+		assert.NoError(t, err)
+		var last []string
+		for _, d := range departures {
+			if d.LastTrain {
+				last = append(last, d.TimeLabel)
+			}
+		}
+		assert.Equal(t, []string{"5:12PM", "5:31PM"}, last)
+	}
+	assert.True(t, gock.IsDone())
+}
+
+func TestLastTripFailuresAreCached(t *testing.T) {
+	defer gock.Off()
+	defer func() { clock = time.Now }()
+	now := time.Date(2018, 9, 10, 17, 0, 0, 0, serviceTimeZone)
+	clock = func() time.Time { return now }
+
+	// The schedule is asked for once while it's failing, and again after
+	// lastTripErrorTtl.
+	gock.New(MbtaApiV3BaseUrl).
+		Get("/schedules").
+		Reply(429).
+		File("testdata/error-429.json")
+	gock.New(MbtaApiV3BaseUrl).
+		Get("/schedules").
+		Reply(200).
+		File("testdata/schedules-backbay.json")
+
+	httpClient := &http.Client{}
+	gock.InterceptClient(httpClient)
+	service := NewMbtaServiceImpl(httpClient)
+
+	for i := 0; i < 2; i++ {
+		_, err := service.LastTrips("place-bbsta")
+		assert.Error(t, err)
+	}
+	now = now.Add(lastTripErrorTtl)
+	trips, err := service.LastTrips("place-bbsta")
+	assert.NoError(t, err)
+	assert.Len(t, trips, 4)
+	assert.True(t, gock.IsDone())
+}
+
+func TestLastTripsUsePrecomputedSchedules(t *testing.T) {
+	defer gock.Off()
+	defer func() { clock = time.Now }()
+
+	// The API is failing.
+	gock.New(MbtaApiV3BaseUrl).
+		Get("/schedules").
+		Persist().
+		Reply(429).
+		File("testdata/error-429.json")
+	httpClient := &http.Client{}
+	gock.InterceptClient(httpClient)
+	service := NewMbtaServiceImpl(httpClient)
+
+	precomputer := NewSchedulePrecomputer(&datedScheduleService{
+		MbtaServiceTest: MbtaServiceTest{"testdata/schedules-backbay.json"},
+	}, []string{"place-bbsta"})
+	clock = func() time.Time { return time.Date(2018, 9, 10, 1, 30, 0, 0, serviceTimeZone) }
+	precomputer.Precompute()
+	service.Schedules = precomputer
+
+	clock = func() time.Time { return time.Date(2018, 9, 10, 17, 0, 0, 0, serviceTimeZone) }
+	trips, err := service.LastTrips("place-bbsta")
+	assert.NoError(t, err)
+	assert.Len(t, trips, 4)
+}
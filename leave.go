@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LeaveAlert asks to be told when to leave for a train, e.g. the 5:35PM to
+// Providence from South Station with a 10 minute walk to the station. Time is
+// the train's scheduled departure time, formatted like the board's time
+// labels.
+type LeaveAlert struct {
+	Id          string `json:"id"`
+	Stop        string `json:"stop"`
+	Destination string `json:"destination"`
+	Time        string `json:"time"`
+	WalkMinutes int    `json:"walk_minutes"`
+
+	// departs is when the train is scheduled to leave, on the day the alert
+	// was added or the next if the time had passed.
+	departs time.Time
+}
+
+// maxLeaveAlerts is the most alerts that can be pending at once, since each
+// stop with an alert is fetched every check.
+const maxLeaveAlerts = 100
+
+// errTooManyLeaveAlerts is returned by LeaveAlerts.Add when maxLeaveAlerts
+// are already pending.
+var errTooManyLeaveAlerts = fmt.Errorf("at most %d alerts can be pending", maxLeaveAlerts)
+
+// LeaveAlerts holds the pending leave alerts and fires them through the
+// notifier when it's time to leave. Since the leave time is worked out from
+// the latest prediction on every check, alerts for delayed trains fire later
+// automatically. Alerts are removed once they fire or their train has left.
+type LeaveAlerts struct {
+	service  MbtaService
+	notifier Notifier
+
+	mu     sync.Mutex
+	alerts map[string]*LeaveAlert
+	nextId int
+}
+
+// NewLeaveAlerts creates and returns an empty set of leave alerts.
+func NewLeaveAlerts(service MbtaService, notifier Notifier) *LeaveAlerts {
+	return &LeaveAlerts{
+		service:  service,
+		notifier: notifier,
+		alerts:   map[string]*LeaveAlert{},
+	}
+}
+
+// Add validates the alert, assigns it an ID and schedules it.
+func (a *LeaveAlerts) Add(alert LeaveAlert) (LeaveAlert, error) {
+	if alert.Stop == "" || alert.Destination == "" {
+		return alert, errors.New("stop and destination are required")
+	}
+	t, err := time.Parse("3:04PM", strings.ToUpper(strings.Replace(alert.Time, " ", "", -1)))
+	if err != nil {
+		return alert, fmt.Errorf("invalid time %q", alert.Time)
+	}
+	if alert.WalkMinutes < 0 {
+		return alert, errors.New("walk_minutes must not be negative")
+	}
+	alert.Time = t.Format("3:04PM")
+	now := clock().In(serviceTimeZone)
+	alert.departs = time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, serviceTimeZone)
+	if alert.departs.Before(now) {
+		alert.departs = alert.departs.AddDate(0, 0, 1)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.alerts) >= maxLeaveAlerts {
+		return alert, errTooManyLeaveAlerts
+	}
+	a.nextId++
+	alert.Id = strconv.Itoa(a.nextId)
+	a.alerts[alert.Id] = &alert
+	return alert, nil
+}
+
+// List returns the pending alerts, in the order they were added.
+func (a *LeaveAlerts) List() []LeaveAlert {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	alerts := []LeaveAlert{}
+	for _, alert := range a.alerts {
+		alerts = append(alerts, *alert)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		ii, _ := strconv.Atoi(alerts[i].Id)
+		jj, _ := strconv.Atoi(alerts[j].Id)
+		return ii < jj
+	})
+	return alerts
+}
+
+// Remove cancels the alert with the given ID, reporting whether it existed.
+func (a *LeaveAlerts) Remove(id string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, ok := a.alerts[id]
+	delete(a.alerts, id)
+	return ok
+}
+
+// Check fetches the departures for every stop with a pending alert and fires
+// (and removes) the alerts whose leave time has come. Alerts whose train has
+// left, or is no longer predicted after its scheduled time, are removed
+// without firing.
+func (a *LeaveAlerts) Check() {
+	byStop := map[string][]LeaveAlert{}
+	for _, alert := range a.List() {
+		byStop[alert.Stop] = append(byStop[alert.Stop], alert)
+	}
+	now := clock()
+	for stop, alerts := range byStop {
+		departures, err := a.service.ListDepartures(stop, Filter{Direction: "both"})
+		if departures == nil {
+			log.Printf("leave alerts: %v", err)
+			continue
+		}
+		for _, alert := range alerts {
+			d, ok := findDeparture(departures, alert)
+			if !ok {
+				if now.After(alert.departs) {
+					a.Remove(alert.Id)
+				}
+				continue
+			}
+			if now.After(d.Time) {
+				a.Remove(alert.Id)
+				continue
+			}
+			leave := d.Time.Add(-time.Duration(alert.WalkMinutes) * time.Minute)
+			if now.Before(leave) {
+				continue
+			}
+			if err := a.notifier.Notify(leaveNotification(alert, d)); err != nil {
+				// Leave it in place to try again on the next check.
+				log.Printf("leave alerts: %v", err)
+				continue
+			}
+			a.Remove(alert.Id)
+		}
+	}
+}
+
+// Run checks the alerts every interval until done is closed.
+func (a *LeaveAlerts) Run(interval time.Duration, done <-chan struct{}) {
+	poll(interval, done, a.Check)
+}
+
+// findDeparture returns the departure the alert is for, matching on the
+// destination and the scheduled time, or the predicted time if there's no
+// schedule.
+func findDeparture(departures []Departure, alert LeaveAlert) (Departure, bool) {
+	for _, d := range departures {
+		if d.Time.IsZero() || !strings.EqualFold(d.Destination, alert.Destination) {
+			continue
+		}
+		scheduled := d.ScheduledTime
+		if scheduled.IsZero() {
+			scheduled = d.Time
+		}
+		if serviceTimeLabel(scheduled) == alert.Time {
+			return d, true
+		}
+	}
+	return Departure{}, false
+}
+
+// leaveNotification returns the notification telling the user to leave for d.
+func leaveNotification(alert LeaveAlert, d Departure) Notification {
+	message := fmt.Sprintf("Leave now for the %s to %s", alert.Time, d.Destination)
+	if d.TimeLabel != alert.Time {
+		message += fmt.Sprintf(", now departing at %s", d.TimeLabel)
+	}
+	if d.Track != "TBD" {
+		message += fmt.Sprintf(" from track %s", d.Track)
+	}
+	return Notification{Title: "Time to leave", Message: message + "."}
+}
+
+// RegisterLeaveAlertRoutes adds the JSON API for managing leave alerts to the
+// router, requiring the token.
+func RegisterLeaveAlertRoutes(router gin.IRouter, token string, alerts *LeaveAlerts) {
+	admin := router.Group("", requireToken(token))
+	admin.GET("/api/v1/leave-alerts", func(c *gin.Context) {
+		c.JSON(http.StatusOK, alerts.List())
+	})
+	admin.POST("/api/v1/leave-alerts", func(c *gin.Context) {
+		var alert LeaveAlert
+		if err := c.BindJSON(&alert); err != nil {
+			return
+		}
+		alert, err := alerts.Add(alert)
+		switch {
+		case err == errTooManyLeaveAlerts:
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		case err != nil:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusCreated, alert)
+		}
+	})
+	admin.DELETE("/api/v1/leave-alerts/:id", func(c *gin.Context) {
+		if !alerts.Remove(c.Param("id")) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no such alert"})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingNotifier remembers the notifications it's sent.
+type recordingNotifier struct {
+	sent []Notification
+}
+
+func (r *recordingNotifier) Notify(n Notification) error {
+	r.sent = append(r.sent, n)
+	return nil
+}
+
+func TestLeaveAlertFollowsDelays(t *testing.T) {
+	defer func() { clock = time.Now }()
+	notifier := &recordingNotifier{}
+	alerts := NewLeaveAlerts(&MbtaServiceTest{"testdata/predictions-delayed.json"}, notifier)
+
+	// The 9:05 to Needham Heights is running about an hour and a half late.
+	clock = func() time.Time { return at("2018-09-10T08:55:00-04:00") }
+	_, err := alerts.Add(LeaveAlert{
+		Stop:        "place-sstat",
+		Destination: "needham heights",
+		Time:        "9:05 am",
+		WalkMinutes: 10,
+	})
+	assert.NoError(t, err)
+
+	alerts.Check()
+	assert.Empty(t, notifier.sent)
+
+	clock = func() time.Time { return at("2018-09-10T10:25:00-04:00") }
+	alerts.Check()
+	assert.Equal(t, []Notification{{
+		Title:   "Time to leave",
+		Message: "Leave now for the 9:05AM to Needham Heights, now departing at 10:34AM.",
+	}}, notifier.sent)
+	assert.Empty(t, alerts.List())
+}
+
+func TestLeaveAlertExpiry(t *testing.T) {
+	defer func() { clock = time.Now }()
+	notifier := &recordingNotifier{}
+	alerts := NewLeaveAlerts(&MbtaServiceTest{"testdata/predictions-delayed.json"}, notifier)
+	clock = func() time.Time { return at("2018-09-10T08:55:00-04:00") }
+	alerts.Add(LeaveAlert{Stop: "place-sstat", Destination: "Needham Heights", Time: "9:05AM"})
+	alerts.Add(LeaveAlert{Stop: "place-sstat", Destination: "Nowhere", Time: "9:00AM"})
+
+	// The alert for a train that isn't predicted goes once its time passes.
+	clock = func() time.Time { return at("2018-09-10T09:10:00-04:00") }
+	alerts.Check()
+	if assert.Len(t, alerts.List(), 1) {
+		assert.Equal(t, "Needham Heights", alerts.List()[0].Destination)
+	}
+
+	// The delayed train left without the alert firing, e.g. because the
+	// notifier was down.
+	clock = func() time.Time { return at("2018-09-10T10:40:00-04:00") }
+	alerts.Check()
+	assert.Empty(t, alerts.List())
+	assert.Empty(t, notifier.sent)
+
+	for i := 0; i < maxLeaveAlerts; i++ {
+		_, err := alerts.Add(LeaveAlert{Stop: "place-sstat", Destination: "Providence", Time: "1:05PM"})
+		assert.NoError(t, err)
+	}
+	_, err := alerts.Add(LeaveAlert{Stop: "place-sstat", Destination: "Providence", Time: "1:05PM"})
+	assert.Equal(t, errTooManyLeaveAlerts, err)
+}
+
+func TestLeaveAlertRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	RegisterLeaveAlertRoutes(router, "secret",
+		NewLeaveAlerts(&MbtaServiceTest{"testdata/predictions.json"}, &recordingNotifier{}))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/leave-alerts", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/leave-alerts?token=secret", bytes.NewBufferString(
+		`{"stop": "place-sstat", "destination": "Providence", "time": "1:05PM", "walk_minutes": 5}`)))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.JSONEq(t, `{"id": "1", "stop": "place-sstat", "destination": "Providence",
+		"time": "1:05PM", "walk_minutes": 5}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/leave-alerts?token=secret", bytes.NewBufferString(
+		`{"stop": "place-sstat", "destination": "Providence", "time": "soon"}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/leave-alerts?token=secret", nil))
+	assert.Contains(t, w.Body.String(), `"id":"1"`)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/v1/leave-alerts/1", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/v1/leave-alerts/1?token=secret", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/v1/leave-alerts/1?token=secret", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
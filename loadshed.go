@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"expvar"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxShedCacheEntries limits the number of responses a LoadShedder keeps, so
+// that requests with made-up query strings can't use up memory.
+const maxShedCacheEntries = 100
+
+// loadMetrics counts the requests shed by LoadShedders. They're published with
+// the other expvars at /debug/vars.
+var loadMetrics = expvar.NewMap("load")
+
+// LoadShedder limits the number of requests handled at once. Requests beyond
+// the limit wait up to Wait for a slot, and are then shed: they get the last
+// successful response to the same URL on the same host and tenant (in the same
+// format, for the board pages that negotiate it), headers included, if it's no
+// older than MaxAge, or a 503 telling the client to retry after RetryAfter.
+// One LoadShedder can be shared by the main boards and the tenants. This protects both the process and the
+// MBTA API rate limit from traffic spikes.
+type LoadShedder struct {
+	Wait       time.Duration
+	RetryAfter time.Duration
+	MaxAge     time.Duration
+
+	slots     chan struct{}
+	mu        sync.Mutex
+	responses map[string]shedResponse
+}
+
+// shedResponse is a successful response kept for serving shed requests, and
+// when it was kept.
+type shedResponse struct {
+	header http.Header
+	body   []byte
+	cached time.Time
+}
+
+// NewLoadShedder returns a LoadShedder that handles up to limit requests at
+// once, waiting a second for a slot, serving responses up to 30 seconds old
+// and asking shed clients to retry after five.
+func NewLoadShedder(limit int) *LoadShedder {
+	return &LoadShedder{
+		Wait:       time.Second,
+		RetryAfter: 5 * time.Second,
+		MaxAge:     30 * time.Second,
+		slots:      make(chan struct{}, limit),
+		responses:  map[string]shedResponse{},
+	}
+}
+
+// Middleware returns the gin middleware that applies the limit.
+func (l *LoadShedder) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timer := time.NewTimer(l.Wait)
+		defer timer.Stop()
+		select {
+		case l.slots <- struct{}{}:
+		case <-timer.C:
+			l.shed(c)
+			return
+		}
+		defer func() { <-l.slots }()
+
+		writer := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+		if writer.Status() == http.StatusOK {
+			l.mu.Lock()
+			now := clock()
+			key := shedKey(c)
+			if _, ok := l.responses[key]; !ok && len(l.responses) >= maxShedCacheEntries {
+				l.dropExpired(now)
+			}
+			if _, ok := l.responses[key]; ok || len(l.responses) < maxShedCacheEntries {
+				l.responses[key] = shedResponse{copyHeader(writer.Header()), writer.body.Bytes(), now}
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+// shed responds to a request that couldn't be handled.
+func (l *LoadShedder) shed(c *gin.Context) {
+	l.mu.Lock()
+	response, ok := l.responses[shedKey(c)]
+	l.mu.Unlock()
+	if ok && clock().Sub(response.cached) <= l.MaxAge {
+		loadMetrics.Add("shed_cached", 1)
+		for name, values := range response.header {
+			c.Writer.Header()[name] = values
+		}
+		c.Data(http.StatusOK, response.header.Get("Content-Type"), response.body)
+		c.Abort()
+		return
+	}
+	loadMetrics.Add("shed_rejected", 1)
+	c.Header("Retry-After", strconv.Itoa(int(l.RetryAfter/time.Second)))
+	c.AbortWithStatus(http.StatusServiceUnavailable)
+}
+
+// dropExpired drops the kept responses older than MaxAge. The caller must
+// hold l.mu.
+func (l *LoadShedder) dropExpired(now time.Time) {
+	for key, response := range l.responses {
+		if now.Sub(response.cached) > l.MaxAge {
+			delete(l.responses, key)
+		}
+	}
+}
+
+// shedKey returns the key of the response kept for the request: its host,
+// the prefix of its tenant, if any, its URL and, for the board pages, which
+// negotiate their format from the Accept header, the format.
+func shedKey(c *gin.Context) string {
+	key := c.Request.Host + c.GetString("prefix") + c.Request.URL.RequestURI()
+	if c.Param("stop") != "" {
+		key += " " + boardFormat(c)
+	}
+	return key
+}
+
+// copyHeader returns a copy of the header, which stays the same when the
+// original is changed.
+func copyHeader(header http.Header) http.Header {
+	copied := http.Header{}
+	for name, values := range header {
+		copied[name] = append([]string(nil), values...)
+	}
+	return copied
+}
+
+// recordingWriter keeps a copy of the response body as it's written.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadShedder(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	shedder := NewLoadShedder(1)
+	shedder.Wait = 10 * time.Millisecond
+	router.Use(shedder.Middleware())
+	started, release := make(chan struct{}), make(chan struct{})
+	router.GET("/slow", func(c *gin.Context) {
+		started <- struct{}{}
+		<-release
+		c.String(http.StatusOK, "slow")
+	})
+	router.GET("/fast", func(c *gin.Context) {
+		c.String(http.StatusOK, "fast")
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/fast", nil))
+	assert.Equal(t, "fast", w.Body.String())
+
+	// Hold the only slot.
+	done := make(chan struct{})
+	go func() {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+		close(done)
+	}()
+	<-started
+
+	// A URL that's been served before gets its last response...
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/fast", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "fast", w.Body.String())
+
+	// ...and any other is told to come back later.
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/fast?new=1", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "5", w.Header().Get("Retry-After"))
+
+	// Responses too old to pass for current aren't served either.
+	defer func() { clock = time.Now }()
+	clock = func() time.Time { return time.Now().Add(shedder.MaxAge + time.Second) }
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/fast", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	clock = time.Now
+
+	close(release)
+	<-done
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/fast?new=1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestLoadShedderNegotiatedFormat(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	shedder := NewLoadShedder(1)
+	shedder.Wait = 10 * time.Millisecond
+	router.Use(shedder.Middleware())
+	started, release := make(chan struct{}), make(chan struct{})
+	router.GET("/slow", func(c *gin.Context) {
+		started <- struct{}{}
+		<-release
+	})
+	router.GET("/board/:stop", func(c *gin.Context) {
+		c.Header("Vary", "Accept")
+		c.String(http.StatusOK, boardFormat(c))
+	})
+	get := func(accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/board/place-sstat", nil)
+		req.Header.Set("Accept", accept)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	assert.Equal(t, "json", get("application/json").Body.String())
+
+	done := make(chan struct{})
+	go func() {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+		close(done)
+	}()
+	<-started
+
+	// Browsers aren't sent the JSON kept for API clients, and its headers are
+	// kept with it.
+	w := get("application/json")
+	assert.Equal(t, "json", w.Body.String())
+	assert.Equal(t, "Accept", w.Header().Get("Vary"))
+	assert.Equal(t, http.StatusServiceUnavailable, get("text/html").Code)
+
+	close(release)
+	<-done
+}
@@ -1,85 +1,23 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
-	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/dghubble/sling"
 	"github.com/gin-gonic/gin"
-	"github.com/google/jsonapi"
+	"github.com/mattmckeon/splitflap/internal/mbta"
 )
 
-const MbtaApiV3BaseUrl = "https://api-v3.mbta.com/"
-
-// Prediction represents an MBTA API prediction and its relationships.
-// We only define the fields we need to unmarshal from the JSONAPI response.
-type Prediction struct {
-	Id            string    `jsonapi:"primary,prediction"`
-	DepartureTime string    `jsonapi:"attr,departure_time"`
-	Status        string    `jsonapi:"attr,status"`
-	Route         *Route    `jsonapi:"relation,route,omitempty"`
-	Trip          *Trip     `jsonapi:"relation,trip,omitempty"`
-	Stop          *Stop     `jsonapi:"relation,stop,omitempty"`
-	Schedule      *Schedule `jsonapi:"relation,schedule,omitempty"`
-}
-
-// Route represents a route as defined in the MBTA API.
-// We only define the fields we need to unmarshal from the JSONAPI response.
-type Route struct {
-	Id             string   `jsonapi:"primary,route"`
-	Type           int      `jsonapi:"attr,type"`
-	DirectionNames []string `jsonapi:"attr,direction_names"`
-}
-
-// Schedule represents a scheduled departure or arrival in the MBTA API.
-// We only define the fields we need to unmarshal from the JSONAPI response.
-type Schedule struct {
-	Id            string `jsonapi:"primary,schedule"`
-	DepartureTime string `jsonapi:"attr,departure_time"`
-}
-
-// Stop represents a stop or station as defined in the MBTA API.
-// We only define the fields we need to unmarshal from the JSONAPI response.
-type Stop struct {
-	Id           string `jsonapi:"primary,stop"`
-	PlatformCode string `jsonapi:"attr,platform_code"`
-}
-
-// Trip represents a journey as defined in the MBTA API.
-// We only define the fields we need to unmarshal from the JSONAPI response.
-type Trip struct {
-	Id          string `jsonapi:"primary,trip"`
-	Headsign    string `jsonapi:"attr,headsign"`
-	DirectionId int    `jsonapi:"attr,direction_id"`
-}
-
-// ApiV3Error is the base type used to unmarshall an error from MBTA APIv3.
-type ApiV3Error struct {
-	Errors []struct {
-		Status string `json:"status"`
-		Source struct {
-			Parameter string `json:"parameter"`
-		} `json:"source"`
-		Detail string `json:"detail"`
-		Code   string `json:"code"`
-	} `json:"errors"`
-}
-
-// Error implements the Golang error interface for ApiV3Error.
-func (e ApiV3Error) Error() string {
-	if len(e.Errors) == 1 {
-		return fmt.Sprintf("MBTA API error: %v", e.Errors[0].Detail)
-	} else {
-		return fmt.Sprintf("MBTA API error: %+v", e.Errors)
-	}
-}
+const MbtaApiV3BaseUrl = mbta.BaseUrl
 
 // ParseError is used to gather errors resulting from parsing the API response
 // to generate the departure board rows.
@@ -92,46 +30,310 @@ func (e ParseError) Error() string {
 	return fmt.Sprintf("Parse error: %+v", e.Errors)
 }
 
-// Params defines the query parameters sent via the Sling library.
-// The field tags map each value to a URL parameter.
-type Params struct {
-	Stop    string `url:"filter[stop],omitempty"`
-	Include string `url:"include,omitempty"`
-	Sort    string `url:"sort,omitempty"`
-}
-
-// Departure represents each row in our departure board.
+// Departure represents each row in our departure board. Route and Trip are the
+// IDs of its route and trip, and Line the route's name. Time is the predicted
+// departure time and ScheduledTime the scheduled one; either is zero if it's
+// unknown. Branch is the letter of the Green Line branch, for Green Line
+// departures. LastTrain is set on the last departure of the service day on its
+// line. DropOffOnly is set on trains that stop to let passengers off but can't
+// be boarded. Zone is the commuter rail fare zone of the destination and Fare
+// the fare to it from Boston, if fare zones are loaded. Provider is set on the
+// departures of merged stations to the name of the provider running them.
+// DelayReason explains why a late or cancelled train is late or cancelled, from
+// MBTA alerts, e.g. "signal problem".
 type Departure struct {
-	TimeLabel   string
-	Destination string
-	Track       string
-	Status      string
+	TimeLabel     string    `json:"time"`
+	Route         string    `json:"route,omitempty"`
+	Line          string    `json:"line,omitempty"`
+	Trip          string    `json:"trip,omitempty"`
+	Branch        string    `json:"branch,omitempty"`
+	Destination   string    `json:"destination"`
+	Track         string    `json:"track"`
+	Status        string    `json:"status"`
+	Direction     string    `json:"direction"`
+	Time          time.Time `json:"departure_time,omitzero"`
+	ScheduledTime time.Time `json:"scheduled_time,omitzero"`
+	LastTrain     bool      `json:"last_train,omitempty"`
+	DropOffOnly   bool      `json:"drop_off_only,omitempty"`
+	Zone          string    `json:"zone,omitempty"`
+	Fare          string    `json:"fare,omitempty"`
+	Provider      string    `json:"provider,omitempty"`
+	DelayReason   string    `json:"delay_reason,omitempty"`
 }
 
 // DepartureBoard encapsulates the title, rows, and any errors for each board.
+// ShowDirection adds a column with each departure's direction, for boards at
+// through-stations that show trains going both ways, and ShowBranch one with
+// each departure's Green Line branch. ShowProvider adds one with the provider
+// of each departure, for merged stations. ShowZone and ShowFare add columns with
+// each departure's fare zone and fare, and ShowScheduled ones with its
+// scheduled time and delay. StaleSince is set when the departures couldn't be
+// refreshed and are from an earlier fetch, and Unavailable lists the
+// providers of a merged station whose departures couldn't be fetched.
+// Operator messages and outages are shown in strips above the departures, and
+// Parking and Bikes, if set, in panels below them, followed by the Rotation
+// slot and a footnote with the Performance of the board's lines. Prefix is the
+// path prefix of the tenant the board is shown for, which its links start with.
 type DepartureBoard struct {
-	Title      string
-	Departures []Departure
-	Error      error
+	Title         string        `json:"title"`
+	Departures    []Departure   `json:"departures"`
+	Error         error         `json:"-"`
+	StaleSince    time.Time     `json:"stale_since,omitzero"`
+	Unavailable   []string      `json:"unavailable_providers,omitempty"`
+	Messages      []string      `json:"messages,omitempty"`
+	ShowDirection bool          `json:"-"`
+	ShowBranch    bool          `json:"-"`
+	ShowProvider  bool          `json:"-"`
+	ShowZone      bool          `json:"-"`
+	ShowFare      bool          `json:"-"`
+	ShowScheduled bool          `json:"-"`
+	Parking       []Parking     `json:"parking,omitempty"`
+	Outages       []Outage      `json:"outages,omitempty"`
+	Bikes         []BikeStation `json:"bikes,omitempty"`
+	Rotation      *Rotation     `json:"rotation,omitempty"`
+	Performance   []RouteStats  `json:"performance,omitempty"`
+	Prefix        string        `json:"-"`
+}
+
+// MarshalJSON implements the json.Marshaler interface for DepartureBoard,
+// replacing the board's error with its message.
+func (b DepartureBoard) MarshalJSON() ([]byte, error) {
+	type board DepartureBoard
+	var message string
+	if b.Error != nil {
+		message = b.Error.Error()
+	}
+	return json.Marshal(struct {
+		board
+		Error string `json:"error,omitempty"`
+	}{board(b), message})
+}
+
+// setDepartures sets the board's departures from the result of a service.
+// Stale results are shown along with how old they are rather than as an error,
+// and partial results from a merged station along with the providers missing.
+func (b *DepartureBoard) setDepartures(departures []Departure, err error) {
+	if stale, ok := err.(*StaleError); ok {
+		b.StaleSince = stale.Since.In(serviceTimeZone)
+		err = nil
+	}
+	if partial, ok := err.(*ProviderError); ok {
+		b.Unavailable = partial.Providers()
+		if !partial.StaleSince.IsZero() {
+			b.StaleSince = partial.StaleSince.In(serviceTimeZone)
+		}
+		err = nil
+	}
+	b.Departures, b.Error = departures, err
+	for _, d := range departures {
+		if d.Branch != "" {
+			b.ShowBranch = true
+		}
+		if d.Provider != "" {
+			b.ShowProvider = true
+		}
+	}
 }
 
+// Columns returns the number of columns in the board's table.
+func (b *DepartureBoard) Columns() int {
+	columns := 4
+	if b.ShowDirection {
+		columns++
+	}
+	if b.ShowBranch {
+		columns++
+	}
+	if b.ShowProvider {
+		columns++
+	}
+	if b.ShowZone {
+		columns++
+	}
+	if b.ShowFare {
+		columns++
+	}
+	if b.ShowScheduled {
+		columns += 2
+	}
+	return columns
+}
+
+// SplitBranches replaces each board showing Green Line departures with a
+// board per branch, in order of branch letter. Other boards are unchanged.
+func SplitBranches(boards []*DepartureBoard) []*DepartureBoard {
+	split := []*DepartureBoard{}
+	for _, board := range boards {
+		if !board.ShowBranch {
+			split = append(split, board)
+			continue
+		}
+		branches := map[string]*DepartureBoard{}
+		var letters []string
+		for _, d := range board.Departures {
+			b, ok := branches[d.Branch]
+			if !ok {
+				sub := *board
+				sub.Title = fmt.Sprintf("%s: %s Branch", board.Title, d.Branch)
+				sub.Departures = nil
+				b = &sub
+				branches[d.Branch] = b
+				letters = append(letters, d.Branch)
+			}
+			b.Departures = append(b.Departures, d)
+		}
+		sort.Strings(letters)
+		for _, letter := range letters {
+			split = append(split, branches[letter])
+		}
+	}
+	return split
+}
+
+// outageStations is the set of stops for which elevator and escalator outages
+// are fetched, configured by $OUTAGE_STATIONS.
+var outageStations = map[string]bool{}
+
+// Filter narrows down the departures shown on a board.
+type Filter struct {
+	// Window, if non-zero, limits the board to departures leaving within this
+	// long from now. The predictions endpoint has no time filter, so this is
+	// applied locally by ExtractDepartures.
+	Window time.Duration `json:"window,omitempty"`
+	// Direction is "outbound" (the default when empty), "inbound" or "both".
+	Direction string `json:"direction,omitempty"`
+	// Branch, if set, limits Green Line boards to the branch with this
+	// letter, such as "B".
+	Branch string `json:"branch,omitempty"`
+}
+
+// matchesDirection reports whether a departure in the direction with the given
+// route direction name (e.g. "Outbound") should be shown.
+func (f Filter) matchesDirection(name string) bool {
+	switch f.Direction {
+	case "both":
+		return true
+	case "":
+		return name == "Outbound"
+	default:
+		return strings.EqualFold(name, f.Direction)
+	}
+}
+
+// matchesBranch reports whether a departure on the given Green Line branch,
+// or "" for other routes, should be shown.
+func (f Filter) matchesBranch(branch string) bool {
+	return f.Branch == "" || branch == f.Branch
+}
+
+// matchesDeparture reports whether a departure that has already been
+// extracted, such as a recorded one, should be shown. Green Line departures
+// only carry their route's name for their direction, so it's mapped to the
+// name extractDepartures matches them by.
+func (f Filter) matchesDeparture(d Departure) bool {
+	direction := d.Direction
+	if name, ok := greenLineDirections[direction]; ok && d.Branch != "" {
+		direction = name
+	}
+	return f.matchesDirection(direction) && f.matchesBranch(d.Branch)
+}
+
+// filterDirections are the names that Filter.Direction gives each direction
+// id. Direction 0 is outbound on every route, even those that name it
+// differently, like the Green Line's "West".
+var filterDirections = []string{"Outbound", "Inbound"}
+
+// greenLineDirections are the names that Filter.Direction gives the Green
+// Line's directions, by the names of its directions.
+var greenLineDirections = map[string]string{"West": filterDirections[0], "East": filterDirections[1]}
+
+// ParseFilter returns a copy of defaults overridden by any filter parameters
+// in the request's query string (e.g. ?window=2h).
+func ParseFilter(c *gin.Context, defaults Filter) (Filter, error) {
+	filter := defaults
+	if window := c.Query("window"); window != "" {
+		d, err := time.ParseDuration(window)
+		if err != nil {
+			return filter, fmt.Errorf("invalid window %q", window)
+		}
+		filter.Window = d
+	}
+	if direction := c.Query("direction"); direction != "" {
+		switch direction {
+		case "outbound", "inbound", "both":
+			filter.Direction = direction
+		default:
+			return filter, fmt.Errorf("invalid direction %q", direction)
+		}
+	}
+	if branch := c.Query("branch"); branch != "" {
+		switch strings.ToUpper(branch) {
+		case "B", "C", "D", "E":
+			filter.Branch = strings.ToUpper(branch)
+		default:
+			return filter, fmt.Errorf("invalid branch %q", branch)
+		}
+	}
+	return filter, nil
+}
+
+// clock returns the current time. It's a variable so tests can stop it.
+var clock = time.Now
+
 // MbtaService is a base interface for fetching and parsing departures.
 type MbtaService interface {
-	ListDepartures(place string) ([]Departure, error)
+	ListDepartures(place string, filter Filter) ([]Departure, error)
+}
+
+// unwrapService returns the service underneath any wrappers such as
+// CachingService, which only wrap ListDepartures, so that its optional
+// capabilities can be used.
+func unwrapService(service MbtaService) MbtaService {
+	for {
+		wrapper, ok := service.(interface{ Unwrap() MbtaService })
+		if !ok {
+			return service
+		}
+		service = wrapper.Unwrap()
+	}
 }
 
-// MbtaServiceImpl wraps the Sling request handle and underlying http client.
+// PredictionBatcher is an interface for services that can fetch the
+// predictions for several stops in a single request. Boards showing more than
+// one stop use it, if the service supports it, so that refreshing them only
+// costs one API call. Stops missing from the result are fetched separately.
+type PredictionBatcher interface {
+	BatchPredictions(places []string) (map[string][]*mbta.Prediction, error)
+}
+
+// errNoBatching is returned by wrappers' BatchPredictions methods when the
+// service they wrap can't batch predictions, so that the boards are fetched
+// one at a time instead.
+var errNoBatching = errors.New("service can't batch predictions")
+
+// MbtaServiceImpl implements the services on top of the MBTA APIv3 client.
+// Schedules, if set, is where the last trains are found from instead of the
+// API, such as a SchedulePrecomputer wrapping this service.
 type MbtaServiceImpl struct {
-	sling  *sling.Sling
-	client *http.Client
+	Schedules DatedScheduleService
+
+	mbta         *mbta.Client
+	lastTrips    lastTripCache
+	delayReasons delayReasonCache
 }
 
 // NewMbtaServiceImpl creates and returns a new instance of MbtaServiceImpl
-// (visible so we can pass mocks for testing).
+// (visible so we can pass mocks for testing). If the API_KEY environment
+// variable is set, it is sent with every request.
 func NewMbtaServiceImpl(httpClient *http.Client) *MbtaServiceImpl {
+	return NewMbtaServiceImplWithKey(httpClient, os.Getenv("API_KEY"))
+}
+
+// NewMbtaServiceImplWithKey is NewMbtaServiceImpl with an explicit API key,
+// for tenants with their own.
+func NewMbtaServiceImplWithKey(httpClient *http.Client, apiKey string) *MbtaServiceImpl {
 	return &MbtaServiceImpl{
-		sling:  sling.New().Client(httpClient).Base(MbtaApiV3BaseUrl),
-		client: httpClient,
+		mbta: mbta.NewClient(httpClient, apiKey),
 	}
 }
 
@@ -145,38 +347,33 @@ func NewHttpClient() *http.Client {
 // ListDepartures is an implementation of the MbtaService ListDepartures method
 // that fetches commuter departure board information from the MBTA APIv3
 // predictions endpoint.
-func (s *MbtaServiceImpl) ListDepartures(place string) ([]Departure, error) {
-	sling := s.sling.New().Path("predictions").QueryStruct(&Params{
-		Stop:    place,
-		Include: "route,stop,trip,schedule",
-		Sort:    "departure_time",
-	})
+func (s *MbtaServiceImpl) ListDepartures(place string, filter Filter) ([]Departure, error) {
+	predictions, err := s.predictions(place)
+	if err != nil {
+		return nil, err
+	}
+	return extractDepartures(predictions, filter, lastTripsFor(s, place))
+}
 
-	// Dump the request to logs for debugging
-	req, err := sling.Request()
-	fmt.Printf("request: %v", req)
-
-	// Unfortunately the Golang JSONAPI library is intended for services, so the
-	// response parsing doesn't handle errors as gracefully as we'd like.
-	// We need to check the status code and try to unmarshall any errors we find.
-	resp, err := s.client.Do(req)
-	if err == nil {
-		defer resp.Body.Close()
-		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			var apiError = new(ApiV3Error)
-			err = json.NewDecoder(resp.Body).Decode(apiError)
-			if err == nil {
-				err = apiError
-			}
-		} else {
-			rawPredictions, err := jsonapi.UnmarshalManyPayload(
-				resp.Body, reflect.TypeOf(new(Prediction)))
-			if err == nil {
-				return ExtractDepartures(AsPredictions(rawPredictions))
-			}
-		}
+// BatchPredictions is an implementation of the PredictionBatcher
+// BatchPredictions method that fetches the predictions for all the places
+// from the MBTA APIv3 predictions endpoint in one request.
+func (s *MbtaServiceImpl) BatchPredictions(places []string) (map[string][]*mbta.Prediction, error) {
+	predictions, err := s.predictions(places...)
+	if err != nil {
+		return nil, err
 	}
-	return nil, err
+	return GroupByStop(predictions, places), nil
+}
+
+// predictions fetches the revenue predictions for the given places, with the
+// relationships ExtractDepartures needs.
+func (s *MbtaServiceImpl) predictions(places ...string) ([]*mbta.Prediction, error) {
+	return s.mbta.Predictions(
+		mbta.Filter("stop", places...),
+		mbta.Filter("revenue", "REVENUE"),
+		mbta.Include("route", "stop", "trip", "schedule"),
+		mbta.Sort("departure_time"))
 }
 
 // MbtaServiceTest is a test version of MbtaService useful for testing with
@@ -188,133 +385,818 @@ type MbtaServiceTest struct {
 // ListDepartures is an implementation of the MbtaService ListDepartures method
 // that ignores the provided place and loads test data from this test service's
 // JsonFile.
-func (s *MbtaServiceTest) ListDepartures(place string) ([]Departure, error) {
-	f, err := os.Open(s.JsonFile)
-	if err != nil {
+func (s *MbtaServiceTest) ListDepartures(place string, filter Filter) ([]Departure, error) {
+	var predictions []*mbta.Prediction
+	if err := s.load(&predictions); err != nil {
 		return nil, err
 	}
-	defer f.Close()
+	return ExtractDepartures(predictions, filter)
+}
 
-	byteValue, err := ioutil.ReadAll(f)
-	var apiError = new(ApiV3Error)
-	err = json.Unmarshal(byteValue, apiError)
-	if err != nil {
+// BatchPredictions is an implementation of the PredictionBatcher
+// BatchPredictions method that loads test data from this test service's
+// JsonFile and returns all of it for each place.
+func (s *MbtaServiceTest) BatchPredictions(places []string) (map[string][]*mbta.Prediction, error) {
+	var predictions []*mbta.Prediction
+	if err := s.load(&predictions); err != nil {
 		return nil, err
 	}
-	if len(apiError.Errors) > 0 {
-		return nil, apiError
+	batch := map[string][]*mbta.Prediction{}
+	for _, place := range places {
+		batch[place] = predictions
 	}
-	rawPredictions, err := jsonapi.UnmarshalManyPayload(
-		bytes.NewReader(byteValue), reflect.TypeOf(new(Prediction)))
-	if err == nil {
-		return ExtractDepartures(AsPredictions(rawPredictions))
+	return batch, nil
+}
+
+// load reads this test service's JsonFile into v, a pointer to a slice of MBTA
+// resources, or returns the error it contains if it's an API error response.
+func (s *MbtaServiceTest) load(v interface{}) error {
+	f, err := os.Open(s.JsonFile)
+	if err != nil {
+		return err
 	}
-	return nil, err
+	defer f.Close()
+	return mbta.Decode(f, v)
 }
 
-// AsPredictions casts the raw unmarshalled JSON payload to the correct type.
-func AsPredictions(rawPredictions []interface{}) []*Prediction {
-	predictions := make([]*Prediction, len(rawPredictions))
-	for i := range rawPredictions {
-		predictions[i] = rawPredictions[i].(*Prediction)
+// GroupByStop sorts predictions into the places they were requested for. A
+// prediction's stop is usually a platform, so it's matched against the places
+// by its own ID and its parent station's. Predictions without a stop can't be
+// placed unless only one place was requested.
+func GroupByStop(predictions []*mbta.Prediction, places []string) map[string][]*mbta.Prediction {
+	groups := map[string][]*mbta.Prediction{}
+	for _, place := range places {
+		groups[place] = []*mbta.Prediction{}
 	}
-	return predictions
+	for _, prediction := range predictions {
+		if len(places) == 1 {
+			groups[places[0]] = append(groups[places[0]], prediction)
+			continue
+		}
+		stop := prediction.Stop
+		if stop == nil {
+			continue
+		}
+		if _, ok := groups[stop.Id]; ok {
+			groups[stop.Id] = append(groups[stop.Id], prediction)
+		} else if stop.ParentStation != nil {
+			if _, ok := groups[stop.ParentStation.Id]; ok {
+				groups[stop.ParentStation.Id] = append(groups[stop.ParentStation.Id], prediction)
+			}
+		}
+	}
+	return groups
 }
 
 // ExtractDepartures is a helper function that extracts fields from an
 // unmarshalled JSONAPI payload and returns a slice of rows corresponding to
-// upcoming commuter rail departures. It assumes that the payload is a slice of
-// pointers to
-func ExtractDepartures(predictions []*Prediction) ([]Departure, error) {
+// upcoming commuter rail departures that match the filter. It assumes that the
+// payload is a slice of pointers to
+func ExtractDepartures(predictions []*mbta.Prediction, filter Filter) ([]Departure, error) {
+	return extractDepartures(predictions, filter, nil)
+}
+
+// extractDepartures is ExtractDepartures, also marking the departures of the
+// given last trips of the day.
+func extractDepartures(predictions []*mbta.Prediction, filter Filter, lastTrips map[string]bool) ([]Departure, error) {
 	departures := []Departure{}
-	parseError := new(ParseError)
+	var parseError *ParseError
+	branches := false
+	var cutoff time.Time
+	if filter.Window > 0 {
+		cutoff = clock().Add(filter.Window)
+	}
 	for _, prediction := range predictions {
 		// We only want trains that match the following:
 		// ✔ Have a valid departure time
-		// ✔ On a commuter rail route (route.type == 2)
-		// ✔ Are on an outbound trip
+		// ✔ On a commuter rail route (route.type == 2), or a branch of the
+		//   Green Line in the filter's branch
+		// ✔ Are in the filter's direction (outbound by default)
+		// ✔ Are in revenue service (not deadheading to or from the yard).
+		//   We ask the API to leave these out too, but check here so that
+		//   canned responses are handled the same way.
+		// ✔ Aren't arriving at the end of the line, even if the prediction
+		//   has a departure time. Trains that can't be boarded elsewhere are
+		//   marked drop-off only.
+		direction, ok := mbta.DirectionName(prediction.Route, prediction.Trip)
+		if !ok {
+			log.Printf("payload: skipping incomplete prediction %s", prediction.Id)
+			mbta.PayloadMetrics.Add("incomplete_predictions", 1)
+			continue
+		}
+		branch, greenLine := mbta.GreenLineBranch(prediction.Route)
+		filterDirection := direction
+		if greenLine && prediction.Trip.DirectionId < len(filterDirections) {
+			filterDirection = filterDirections[prediction.Trip.DirectionId]
+		}
 		if prediction.DepartureTime != "" &&
-			prediction.Route.Type == 2 &&
-			prediction.Route.DirectionNames[prediction.Trip.DirectionId] == "Outbound" {
+			(prediction.Route.Type == 2 || greenLine) &&
+			prediction.Revenue != "NON_REVENUE" &&
+			filter.matchesDirection(filterDirection) &&
+			filter.matchesBranch(branch) &&
+			!endOfLine(prediction.Schedule) {
+			pt, pterr := parseServiceTime(prediction.DepartureTime)
+			if pterr == nil && !cutoff.IsZero() && pt.After(cutoff) {
+				continue
+			}
 			d := Departure{}
 			d.Destination = prediction.Trip.Headsign
-			pt, pterr := time.Parse(time.RFC3339, prediction.DepartureTime)
+			d.Route = prediction.Route.Id
+			d.Line = prediction.Route.LongName
+			d.Trip = prediction.Trip.Id
+			d.Branch = branch
+			branches = branches || greenLine
+			d.LastTrain = lastTrips[prediction.Trip.Id]
+			d.DropOffOnly = !prediction.Schedule.PickupAllowed()
+			if !greenLine {
+				d.Zone = fareZones.Zone(d.Destination)
+				d.Fare = zoneFares[d.Zone]
+			}
 			if pterr == nil {
-				d.TimeLabel = pt.Format("3:04PM")
+				d.Time = pt
+				d.TimeLabel = serviceTimeLabel(pt)
 			} else {
 				err := fmt.Errorf("(Parse Error) %s", prediction.DepartureTime)
+				if parseError == nil {
+					parseError = new(ParseError)
+				}
 				parseError.Errors = append(parseError.Errors, err)
 				d.TimeLabel = err.Error()
 			}
+			if prediction.Schedule != nil {
+				st, sterr := parseServiceTime(prediction.Schedule.DepartureTime)
+				if sterr == nil {
+					d.ScheduledTime = st
+				}
+			}
 			d.Status = prediction.Status
-			if d.Status == "" && pterr == nil && prediction.Schedule != nil {
+			if d.Status == "" && pterr == nil && !d.ScheduledTime.IsZero() &&
+				pt.After(d.ScheduledTime) {
 				// It's possible this is a delayed train, and we should reflect that.
-				st, sterr := time.Parse(time.RFC3339, prediction.Schedule.DepartureTime)
-				if sterr == nil && pt.After(st) {
-					d.Status = "Delayed"
-				}
+				d.Status = "Delayed"
+			}
+			d.Direction = direction
+			if prediction.Stop != nil {
+				d.Track = prediction.Stop.PlatformCode
 			}
-			d.Track = prediction.Stop.PlatformCode
 			if d.Track == "" {
 				d.Track = "TBD"
 			}
 			departures = append(departures, d)
 		}
 	}
-	if len(parseError.Errors) > 0 {
+	// Group Green Line departures by branch. Other departures have no branch
+	// and stay in order of departure time, so commuter rail boards, the
+	// common case, skip the sort and the allocations it makes.
+	if branches {
+		sort.SliceStable(departures, func(i, j int) bool {
+			return departures[i].Branch < departures[j].Branch
+		})
+	}
+	if parseError != nil {
 		return departures, parseError
 	} else {
 		return departures, nil
 	}
 }
 
-// Render is a helper function that fetches departures from the given service
-// and outputs the corresponding HTML to the gin Context.
-func Render(c *gin.Context, client MbtaService) {
-	northStation := &DepartureBoard{
-		Title: "North Station Information",
+// endOfLine reports whether the scheduled stop is an arrival at the end of
+// the line, which has no departure time and can't be boarded.
+func endOfLine(schedule *mbta.Schedule) bool {
+	return schedule != nil && schedule.DepartureTime == "" && !schedule.PickupAllowed()
+}
+
+// BoardDefinition describes a board: its title, the stop whose departures it
+// shows and how they're filtered. Fares is "zone" to show the fare zone of
+// each departure's destination, or "estimate" to show the fare as well.
+// Layout is "compare" to show the scheduled time of each departure next to
+// the predicted one.
+type BoardDefinition struct {
+	Title  string
+	Stop   string
+	Filter Filter
+	Fares  string
+	Layout string
+}
+
+// DefaultBoards are the boards shown on the main page.
+var DefaultBoards = []BoardDefinition{
+	{Title: "North Station Information", Stop: "place-north"},
+	{Title: "South Station Information", Stop: "place-sstat"},
+}
+
+// StopBoard returns the definition of the board for the request's :stop
+// parameter, less any format extension. The title defaults to the stop ID and
+// can be set with ?title=.
+func StopBoard(c *gin.Context, defaults Filter) BoardDefinition {
+	return BoardDefinition{
+		Title:  c.DefaultQuery("title", stopParam(c)),
+		Stop:   stopParam(c),
+		Filter: defaults,
+	}
+}
+
+// FetchBoards fetches each of the defined boards from the given service. Each
+// board's filter, fares and layout can be overridden by the request's query
+// string, and an error is returned if the overrides are invalid. If the
+// service is a PredictionBatcher, the departures for all the boards are
+// fetched at once, except for any stops it leaves out of the batch.
+func FetchBoards(c *gin.Context, client MbtaService, defs []BoardDefinition) ([]*DepartureBoard, error) {
+	defs = append([]BoardDefinition(nil), defs...)
+	places := []string{}
+	seen := map[string]bool{}
+	for i := range defs {
+		filter, err := ParseFilter(c, defs[i].Filter)
+		if err != nil {
+			return nil, err
+		}
+		defs[i].Filter = filter
+		if fares := c.Query("fares"); fares != "" {
+			defs[i].Fares = fares
+		}
+		if !validFares(defs[i].Fares) {
+			return nil, fmt.Errorf("invalid fares %q", defs[i].Fares)
+		}
+		if layout := c.Query("layout"); layout != "" {
+			defs[i].Layout = layout
+		}
+		if !validLayout(defs[i].Layout) {
+			return nil, fmt.Errorf("invalid layout %q", defs[i].Layout)
+		}
+		if !seen[defs[i].Stop] {
+			seen[defs[i].Stop] = true
+			places = append(places, defs[i].Stop)
+		}
+	}
+
+	boards := make([]*DepartureBoard, len(defs))
+	var batch map[string][]*mbta.Prediction
+	err := errNoBatching
+	if batcher, ok := client.(PredictionBatcher); ok && len(places) > 1 {
+		batch, err = batcher.BatchPredictions(places)
+	}
+	if err == errNoBatching {
+		for i, def := range defs {
+			boards[i] = FetchBoard(c, client, def)
+		}
+		return boards, nil
+	}
+	_, stale := err.(*StaleError)
+	for i, def := range defs {
+		predictions, batched := batch[def.Stop]
+		if !batched && (err == nil || stale) {
+			// Stops the service left out of the batch, such as merged
+			// stations, are fetched on their own.
+			boards[i] = FetchBoard(c, client, def)
+			continue
+		}
+		boards[i] = newBoard(def)
+		if err != nil && !stale {
+			boards[i].Error = err
+		} else {
+			departures, parseErr := extractDepartures(predictions, def.Filter,
+				lastTripsFor(client, def.Stop))
+			if parseErr == nil {
+				parseErr = err
+			}
+			boards[i].setDepartures(departures, parseErr)
+		}
+		fetchExtras(c, client, def.Stop, boards[i])
+		recordBoard(c, def, boards[i])
+	}
+	return boards, nil
+}
+
+// FetchBoard fetches the departures for a board from the given service, along
+// with any active operator messages and the optional extras the service
+// supports and that are enabled: outages for the stops in outageStations,
+// parking if the request has ?parking=1, nearby Bluebikes stations if the
+// bluebikes provider is configured, the performance of its lines if the
+// request has ?performance=1, and the reasons for any delays.
+// Failing to fetch an extra is logged but doesn't fail the board.
+func FetchBoard(c *gin.Context, client MbtaService, def BoardDefinition) *DepartureBoard {
+	board := newBoard(def)
+	board.setDepartures(client.ListDepartures(def.Stop, def.Filter))
+	fetchExtras(c, client, def.Stop, board)
+	recordBoard(c, def, board)
+	return board
+}
+
+// recordBoard records a freshly fetched board for the status page, the board
+// history, the delay statistics and the CDN cache purger, unless it was
+// rendered from a fixture.
+func recordBoard(c *gin.Context, def BoardDefinition, board *DepartureBoard) {
+	if c.GetBool("fixture") {
+		return
+	}
+	delayHistory.ObserveBoard(board)
+	statusTracker.RecordBoard(def.Stop, board)
+	boardHistory.Record(def.Stop, board)
+	if cachePurger != nil {
+		cachePurger.Observe(def, board)
 	}
-	southStation := &DepartureBoard{
-		Title: "South Station Information",
+}
+
+// newBoard returns an empty board for the definition.
+func newBoard(def BoardDefinition) *DepartureBoard {
+	return &DepartureBoard{
+		Title:         def.Title,
+		ShowDirection: def.Filter.Direction == "both",
+		ShowZone:      def.Fares != "",
+		ShowFare:      def.Fares == "estimate",
+		ShowScheduled: def.Layout == "compare",
+	}
+}
+
+// fetchExtras adds the extras described in FetchBoard to a board showing the
+// given stop, and the request's tenant prefix. Extras are fetched from the
+// service underneath any wrappers.
+func fetchExtras(c *gin.Context, client MbtaService, stop string, board *DepartureBoard) {
+	board.Prefix = c.GetString("prefix")
+	now := clock()
+	board.Messages = boardMessages.Active(stop, now)
+	client = unwrapService(client)
+	var err error
+	if outages, ok := client.(OutageService); ok && outageStations[stop] {
+		if board.Outages, err = outages.ListOutages(stop); err != nil {
+			log.Printf("outages: %v", err)
+		}
+	}
+	if ps, ok := client.(ParkingService); ok && c.Query("parking") != "" {
+		if board.Parking, err = ps.ListParking(stop); err != nil {
+			log.Printf("parking: %v", err)
+		}
+	}
+	if locator, ok := client.(StopLocator); ok && bluebikes != nil {
+		lat, lon, err := locator.LocateStop(stop)
+		if err == nil {
+			board.Bikes, err = bluebikes.NearbyStations(lat, lon)
+		}
+		if err != nil {
+			log.Printf("bluebikes: %v", err)
+		}
+	}
+	if rs, ok := client.(DelayReasonService); ok {
+		if routes := delayedRoutes(board.Departures); len(routes) > 0 {
+			reasons, err := rs.DelayReasons(routes)
+			if err != nil {
+				log.Printf("delay reasons: %v", err)
+			} else {
+				board.Departures = withDelayReasons(board.Departures, reasons)
+			}
+		}
+	}
+	if routes := boardRoutes(board); len(routes) > 0 && c.Query("performance") != "" {
+		board.Performance = delayHistory.Stats(routes, now)
+	}
+	board.Rotation = NewRotation(rotationItems(board, now), rotationInterval, now)
+}
+
+// Render is a helper function that fetches the defined boards from the given
+// service and outputs the corresponding HTML to the gin Context.
+func Render(c *gin.Context, client MbtaService, defs []BoardDefinition) {
+	boards, err := FetchBoards(c, client, defs)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if c.Query("split") == "branch" {
+		boards = SplitBranches(boards)
 	}
-	northStation.Departures, northStation.Error =
-		client.ListDepartures("place-north")
-	southStation.Departures, southStation.Error =
-		client.ListDepartures("place-sstat")
 	c.HTML(http.StatusOK, "index.tmpl.html", gin.H{
-		"northStation": northStation,
-		"southStation": southStation,
+		"boards": boards,
+		"og":     NewOpenGraph(c, defs[0], boards[0]),
+		"theme":  c.GetString("theme"),
 	})
 }
 
-func main() {
-	port := os.Getenv("PORT")
+// RenderJson is the JSON API equivalent of Render.
+func RenderJson(c *gin.Context, client MbtaService, defs []BoardDefinition) {
+	boards, err := FetchBoards(c, client, defs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if c.Query("split") == "branch" {
+		boards = SplitBranches(boards)
+	}
+	c.JSON(http.StatusOK, boards)
+}
 
-	if port == "" {
-		log.Fatal("$PORT must be set")
+// RenderBoardJson is the JSON API equivalent of RenderBoard, which returns the
+// board itself rather than a list of boards.
+func RenderBoardJson(c *gin.Context, client MbtaService, defaults Filter) {
+	boards, err := FetchBoards(c, client, []BoardDefinition{StopBoard(c, defaults)})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
+	c.JSON(http.StatusOK, boards[0])
+}
 
+// NewBoardRouter returns a router serving the defined boards from the given
+// service, with the middleware every set of boards shares: request logging,
+// any given middleware, the development mode middleware, the load shedder and
+// the CDN caching headers. It serves the boards at /, any stop at
+// /board/:stop with its link preview image, the GIF and SVG renderings and
+// the JSON API. The group the pages are in is returned so that more can be
+// added to it.
+func NewBoardRouter(service MbtaService, defs []BoardDefinition, defaults Filter, shedder *LoadShedder, middleware ...gin.HandlerFunc) (*gin.Engine, *gin.RouterGroup) {
 	router := gin.New()
 	router.Use(gin.Logger())
-	router.LoadHTMLGlob("templates/*.tmpl.html")
+	router.Use(middleware...)
+	if devMode {
+		router.Use(devMiddleware())
+	}
+	LoadTemplates(router, "templates")
 	router.Static("/static", "static")
+	pages := router.Group("", shedder.Middleware(), CacheHeaders(cacheMaxAge))
 
 	// The main route
-	router.GET("/", func(c *gin.Context) {
-		Render(c, NewMbtaServiceImpl(NewHttpClient()))
+	pages.GET("/", func(c *gin.Context) {
+		Render(c, service, defs)
+	})
+
+	// A single board for any stop, e.g. /board/place-bbsta?direction=both, as
+	// HTML, JSON, text or iCalendar depending on the Accept header or an
+	// extension such as /board/place-bbsta.ics
+	pages.GET("/board/:stop", func(c *gin.Context) {
+		RenderBoard(c, service, defaults)
+	})
+
+	// The link preview image for /board/:stop
+	pages.GET("/board/:stop/og.png", func(c *gin.Context) {
+		RenderBoardOgImage(c, service, defaults)
 	})
 
-	// A test route that returns canned prediction data.
-	// Useful for tweaking CSS changes.
-	router.GET("/test", func(c *gin.Context) {
-		Render(c, &MbtaServiceTest{"testdata/predictions-delayed.json"})
+	// An animated GIF of the recent changes to a board, e.g.
+	// /board.gif?stop=place-bbsta
+	pages.GET("/board.gif", func(c *gin.Context) {
+		RenderBoardGif(c, service, defs)
 	})
 
-	// A test route that returns an API error.
-	// Useful for tweaking CSS changes.
-	router.GET("/testerror", func(c *gin.Context) {
-		Render(c, &MbtaServiceTest{"testdata/error-429.json"})
+	// A scalable rendering of a board, e.g.
+	// /board.svg?stop=place-bbsta&theme=light&width=800
+	pages.GET("/board.svg", func(c *gin.Context) {
+		RenderBoardSvg(c, service, defs)
+	})
+
+	// The JSON equivalent of /
+	pages.GET("/api/v1/boards", func(c *gin.Context) {
+		RenderJson(c, service, defs)
+	})
+
+	// The JSON equivalent of /board/:stop
+	pages.GET("/api/v1/board/:stop", func(c *gin.Context) {
+		RenderBoardJson(c, service, defaults)
+	})
+	return router, pages
+}
+
+// registerTripRoutes adds the schedule page and the trip pages, which the
+// boards link to, to the pages of a set of boards. Either service may be nil
+// if it isn't available.
+func registerTripRoutes(pages gin.IRouter, schedules ScheduleService, trips TripService, defaults Filter) {
+	// The day's scheduled departures for a stop, grouped by line
+	if schedules != nil {
+		pages.GET("/schedule/:stop", func(c *gin.Context) {
+			RenderSchedule(c, schedules, defaults)
+		})
+	}
+
+	// Where a train is and the stops it has left, e.g. /train/CR-Weekday-Fall-18-515
+	if trips != nil {
+		pages.GET("/train/:trip", func(c *gin.Context) {
+			RenderTrip(c, trips, false)
+		})
+		pages.GET("/api/v1/train/:trip", func(c *gin.Context) {
+			RenderTrip(c, trips, true)
+		})
+	}
+}
+
+func main() {
+	// $SIMULATE replaces the MBTA API with a simulated day of departures,
+	// starting at 6AM and running the given number of times faster than real
+	// time.
+	live := NewMbtaServiceImpl(NewHttpClient())
+	var source MbtaService = live
+	var schedules ScheduleService = live
+	if speed := os.Getenv("SIMULATE"); speed != "" {
+		factor, err := strconv.ParseFloat(speed, 64)
+		if err != nil || factor <= 0 {
+			log.Fatalf("invalid $SIMULATE: %q", speed)
+		}
+		y, m, d := time.Now().In(serviceTimeZone).Date()
+		simulator := NewSimulator(time.Date(y, m, d, 6, 0, 0, 0, serviceTimeZone), factor)
+		clock = simulator.Now
+		source, schedules = simulator, simulator
+	}
+
+	// $REPLAY plays back a recording made with $RECORD, $REPLAY_SPEED times
+	// faster than real time.
+	if path := os.Getenv("REPLAY"); path != "" {
+		speed := 1.0
+		if s := os.Getenv("REPLAY_SPEED"); s != "" {
+			var err error
+			if speed, err = strconv.ParseFloat(s, 64); err != nil || speed <= 0 {
+				log.Fatalf("invalid $REPLAY_SPEED: %q", s)
+			}
+		}
+		replay, err := LoadReplay(path, speed)
+		if err != nil {
+			log.Fatalf("invalid $REPLAY: %v", err)
+		}
+		clock = replay.Now
+		source = replay
+	}
+
+	// $RECORD appends the departures shown to a recording at the given path.
+	if path := os.Getenv("RECORD"); path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Fatalf("invalid $RECORD: %v", err)
+		}
+		defer f.Close()
+		source = NewRecorder(source, f)
+	}
+
+	// $MESSAGES_FILE saves the operator messages shown on boards, which are
+	// managed through the API if $ADMIN_TOKEN is set.
+	if path := os.Getenv("MESSAGES_FILE"); path != "" {
+		store, err := NewMessageStore(path)
+		if err != nil {
+			log.Fatalf("invalid $MESSAGES_FILE: %v", err)
+		}
+		boardMessages = store
+	}
+
+	// $STATS_FILE saves the delays of the departures shown, from which the
+	// statistics at /api/v1/stats are computed, every minute.
+	if path := os.Getenv("STATS_FILE"); path != "" {
+		history, err := NewDelayHistory(path)
+		if err != nil {
+			log.Fatalf("invalid $STATS_FILE: %v", err)
+		}
+		delayHistory = history
+	}
+	go poll(time.Minute, nil, delayHistory.Save)
+
+	// $ROTATION is a comma-separated list of the sources of the items in the
+	// rotating slot on each board, which changes every $ROTATION_INTERVAL
+	// (10s by default). $ROTATION_TEXT is a "|"-separated list of custom items
+	// for the "text" source.
+	if sources := os.Getenv("ROTATION"); sources != "" {
+		rotationSources = strings.Split(sources, ",")
+		if err := ValidateRotationSources(rotationSources); err != nil {
+			log.Fatalf("invalid $ROTATION: %v", err)
+		}
+	}
+	if interval := os.Getenv("ROTATION_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			log.Fatalf("invalid $ROTATION_INTERVAL: %q", interval)
+		}
+		rotationInterval = d
+	}
+	rotationText = parseRotationText(os.Getenv("ROTATION_TEXT"))
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "once":
+			err := runOnce(source, os.Args[2:], os.Stdout)
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "stream":
+			err := runStream(source, os.Args[2:], os.Stdout, interrupted())
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "daemon":
+			err := runDaemon(source, os.Args[2:], interrupted())
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "doctor":
+			err := runDoctor(live, os.Args[2:], os.Stdout)
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
+	flag.BoolVar(&devMode, "dev", false,
+		"reload templates on every request, show full errors and enable the fixture routes")
+	flag.Parse()
+
+	port := os.Getenv("PORT")
+
+	if port == "" {
+		log.Fatal("$PORT must be set")
+	}
+
+	// $DEPARTURE_WINDOW sets the default time window for the boards, e.g. 2h.
+	var defaults Filter
+	if window := os.Getenv("DEPARTURE_WINDOW"); window != "" {
+		d, err := time.ParseDuration(window)
+		if err != nil {
+			log.Fatalf("invalid $DEPARTURE_WINDOW: %v", err)
+		}
+		defaults.Window = d
+	}
+	boards := make([]BoardDefinition, len(DefaultBoards))
+	for i, def := range DefaultBoards {
+		def.Filter = defaults
+		boards[i] = def
+	}
+
+	// $OUTAGE_STATIONS is a comma-separated list of stops to show elevator
+	// and escalator outages for.
+	for _, stop := range strings.Split(os.Getenv("OUTAGE_STATIONS"), ",") {
+		if stop = strings.TrimSpace(stop); stop != "" {
+			outageStations[stop] = true
+		}
+	}
+
+	// $GTFS_STOPS is the path of the stops.txt file from the MBTA's GTFS
+	// feed, used to show fare zones.
+	if path := os.Getenv("GTFS_STOPS"); path != "" {
+		zones, err := LoadFareZones(path)
+		if err != nil {
+			log.Fatalf("invalid $GTFS_STOPS: %v", err)
+		}
+		fareZones = zones
+	}
+
+	// $BLUEBIKES enables the panel of nearby Bluebikes stations.
+	if os.Getenv("BLUEBIKES") != "" {
+		bluebikes = NewBluebikesProvider(NewHttpClient())
+	}
+
+	// The schedules of the boards' stops and any in $PRECOMPUTE_STOPS, a
+	// comma-separated list, are fetched overnight for the next service day.
+	if dated, ok := schedules.(DatedScheduleService); ok {
+		stops := []string{}
+		for _, def := range boards {
+			stops = append(stops, def.Stop)
+		}
+		for _, stop := range strings.Split(os.Getenv("PRECOMPUTE_STOPS"), ",") {
+			if stop = strings.TrimSpace(stop); stop != "" {
+				stops = append(stops, stop)
+			}
+		}
+		precomputer := NewSchedulePrecomputer(dated, stops)
+		go precomputer.Run(10*time.Minute, nil)
+		schedules = precomputer
+		if impl, ok := dated.(*MbtaServiceImpl); ok {
+			impl.Schedules = precomputer
+		}
+	}
+
+	// The boards share a service so that failures are cached between
+	// requests.
+	cache := NewCachingService(source)
+	cache.Spans, _ = schedules.(SpanService)
+	var service MbtaService = cache
+
+	// $MERGED_BOARDS is a YAML file of other providers of departures, such as
+	// Amtrak, and the stations whose boards merge theirs with the MBTA's.
+	if path := os.Getenv("MERGED_BOARDS"); path != "" {
+		merged, err := LoadMergedBoards(path)
+		if err != nil {
+			log.Fatalf("invalid $MERGED_BOARDS: %v", err)
+		}
+		service = NewMergedService(service, merged, NewHttpClient())
+	}
+
+	// Gin only reloads templates on every request in debug mode.
+	if devMode {
+		gin.SetMode(gin.DebugMode)
+	} else {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	// The pages and API that fetch departures are limited to
+	// $MAX_CONCURRENT_REQUESTS at once (32 by default), shedding the rest.
+	limit := 32
+	if max := os.Getenv("MAX_CONCURRENT_REQUESTS"); max != "" {
+		n, err := strconv.Atoi(max)
+		if err != nil || n <= 0 {
+			log.Fatalf("invalid $MAX_CONCURRENT_REQUESTS: %q", max)
+		}
+		limit = n
+	}
+
+	// Their responses may be cached by a CDN for $CACHE_MAX_AGE (15s by
+	// default). If $PURGE_WEBHOOK_URL is set, the defined boards are purged
+	// through it within 5 seconds of being fetched with changes.
+	if maxAge := os.Getenv("CACHE_MAX_AGE"); maxAge != "" {
+		d, err := time.ParseDuration(maxAge)
+		if err != nil || d < 0 {
+			log.Fatalf("invalid $CACHE_MAX_AGE: %q", maxAge)
+		}
+		cacheMaxAge = d
+	}
+	// The predictions for the main page's boards are compared with their
+	// schedules every five minutes, for the status page.
+	go NewCoverageChecker(service, schedules, boards, statusTracker).Run(5*time.Minute, nil)
+	if url := os.Getenv("PURGE_WEBHOOK_URL"); url != "" {
+		cachePurger = NewCachePurger(url, NewHttpClient(), boards)
+		go cachePurger.Run(5*time.Second, nil)
+	}
+	shedder := NewLoadShedder(limit)
+	router, pages := NewBoardRouter(service, boards, defaults, shedder)
+
+	// The day's scheduled departures for a stop and where trains are
+	trips, _ := unwrapService(source).(TripService)
+	registerTripRoutes(pages, schedules, trips, defaults)
+
+	// The first and last departures from a stop on each line today and
+	// tomorrow, e.g. /api/v1/span?stop=place-bbsta&route=CR-Worcester
+	if cache.Spans != nil {
+		pages.GET("/api/v1/span", func(c *gin.Context) {
+			RenderSpan(c, cache)
+		})
+	}
+
+	// Delay statistics for each route, e.g. /api/v1/stats?route=CR-Worcester
+	pages.GET("/api/v1/stats", func(c *gin.Context) {
+		RenderStats(c, delayHistory)
 	})
 
-	router.Run(":" + port)
+	// Time-to-leave alerts, delivered through $NOTIFY_WEBHOOK_URL if set, if
+	// $ADMIN_TOKEN is set to the token their API requires
+	notifier := ConfiguredNotifier(os.Getenv("NOTIFY_WEBHOOK_URL"))
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		leaveAlerts := NewLeaveAlerts(source, notifier)
+		go leaveAlerts.Run(time.Minute, nil)
+		RegisterLeaveAlertRoutes(router, token, leaveAlerts)
+	}
+
+	// $RULES is a YAML file of alert rules, checked every minute, and the
+	// channels they notify besides $NOTIFY_WEBHOOK_URL.
+	if path := os.Getenv("RULES"); path != "" {
+		rules, err := LoadRules(path)
+		if err != nil {
+			log.Fatalf("invalid $RULES: %v", err)
+		}
+		go NewRuleEngine(source, rules, notifier).Run(time.Minute, nil)
+	}
+
+	// The operator message API, if $ADMIN_TOKEN is set to the token it
+	// requires
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		RegisterMessageRoutes(router, token, boardMessages)
+	}
+
+	// The status page, if $STATUS_TOKEN is set to the token it requires
+	if token := os.Getenv("STATUS_TOKEN"); token != "" {
+		RegisterStatusRoutes(router, token, cache)
+	}
+
+	// Boards rendered from canned data in testdata/, e.g.
+	// /fixture/predictions-delayed or /fixture/error-429. Useful for
+	// tweaking CSS changes, so only served in development mode by binaries
+	// built with the dev tag.
+	if devMode {
+		RegisterFixtureRoutes(router, "testdata", boards)
+	}
+
+	// $DISPLAYS is a YAML file of remote displays, which connect to /display
+	// to be pushed their boards. The connected displays are listed at
+	// /api/v1/displays if $ADMIN_TOKEN is set, and on the status page.
+	if path := os.Getenv("DISPLAYS"); path != "" {
+		displays, err := LoadDisplays(path)
+		if err != nil {
+			log.Fatalf("invalid $DISPLAYS: %v", err)
+		}
+		displayHub = NewDisplayHub(service, displays)
+		RegisterDisplayRoutes(router, os.Getenv("ADMIN_TOKEN"), displayHub)
+	}
+
+	// $TENANTS is a YAML file of other sets of boards, each served under its
+	// own path prefix or hostname with its own theme and, optionally, API key.
+	// Their requests share the main boards' limit.
+	var handler http.Handler = router
+	if path := os.Getenv("TENANTS"); path != "" {
+		tenants, err := LoadTenants(path)
+		if err != nil {
+			log.Fatalf("invalid $TENANTS: %v", err)
+		}
+		routers := make([]http.Handler, len(tenants))
+		for i, t := range tenants {
+			tenantService := service
+			if t.ApiKey != "" {
+				tenantService = NewCachingService(NewMbtaServiceImplWithKey(NewHttpClient(), t.ApiKey))
+			}
+			routers[i] = NewTenantRouter(t, tenantService, shedder)
+		}
+		handler = NewTenantMux(router, tenants, routers)
+	}
+
+	log.Fatal(http.ListenAndServe(":"+port, handler))
 }
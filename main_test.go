@@ -1,24 +1,40 @@
 package main
 
 import (
+	"encoding/json"
+	"expvar"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gin-gonic/gin"
+	"github.com/mattmckeon/splitflap/internal/mbta"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/h2non/gock.v1"
 )
 
+// at parses an RFC3339 timestamp for use in expected values.
+func at(timestamp string) time.Time {
+	t, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
 func TestParse(t *testing.T) {
-	actual, _ := (&MbtaServiceTest{"testdata/predictions.json"}).ListDepartures("")
+	actual, _ := (&MbtaServiceTest{"testdata/predictions.json"}).ListDepartures("", Filter{})
 
 	expected := []Departure{
-		{"11:50AM", "Readville", "TBD", ""},
-		{"11:50AM", "Readville", "10", "Now boarding"},
-		{"12:40PM", "Worcester", "TBD", "On time"},
-		{"12:50PM", "Readville", "TBD", "On time"},
-		{"1:05PM", "Providence", "TBD", "On time"},
-		{"1:20PM", "Forge Park/495", "TBD", "On time"},
+		{TimeLabel: "11:50AM", Route: "CR-Fairmount", Line: "Fairmount Line", Trip: "CR-Sunday-Aug11-18-2761", Destination: "Readville", Track: "TBD", Direction: "Outbound", Time: at("2018-09-09T11:50:00-04:00")},
+		{TimeLabel: "11:50AM", Route: "CR-Fairmount", Line: "Fairmount Line", Trip: "CR-Sunday-Spring-18-2761", Destination: "Readville", Track: "10", Status: "Now boarding", Direction: "Outbound", Time: at("2018-09-09T11:50:00-04:00")},
+		{TimeLabel: "12:40PM", Route: "CR-Worcester", Line: "Framingham/Worcester Line", Trip: "CR-Sunday-Spring-18-2507", Destination: "Worcester", Track: "TBD", Status: "On time", Direction: "Outbound", Time: at("2018-09-09T12:40:00-04:00")},
+		{TimeLabel: "12:50PM", Route: "CR-Fairmount", Line: "Fairmount Line", Trip: "CR-Sunday-Spring-18-2763", Destination: "Readville", Track: "TBD", Status: "On time", Direction: "Outbound", Time: at("2018-09-09T12:50:00-04:00")},
+		{TimeLabel: "1:05PM", Route: "CR-Providence", Line: "Providence/Stoughton Line", Trip: "CR-Sunday-Spring-18-2807", Destination: "Providence", Track: "TBD", Status: "On time", Direction: "Outbound", Time: at("2018-09-09T13:05:00-04:00")},
+		{TimeLabel: "1:20PM", Route: "CR-Franklin", Line: "Franklin Line", Trip: "CR-Sunday-Spring-18-2709", Destination: "Forge Park/495", Track: "TBD", Status: "On time", Direction: "Outbound", Time: at("2018-09-09T13:20:00-04:00")},
 	}
 	assert.Equal(t, expected, actual)
 }
@@ -32,13 +48,269 @@ func TestRateLimitError(t *testing.T) {
 
 	gock.New(MbtaApiV3BaseUrl).
 		Get("/predictions").
+		MatchParam("filter[revenue]", "REVENUE").
 		Reply(429).
 		Body(f)
 
 	httpClient := &http.Client{}
 	gock.InterceptClient(httpClient)
 
-	departures, err := NewMbtaServiceImpl(httpClient).ListDepartures("")
+	departures, err := NewMbtaServiceImpl(httpClient).ListDepartures("", Filter{})
 	assert.Nil(t, departures)
 	assert.EqualError(t, err, "MBTA API error: You have exceeded your allowed usage rate.")
 }
+
+func TestWindow(t *testing.T) {
+	defer func() { clock = time.Now }()
+	clock = func() time.Time {
+		return time.Date(2018, 9, 9, 16, 0, 0, 0, time.UTC)
+	}
+
+	actual, err := (&MbtaServiceTest{"testdata/predictions.json"}).
+		ListDepartures("", Filter{Window: time.Hour})
+	assert.NoError(t, err)
+
+	expected := []Departure{
+		{TimeLabel: "11:50AM", Route: "CR-Fairmount", Line: "Fairmount Line", Trip: "CR-Sunday-Aug11-18-2761", Destination: "Readville", Track: "TBD", Direction: "Outbound", Time: at("2018-09-09T11:50:00-04:00")},
+		{TimeLabel: "11:50AM", Route: "CR-Fairmount", Line: "Fairmount Line", Trip: "CR-Sunday-Spring-18-2761", Destination: "Readville", Track: "10", Status: "Now boarding", Direction: "Outbound", Time: at("2018-09-09T11:50:00-04:00")},
+		{TimeLabel: "12:40PM", Route: "CR-Worcester", Line: "Framingham/Worcester Line", Trip: "CR-Sunday-Spring-18-2507", Destination: "Worcester", Track: "TBD", Status: "On time", Direction: "Outbound", Time: at("2018-09-09T12:40:00-04:00")},
+		{TimeLabel: "12:50PM", Route: "CR-Fairmount", Line: "Fairmount Line", Trip: "CR-Sunday-Spring-18-2763", Destination: "Readville", Track: "TBD", Status: "On time", Direction: "Outbound", Time: at("2018-09-09T12:50:00-04:00")},
+	}
+	assert.Equal(t, expected, actual)
+}
+
+// The Back Bay fixture also has a non-revenue Worcester train at 5:25PM that
+// should never appear.
+func TestBothDirections(t *testing.T) {
+	service := &MbtaServiceTest{"testdata/predictions-backbay.json"}
+
+	outbound, err := service.ListDepartures("place-bbsta", Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, []Departure{
+		{TimeLabel: "5:05PM", Route: "CR-Providence", Line: "Providence/Stoughton Line", Trip: "t1", Destination: "Providence", Track: "1", Status: "On time", Direction: "Outbound", Time: at("2018-09-10T17:05:00-04:00")},
+		{TimeLabel: "5:20PM", Route: "CR-Worcester", Line: "Framingham/Worcester Line", Trip: "t3", Destination: "Worcester", Track: "5", Direction: "Outbound", Time: at("2018-09-10T17:20:00-04:00")},
+	}, outbound)
+
+	both, err := service.ListDepartures("place-bbsta", Filter{Direction: "both"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Departure{
+		{TimeLabel: "5:05PM", Route: "CR-Providence", Line: "Providence/Stoughton Line", Trip: "t1", Destination: "Providence", Track: "1", Status: "On time", Direction: "Outbound", Time: at("2018-09-10T17:05:00-04:00")},
+		{TimeLabel: "5:12PM", Route: "CR-Worcester", Line: "Framingham/Worcester Line", Trip: "t2", Destination: "South Station", Track: "7", Status: "On time", Direction: "Inbound", Time: at("2018-09-10T17:12:00-04:00")},
+		{TimeLabel: "5:20PM", Route: "CR-Worcester", Line: "Framingham/Worcester Line", Trip: "t3", Destination: "Worcester", Track: "5", Direction: "Outbound", Time: at("2018-09-10T17:20:00-04:00")},
+		{TimeLabel: "5:31PM", Route: "CR-Providence", Line: "Providence/Stoughton Line", Trip: "t4", Destination: "South Station", Track: "TBD", Status: "Delayed", Direction: "Inbound", Time: at("2018-09-10T17:31:00-04:00")},
+	}, both)
+}
+
+func TestRenderBoard(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	LoadTemplates(router, "templates")
+	router.GET("/board/:stop", func(c *gin.Context) {
+		RenderBoard(c, &MbtaServiceTest{"testdata/predictions-backbay.json"}, Filter{})
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/board/place-bbsta?direction=both&title=Back+Bay", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<caption>Back Bay</caption>")
+	assert.Contains(t, w.Body.String(), "<th>Direction</th>")
+	assert.Contains(t, w.Body.String(), `<td class="direction">Inbound</td>`)
+	// Pages under /board/ load the static assets from the root.
+	assert.Contains(t, w.Body.String(), `src="/static/descrambler.js"`)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/board/place-bbsta?direction=sideways", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestRenderDefinedBoards(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	LoadTemplates(router, "templates")
+	defs := []BoardDefinition{
+		{Title: "Back Bay Outbound", Stop: "place-bbsta"},
+		{Title: "Back Bay Both Ways", Stop: "place-bbsta", Filter: Filter{Direction: "both"}},
+	}
+	service := &MbtaServiceTest{"testdata/predictions-backbay.json"}
+	router.GET("/", func(c *gin.Context) {
+		Render(c, service, defs)
+	})
+	router.GET("/api/v1/boards", func(c *gin.Context) {
+		RenderJson(c, service, defs)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<caption>Back Bay Outbound</caption>")
+	assert.Contains(t, w.Body.String(), "<caption>Back Bay Both Ways</caption>")
+	assert.Equal(t, 1, strings.Count(w.Body.String(), "<th>Direction</th>"))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/boards", nil))
+	var boards []struct {
+		Title      string
+		Departures []Departure
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &boards))
+	assert.Len(t, boards, 2)
+	assert.Len(t, boards[0].Departures, 2)
+	assert.Len(t, boards[1].Departures, 4)
+
+	// Query string overrides apply to every board.
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/boards?direction=inbound", nil))
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &boards))
+	assert.Len(t, boards[0].Departures, 2)
+	assert.Len(t, boards[1].Departures, 2)
+}
+
+// metric returns the current value of an mbta.PayloadMetrics counter.
+func metric(name string) int64 {
+	if v, ok := mbta.PayloadMetrics.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestMalformedPayload(t *testing.T) {
+	before := map[string]int64{}
+	names := []string{"dropped_resources", "invalid_attributes",
+		"unknown_relationship_types", "incomplete_predictions"}
+	for _, name := range names {
+		before[name] = metric(name)
+	}
+
+	actual, err := (&MbtaServiceTest{"testdata/predictions-malformed.json"}).
+		ListDepartures("place-bbsta", Filter{})
+	assert.NoError(t, err)
+
+	// The numeric platform code and status are dropped, along with the
+	// prediction whose route is a facility, the one without a trip and the
+	// vehicle that snuck into the data.
+	expected := []Departure{
+		{TimeLabel: "5:20PM", Route: "CR-Worcester", Line: "Framingham/Worcester Line", Trip: "t1", Destination: "Worcester", Track: "TBD", Status: "On time",
+			Direction: "Outbound", Time: at("2018-09-10T17:20:00-04:00")},
+		{TimeLabel: "5:40PM", Route: "CR-Worcester", Line: "Framingham/Worcester Line", Trip: "t2", Destination: "Framingham", Track: "TBD",
+			Direction: "Outbound", Time: at("2018-09-10T17:40:00-04:00")},
+	}
+	assert.Equal(t, expected, actual)
+
+	assert.Equal(t, int64(1), metric("dropped_resources")-before["dropped_resources"])
+	assert.Equal(t, int64(3), metric("invalid_attributes")-before["invalid_attributes"])
+	assert.Equal(t, int64(1), metric("unknown_relationship_types")-before["unknown_relationship_types"])
+	assert.Equal(t, int64(2), metric("incomplete_predictions")-before["incomplete_predictions"])
+}
+
+func TestBatchPredictions(t *testing.T) {
+	defer gock.Off()
+	defer func() { clock = time.Now }()
+	clock = func() time.Time {
+		return time.Date(2018, 9, 9, 16, 0, 0, 0, time.UTC)
+	}
+
+	// Both boards are fetched in one request; a second one would go unmatched.
+	gock.New(MbtaApiV3BaseUrl).
+		Get("/predictions").
+		MatchParam("filter[stop]", "^place-north,place-sstat$").
+		Reply(200).
+		File("testdata/predictions.json")
+
+	httpClient := &http.Client{}
+	gock.InterceptClient(httpClient)
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/", nil)
+	boards, err := FetchBoards(c, NewMbtaServiceImpl(httpClient), DefaultBoards)
+	assert.NoError(t, err)
+	assert.True(t, gock.IsDone())
+
+	// All the predictions are for South Station platforms.
+	assert.NoError(t, boards[0].Error)
+	assert.Empty(t, boards[0].Departures)
+	assert.NoError(t, boards[1].Error)
+	expected, _ := (&MbtaServiceTest{"testdata/predictions.json"}).ListDepartures("", Filter{})
+	assert.Equal(t, expected, boards[1].Departures)
+}
+
+// BenchmarkExtractDepartures measures extracting a commuter rail board. The
+// only allocations should be growing the departures slice and formatting their
+// times, so the count stays at 10 for the fixture's six departures while the
+// bytes grow with Departure.
+func BenchmarkExtractDepartures(b *testing.B) {
+	var predictions []*mbta.Prediction
+	if err := (&MbtaServiceTest{"testdata/predictions.json"}).load(&predictions); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ExtractDepartures(predictions, Filter{Direction: "both"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// The drop-off fixture has an inbound Worcester train that only lets
+// passengers off at Back Bay, and an arrival at South Station with a departure
+// time that should never appear.
+func TestDropOffOnly(t *testing.T) {
+	service := &MbtaServiceTest{"testdata/predictions-dropoff.json"}
+
+	departures, err := service.ListDepartures("place-sstat", Filter{Direction: "both"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Departure{
+		{TimeLabel: "5:05PM", Route: "CR-Providence", Line: "Providence/Stoughton Line", Trip: "d1", Destination: "Providence", Track: "1", Status: "On time", Direction: "Outbound",
+			Time: at("2018-09-10T17:05:00-04:00"), ScheduledTime: at("2018-09-10T17:05:00-04:00")},
+		{TimeLabel: "5:10PM", Route: "CR-Worcester", Line: "Framingham/Worcester Line", Trip: "d2", Destination: "South Station", Track: "2", Status: "On time", Direction: "Inbound",
+			Time: at("2018-09-10T17:10:00-04:00"), ScheduledTime: at("2018-09-10T17:10:00-04:00"), DropOffOnly: true},
+	}, departures)
+}
+
+func TestGreenLineBranches(t *testing.T) {
+	service := &MbtaServiceTest{"testdata/predictions-kenmore.json"}
+
+	westbound, err := service.ListDepartures("place-kencl", Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, []Departure{
+		{TimeLabel: "6:03PM", Route: "Green-B", Line: "Green Line B", Trip: "tg2", Branch: "B", Destination: "Boston College", Track: "TBD", Direction: "West", Time: at("2018-09-10T18:03:00-04:00")},
+		{TimeLabel: "6:09PM", Route: "Green-B", Line: "Green Line B", Trip: "tg5", Branch: "B", Destination: "Boston College", Track: "TBD", Direction: "West", Time: at("2018-09-10T18:09:00-04:00")},
+		{TimeLabel: "6:05PM", Route: "Green-C", Line: "Green Line C", Trip: "tg3", Branch: "C", Destination: "Cleveland Circle", Track: "TBD", Direction: "West", Time: at("2018-09-10T18:05:00-04:00")},
+		{TimeLabel: "6:02PM", Route: "Green-D", Line: "Green Line D", Trip: "tg1", Branch: "D", Destination: "Riverside", Track: "TBD", Direction: "West", Time: at("2018-09-10T18:02:00-04:00")},
+		{TimeLabel: "6:12PM", Route: "Green-D", Line: "Green Line D", Trip: "tg7", Branch: "D", Destination: "Riverside", Track: "TBD", Direction: "West", Time: at("2018-09-10T18:12:00-04:00")},
+	}, westbound)
+
+	d, err := service.ListDepartures("place-kencl", Filter{Direction: "inbound", Branch: "D"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Departure{
+		{TimeLabel: "6:06PM", Route: "Green-D", Line: "Green Line D", Trip: "tg4", Branch: "D", Destination: "Government Center", Track: "TBD", Direction: "East", Time: at("2018-09-10T18:06:00-04:00")},
+	}, d)
+}
+
+func TestRenderBranchBoards(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	LoadTemplates(router, "templates")
+	router.GET("/board/:stop", func(c *gin.Context) {
+		RenderBoard(c, &MbtaServiceTest{"testdata/predictions-kenmore.json"}, Filter{})
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/board/place-kencl?title=Kenmore", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<th>Branch</th>")
+	assert.Contains(t, w.Body.String(), `<td class="branch">C</td>`)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/board/place-kencl?title=Kenmore&split=branch", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<caption>Kenmore: B Branch</caption>")
+	assert.Contains(t, w.Body.String(), "<caption>Kenmore: C Branch</caption>")
+	assert.Contains(t, w.Body.String(), "<caption>Kenmore: D Branch</caption>")
+	assert.NotContains(t, w.Body.String(), "<caption>Kenmore</caption>")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/board/place-kencl?branch=f", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
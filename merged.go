@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattmckeon/splitflap/internal/mbta"
+	"gopkg.in/yaml.v2"
+)
+
+// mbtaProvider is the name of the built-in provider, the MBTA service the
+// other boards use.
+const mbtaProvider = "MBTA"
+
+// ProviderConfig is the configuration of a provider of departures besides the
+// MBTA, such as Amtrak. Feed is the URL of its departures in the format of
+// /api/v1/board/<stop id>, with {stop} replaced by the stop, as served by
+// another splitflap or an adapter for the provider's own API. Name is shown
+// next to its departures.
+type ProviderConfig struct {
+	Name string `yaml:"name"`
+	Feed string `yaml:"feed"`
+}
+
+// MergedSource is one of the providers of a merged station, with its ID for
+// the station.
+type MergedSource struct {
+	Provider string `yaml:"provider"`
+	Stop     string `yaml:"stop"`
+}
+
+// MergedStation is a stop whose board merges the departures of several
+// providers, e.g. the MBTA's and Amtrak's at South Station.
+type MergedStation struct {
+	Stop    string         `yaml:"stop"`
+	Sources []MergedSource `yaml:"sources"`
+}
+
+// MergedConfig is the contents of a merged boards file.
+type MergedConfig struct {
+	Providers []ProviderConfig `yaml:"providers"`
+	Stations  []MergedStation  `yaml:"stations"`
+}
+
+// LoadMergedBoards reads and validates a YAML file of providers and the
+// stations merging their departures.
+func LoadMergedBoards(path string) (MergedConfig, error) {
+	var config MergedConfig
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return config, fmt.Errorf("%s: %v", path, err)
+	}
+	providers := map[string]bool{mbtaProvider: true}
+	for _, p := range config.Providers {
+		if p.Name == "" || providers[p.Name] {
+			return config, fmt.Errorf("%s: provider names must be set and unique, and not %s", path, mbtaProvider)
+		}
+		if _, err := url.Parse(p.Feed); err != nil || !strings.Contains(p.Feed, "{stop}") {
+			return config, fmt.Errorf("%s: provider %q: feed must be a URL with {stop}", path, p.Name)
+		}
+		providers[p.Name] = true
+	}
+	stations := map[string]bool{}
+	for _, s := range config.Stations {
+		if s.Stop == "" || stations[s.Stop] {
+			return config, fmt.Errorf("%s: station stops must be set and unique", path)
+		}
+		stations[s.Stop] = true
+		if len(s.Sources) == 0 {
+			return config, fmt.Errorf("%s: station %q: sources are required", path, s.Stop)
+		}
+		for _, source := range s.Sources {
+			if !providers[source.Provider] || source.Stop == "" {
+				return config, fmt.Errorf("%s: station %q: invalid source %q", path, s.Stop, source.Provider)
+			}
+		}
+	}
+	return config, nil
+}
+
+// ProviderError is returned along with the departures of a merged station
+// when some of its providers failed. Boards show the departures they have,
+// noting the providers that are missing, rather than an error. StaleSince is
+// set when some of the departures are from an earlier fetch.
+type ProviderError struct {
+	Errs       map[string]error
+	StaleSince time.Time
+}
+
+// Error implements the Golang error interface for ProviderError.
+func (e *ProviderError) Error() string {
+	messages := []string{}
+	for _, name := range e.Providers() {
+		messages = append(messages, fmt.Sprintf("%s: %v", name, e.Errs[name]))
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Providers returns the names of the providers that failed, sorted.
+func (e *ProviderError) Providers() []string {
+	names := []string{}
+	for name := range e.Errs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MergedService wraps an MbtaService to merge the departures of other
+// providers into the boards of the configured stations, sorted by time and
+// attributed to their provider. Each provider is fetched separately, and
+// cached like the MBTA, so that one failing only leaves its departures off.
+// Other stops are passed through.
+type MergedService struct {
+	MbtaService
+	providers map[string]MbtaService
+	stations  map[string]MergedStation
+}
+
+// NewMergedService creates and returns a MergedService for the configured
+// stations, fetching the MBTA's departures from service.
+func NewMergedService(service MbtaService, config MergedConfig, httpClient *http.Client) *MergedService {
+	s := &MergedService{
+		MbtaService: service,
+		providers:   map[string]MbtaService{mbtaProvider: service},
+		stations:    map[string]MergedStation{},
+	}
+	for _, p := range config.Providers {
+		s.providers[p.Name] = NewCachingService(&FeedService{Url: p.Feed, client: httpClient})
+	}
+	for _, station := range config.Stations {
+		s.stations[station.Stop] = station
+	}
+	return s
+}
+
+// Unwrap returns the wrapped service, so callers can use its optional
+// capabilities directly.
+func (s *MergedService) Unwrap() MbtaService {
+	return s.MbtaService
+}
+
+// BatchPredictions is an implementation of the PredictionBatcher
+// BatchPredictions method that batches the predictions for the places that
+// aren't merged stations through the wrapped service, leaving the merged
+// stations out to be fetched on their own. It returns errNoBatching if the
+// wrapped service isn't a PredictionBatcher.
+func (s *MergedService) BatchPredictions(places []string) (map[string][]*mbta.Prediction, error) {
+	batcher, ok := s.MbtaService.(PredictionBatcher)
+	if !ok {
+		return nil, errNoBatching
+	}
+	unmerged := []string{}
+	for _, place := range places {
+		if _, ok := s.stations[place]; !ok {
+			unmerged = append(unmerged, place)
+		}
+	}
+	if len(unmerged) == 0 {
+		return map[string][]*mbta.Prediction{}, nil
+	}
+	return batcher.BatchPredictions(unmerged)
+}
+
+// ListDepartures is an implementation of the MbtaService ListDepartures method
+// that merges the departures of a configured station's providers. It fails
+// only if every provider does, and returns a *ProviderError with the
+// departures if some of them did.
+func (s *MergedService) ListDepartures(place string, filter Filter) ([]Departure, error) {
+	station, ok := s.stations[place]
+	if !ok {
+		return s.MbtaService.ListDepartures(place, filter)
+	}
+	type result struct {
+		departures []Departure
+		err        error
+	}
+	results := make([]result, len(station.Sources))
+	var wg sync.WaitGroup
+	for i, source := range station.Sources {
+		wg.Add(1)
+		go func(i int, source MergedSource) {
+			defer wg.Done()
+			departures, err := s.providers[source.Provider].ListDepartures(source.Stop, filter)
+			results[i] = result{departures, err}
+		}(i, source)
+	}
+	wg.Wait()
+
+	departures := []Departure{}
+	partial := &ProviderError{Errs: map[string]error{}}
+	var lastErr error
+	for i, r := range results {
+		name := station.Sources[i].Provider
+		if stale, ok := r.err.(*StaleError); ok {
+			if partial.StaleSince.IsZero() || stale.Since.Before(partial.StaleSince) {
+				partial.StaleSince = stale.Since
+			}
+		} else if r.err != nil {
+			partial.Errs[name] = r.err
+			lastErr = r.err
+			if r.departures == nil {
+				continue
+			}
+		}
+		for _, d := range r.departures {
+			d.Provider = name
+			departures = append(departures, d)
+		}
+	}
+	if len(partial.Errs) == len(results) {
+		return nil, lastErr
+	}
+	sort.SliceStable(departures, func(i, j int) bool {
+		a, b := departures[i].Time, departures[j].Time
+		return !a.IsZero() && (b.IsZero() || a.Before(b))
+	})
+	if len(partial.Errs) > 0 || !partial.StaleSince.IsZero() {
+		return departures, partial
+	}
+	return departures, nil
+}
+
+// FeedService implements MbtaService on top of a feed of departures in the
+// format of /api/v1/board/<stop id>, with {stop} in the URL replaced by the
+// stop. The filter is applied locally, with departures without a direction
+// shown in any. Trips are left out, since they aren't MBTA trips.
+type FeedService struct {
+	Url    string
+	client *http.Client
+}
+
+// ListDepartures is an implementation of the MbtaService ListDepartures method
+// that fetches the departures from the feed.
+func (s *FeedService) ListDepartures(place string, filter Filter) ([]Departure, error) {
+	resp, err := s.client.Get(strings.Replace(s.Url, "{stop}", url.PathEscape(place), -1))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("feed error: " + resp.Status)
+	}
+	var board struct {
+		Departures []Departure `json:"departures"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&board); err != nil {
+		return nil, fmt.Errorf("feed error: %v", err)
+	}
+	var cutoff time.Time
+	if filter.Window > 0 {
+		cutoff = clock().Add(filter.Window)
+	}
+	departures := []Departure{}
+	for _, d := range board.Departures {
+		if (d.Direction != "" && !filter.matchesDirection(d.Direction)) ||
+			(!cutoff.IsZero() && d.Time.After(cutoff)) {
+			continue
+		}
+		d.Trip = ""
+		departures = append(departures, d)
+	}
+	return departures, nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+const amtrakFeed = `{"title": "BBY", "departures": [
+	{"time": "5:10PM", "trip": "171", "destination": "New York", "track": "", "status": "On time", "direction": "Outbound", "departure_time": "2018-09-10T17:10:00-04:00"},
+	{"time": "5:40PM", "trip": "172", "destination": "Boston", "track": "", "status": "", "direction": "Inbound", "departure_time": "2018-09-10T17:40:00-04:00"}
+]}`
+
+func newMergedTestService(t *testing.T, mbtaFixture string) *MergedService {
+	config, err := LoadMergedBoards("testdata/merged.yaml")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	httpClient := &http.Client{}
+	gock.InterceptClient(httpClient)
+	return NewMergedService(&MbtaServiceTest{mbtaFixture}, config, httpClient)
+}
+
+func TestMergedBoard(t *testing.T) {
+	defer gock.Off()
+	gock.New("https://amtrak.example.com").
+		Get("/api/v1/board/BBY").
+		Reply(200).
+		BodyString(amtrakFeed)
+
+	service := newMergedTestService(t, "testdata/predictions-backbay.json")
+	departures, err := service.ListDepartures("place-bbsta", Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, []Departure{
+		{TimeLabel: "5:05PM", Route: "CR-Providence", Line: "Providence/Stoughton Line", Trip: "t1", Destination: "Providence", Track: "1", Status: "On time", Direction: "Outbound", Time: at("2018-09-10T17:05:00-04:00"), Provider: "MBTA"},
+		{TimeLabel: "5:10PM", Destination: "New York", Status: "On time", Direction: "Outbound", Time: at("2018-09-10T17:10:00-04:00"), Provider: "Amtrak"},
+		{TimeLabel: "5:20PM", Route: "CR-Worcester", Line: "Framingham/Worcester Line", Trip: "t3", Destination: "Worcester", Track: "5", Direction: "Outbound", Time: at("2018-09-10T17:20:00-04:00"), Provider: "MBTA"},
+	}, departures)
+	assert.True(t, gock.IsDone())
+
+	// Other stops are passed through.
+	departures, err = service.ListDepartures("place-north", Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, "", departures[0].Provider)
+}
+
+func TestMergedBoardProviderFailure(t *testing.T) {
+	defer gock.Off()
+	gock.New("https://amtrak.example.com").
+		Get("/api/v1/board/BBY").
+		Reply(503)
+
+	service := newMergedTestService(t, "testdata/predictions-backbay.json")
+	departures, err := service.ListDepartures("place-bbsta", Filter{})
+	assert.Len(t, departures, 2)
+	if partial, ok := err.(*ProviderError); assert.True(t, ok, "%v", err) {
+		assert.Equal(t, []string{"Amtrak"}, partial.Providers())
+		assert.Equal(t, "Amtrak: feed error: 503 Service Unavailable", partial.Error())
+	}
+
+	board := newBoard(BoardDefinition{Title: "Back Bay", Stop: "place-bbsta"})
+	board.setDepartures(departures, err)
+	assert.NoError(t, board.Error)
+	assert.Equal(t, []string{"Amtrak"}, board.Unavailable)
+	assert.True(t, board.ShowProvider)
+	assert.Equal(t, 5, board.Columns())
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	LoadTemplates(router, "templates")
+	router.GET("/board/:stop", func(c *gin.Context) {
+		gock.New("https://amtrak.example.com").
+			Get("/api/v1/board/BBY").
+			Reply(503)
+		RenderBoard(c, newMergedTestService(t, "testdata/predictions-backbay.json"), Filter{})
+	})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/board/place-bbsta", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<th>Provider</th>")
+	assert.Contains(t, w.Body.String(), `<td class="provider">MBTA</td>`)
+	assert.Contains(t, w.Body.String(), "Amtrak departures unavailable")
+}
+
+func TestMergedBoardAllProvidersFail(t *testing.T) {
+	defer gock.Off()
+	gock.New("https://amtrak.example.com").
+		Get("/api/v1/board/BBY").
+		Reply(503)
+
+	service := newMergedTestService(t, "testdata/error-429.json")
+	departures, err := service.ListDepartures("place-bbsta", Filter{})
+	assert.Nil(t, departures)
+	assert.Error(t, err)
+	_, partial := err.(*ProviderError)
+	assert.False(t, partial)
+}
+
+func TestLoadMergedBoardsErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merged")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	for name, config := range map[string]string{
+		"builtin":  "providers:\n  - name: MBTA\n    feed: https://example.com/{stop}\n",
+		"no-stop":  "providers:\n  - name: Amtrak\n    feed: https://example.com/board\n",
+		"unknown":  "stations:\n  - stop: place-sstat\n    sources:\n      - provider: Amtrak\n        stop: BOS\n",
+		"empty":    "stations:\n  - stop: place-sstat\n",
+		"typo":     "stations:\n  - stop: place-sstat\n    source: []\n",
+		"repeated": "stations:\n  - stop: place-sstat\n    sources: [{provider: MBTA, stop: place-sstat}]\n  - stop: place-sstat\n    sources: [{provider: MBTA, stop: place-sstat}]\n",
+	} {
+		path := filepath.Join(dir, name+".yaml")
+		assert.NoError(t, ioutil.WriteFile(path, []byte(config), 0644))
+		_, err := LoadMergedBoards(path)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestMergedBatchPredictions(t *testing.T) {
+	service := newMergedTestService(t, "testdata/predictions-backbay.json")
+	batch, err := service.BatchPredictions([]string{"place-bbsta", "place-north"})
+	assert.NoError(t, err)
+	assert.Contains(t, batch, "place-north")
+	assert.NotContains(t, batch, "place-bbsta")
+
+	batch, err = service.BatchPredictions([]string{"place-bbsta"})
+	assert.NoError(t, err)
+	assert.Empty(t, batch)
+}
+
+func TestMergedBoardDelaysAreNotRecorded(t *testing.T) {
+	defer gock.Off()
+	// Another splitflap's feed can use MBTA route IDs.
+	gock.New("https://amtrak.example.com").
+		Get("/api/v1/board/BBY").
+		Reply(200).
+		BodyString(`{"title": "BBY", "departures": [
+	{"time": "5:10PM", "route": "CR-Providence", "trip": "171", "destination": "New York", "track": "", "status": "Delayed", "direction": "Outbound", "departure_time": "2018-09-10T17:10:00-04:00", "scheduled_time": "2018-09-10T17:00:00-04:00"},
+	{"time": "5:25PM", "route": "CR-Providence", "trip": "173", "destination": "New York", "track": "", "status": "On time", "direction": "Outbound", "departure_time": "2018-09-10T17:25:00-04:00", "scheduled_time": "2018-09-10T17:25:00-04:00"}
+]}`)
+
+	h, _ := NewDelayHistory("")
+	board := newBoard(BoardDefinition{Title: "Back Bay", Stop: "place-bbsta"})
+	board.setDepartures(newMergedTestService(t, "testdata/predictions-backbay.json").
+		ListDepartures("place-bbsta", Filter{}))
+	assert.True(t, board.ShowProvider)
+	h.ObserveBoard(board)
+	assert.Empty(t, h.Stats([]string{"CR-Providence"}, at("2018-09-10T18:00:00-04:00")))
+}
+
+func TestFeedDeparturesWithoutDirection(t *testing.T) {
+	defer gock.Off()
+	gock.New("https://amtrak.example.com").
+		Get("/api/v1/board/BBY").
+		Reply(200).
+		BodyString(`{"title": "BBY", "departures": [
+	{"time": "5:10PM", "trip": "171", "destination": "New York", "track": "", "status": "On time", "departure_time": "2018-09-10T17:10:00-04:00"}
+]}`)
+
+	service := newMergedTestService(t, "testdata/predictions-backbay.json")
+	departures, err := service.ListDepartures("place-bbsta", Filter{})
+	assert.NoError(t, err)
+	assert.Len(t, departures, 3)
+	assert.True(t, gock.IsDone())
+}
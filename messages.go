@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BoardMessage is an operator-defined notice, such as "Elevator out at North
+// Station", shown in the message slot of boards. It's shown on the boards for
+// Stops, or on every board if there are none, from Start until End; either
+// may be zero for a message that's shown until it's removed.
+type BoardMessage struct {
+	Id    string    `json:"id"`
+	Text  string    `json:"text"`
+	Stops []string  `json:"stops,omitempty"`
+	Start time.Time `json:"start,omitzero"`
+	End   time.Time `json:"end,omitzero"`
+}
+
+// activeAt reports whether the message should be shown on the stop's board
+// at t.
+func (m BoardMessage) activeAt(stop string, t time.Time) bool {
+	if (!m.Start.IsZero() && t.Before(m.Start)) || (!m.End.IsZero() && !t.Before(m.End)) {
+		return false
+	}
+	if len(m.Stops) == 0 {
+		return true
+	}
+	for _, s := range m.Stops {
+		if s == stop {
+			return true
+		}
+	}
+	return false
+}
+
+// MessageStore holds the board messages. If it has a path, the messages are
+// saved there whenever they change so they survive restarts.
+type MessageStore struct {
+	path string
+
+	mu       sync.Mutex
+	messages map[string]*BoardMessage
+	nextId   int
+}
+
+// NewMessageStore creates and returns a message store saved at path, loading
+// any messages already there. An empty path keeps the messages in memory.
+func NewMessageStore(path string) (*MessageStore, error) {
+	s := &MessageStore{path: path, messages: map[string]*BoardMessage{}}
+	if path == "" {
+		return s, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var messages []BoardMessage
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for i := range messages {
+		m := messages[i]
+		s.messages[m.Id] = &m
+		if id, err := strconv.Atoi(m.Id); err == nil && id > s.nextId {
+			s.nextId = id
+		}
+	}
+	return s, nil
+}
+
+// boardMessages are the messages shown on boards, saved to $MESSAGES_FILE if
+// it's set.
+var boardMessages, _ = NewMessageStore("")
+
+// Add validates the message, assigns it an ID and saves it.
+func (s *MessageStore) Add(m BoardMessage) (BoardMessage, error) {
+	m.Text = strings.TrimSpace(m.Text)
+	if m.Text == "" {
+		return m, errors.New("text is required")
+	}
+	if !m.Start.IsZero() && !m.End.IsZero() && !m.End.After(m.Start) {
+		return m, errors.New("end must be after start")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextId++
+	m.Id = strconv.Itoa(s.nextId)
+	s.messages[m.Id] = &m
+	s.save()
+	return m, nil
+}
+
+// List returns the messages, in the order they were added.
+func (s *MessageStore) List() []BoardMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.list()
+}
+
+func (s *MessageStore) list() []BoardMessage {
+	messages := []BoardMessage{}
+	for _, m := range s.messages {
+		messages = append(messages, *m)
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		ii, _ := strconv.Atoi(messages[i].Id)
+		jj, _ := strconv.Atoi(messages[j].Id)
+		return ii < jj
+	})
+	return messages
+}
+
+// Remove deletes the message with the given ID, reporting whether it existed.
+func (s *MessageStore) Remove(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.messages[id]; !ok {
+		return false
+	}
+	delete(s.messages, id)
+	s.save()
+	return true
+}
+
+// Active returns the text of the messages to show on the stop's board at t.
+func (s *MessageStore) Active(stop string, t time.Time) []string {
+	var active []string
+	for _, m := range s.List() {
+		if m.activeAt(stop, t) {
+			active = append(active, m.Text)
+		}
+	}
+	return active
+}
+
+// save writes the messages to the store's path, if it has one. Failures are
+// logged, leaving the messages in memory. The caller must hold s.mu.
+func (s *MessageStore) save() {
+	if s.path == "" {
+		return
+	}
+	data, err := json.MarshalIndent(s.list(), "", "  ")
+	if err == nil {
+		err = writeFileAtomic(s.path, append(data, '\n'))
+	}
+	if err != nil {
+		log.Printf("messages: %v", err)
+	}
+}
+
+// WriteMessages writes the messages to w ahead of the departures in the given
+// format: one per line for "text", and as rows of the character grid for
+// "grid". The "json" format has no room for them, so they're left out.
+func WriteMessages(w io.Writer, messages []string, format string) error {
+	for _, m := range messages {
+		var err error
+		switch format {
+		case "text":
+			_, err = fmt.Fprintf(w, "* %s\n", m)
+		case "grid":
+			_, err = fmt.Fprintln(w, gridCell(m, gridWidth))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(messages) > 0 && format == "text" {
+		_, err := fmt.Fprintln(w)
+		return err
+	}
+	return nil
+}
+
+// RegisterMessageRoutes adds the JSON API for managing board messages to the
+// router, requiring the token.
+func RegisterMessageRoutes(router gin.IRouter, token string, messages *MessageStore) {
+	admin := router.Group("", requireToken(token))
+	admin.GET("/api/v1/messages", func(c *gin.Context) {
+		c.JSON(http.StatusOK, messages.List())
+	})
+	admin.POST("/api/v1/messages", func(c *gin.Context) {
+		var m BoardMessage
+		if err := c.BindJSON(&m); err != nil {
+			return
+		}
+		m, err := messages.Add(m)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, m)
+	})
+	admin.DELETE("/api/v1/messages/:id", func(c *gin.Context) {
+		if !messages.Remove(c.Param("id")) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no such message"})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageSchedule(t *testing.T) {
+	store, err := NewMessageStore("")
+	assert.NoError(t, err)
+
+	_, err = store.Add(BoardMessage{Text: "  "})
+	assert.EqualError(t, err, "text is required")
+	_, err = store.Add(BoardMessage{Text: "Backwards", Start: at("2018-12-26T00:00:00-05:00"), End: at("2018-12-24T00:00:00-05:00")})
+	assert.EqualError(t, err, "end must be after start")
+
+	_, err = store.Add(BoardMessage{Text: "Elevator out at North Station", Stops: []string{"place-north"}})
+	assert.NoError(t, err)
+	holidays, err := store.Add(BoardMessage{Text: "Happy Holidays",
+		Start: at("2018-12-24T00:00:00-05:00"), End: at("2018-12-26T00:00:00-05:00")})
+	assert.NoError(t, err)
+	assert.Equal(t, "2", holidays.Id)
+
+	christmas := at("2018-12-25T09:00:00-05:00")
+	assert.Equal(t, []string{"Elevator out at North Station", "Happy Holidays"},
+		store.Active("place-north", christmas))
+	assert.Equal(t, []string{"Happy Holidays"}, store.Active("place-sstat", christmas))
+	assert.Empty(t, store.Active("place-sstat", at("2018-12-26T00:00:00-05:00")))
+
+	assert.True(t, store.Remove("1"))
+	assert.False(t, store.Remove("1"))
+	assert.Empty(t, store.Active("place-north", at("2018-12-27T09:00:00-05:00")))
+}
+
+func TestMessagesArePersisted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "splitflap")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "messages.json")
+
+	store, err := NewMessageStore(path)
+	assert.NoError(t, err)
+	_, err = store.Add(BoardMessage{Text: "Elevator out at North Station"})
+	assert.NoError(t, err)
+
+	store, err = NewMessageStore(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []BoardMessage{{Id: "1", Text: "Elevator out at North Station"}}, store.List())
+	m, err := store.Add(BoardMessage{Text: "Happy Holidays"})
+	assert.NoError(t, err)
+	assert.Equal(t, "2", m.Id)
+}
+
+func TestMessagesOnBoards(t *testing.T) {
+	defer func(store *MessageStore) { boardMessages = store }(boardMessages)
+	boardMessages, _ = NewMessageStore("")
+	boardMessages.Add(BoardMessage{Text: "Elevator out at South Station", Stops: []string{"place-sstat"}})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	LoadTemplates(router, "templates")
+	router.GET("/board/:stop", func(c *gin.Context) {
+		RenderBoard(c, &MbtaServiceTest{"testdata/predictions.json"}, Filter{})
+	})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/board/place-sstat", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `<span class="message">Elevator out at South Station</span>`)
+
+	var out bytes.Buffer
+	err := runOnce(&MbtaServiceTest{"testdata/predictions.json"},
+		[]string{"--stop", "place-sstat"}, &out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "* Elevator out at South Station\n\nTIME ")
+
+	out.Reset()
+	err = runOnce(&MbtaServiceTest{"testdata/predictions.json"},
+		[]string{"--stop", "place-north"}, &out)
+	assert.NoError(t, err)
+	assert.NotContains(t, out.String(), "Elevator")
+}
+
+func TestMessageRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	store, _ := NewMessageStore("")
+	RegisterMessageRoutes(router, "secret", store)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/messages",
+		bytes.NewBufferString(`{"text": "Happy Holidays"}`)))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/api/v1/messages",
+		bytes.NewBufferString(`{"text": "Happy Holidays", "end": "2018-12-26T00:00:00-05:00"}`))
+	r.Header.Set("Authorization", "Bearer secret")
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var m BoardMessage
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &m))
+	assert.Equal(t, "1", m.Id)
+	assert.True(t, m.End.Equal(time.Date(2018, 12, 26, 5, 0, 0, 0, time.UTC)))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/v1/messages/1?token=secret", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, store.List())
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Board pages are available in several formats from the same URL: HTML for
+// browsers, JSON for API clients, plain text for curl and iCalendar for
+// calendar apps. An extension on the stop, e.g. /board/place-bbsta.txt, picks
+// the format explicitly. Otherwise it's negotiated from the Accept header,
+// with requests that accept anything without naming a type, as curl's do,
+// getting plain text, and requests with no Accept header at all HTML.
+
+// mimeCalendar is the media type of iCalendar data.
+const mimeCalendar = "text/calendar"
+
+// boardExtensions are the formats chosen by an extension on the stop.
+var boardExtensions = map[string]string{
+	".json": "json",
+	".txt":  "text",
+	".ics":  "ical",
+}
+
+// boardMediaTypes are the formats for each media type offered, in order of
+// preference when there's no Accept header.
+var boardMediaTypes = map[string]string{
+	gin.MIMEHTML:  "html",
+	gin.MIMEJSON:  "json",
+	gin.MIMEPlain: "text",
+	mimeCalendar:  "ical",
+}
+
+// stopParam returns the request's :stop parameter, without any format
+// extension.
+func stopParam(c *gin.Context) string {
+	stop := c.Param("stop")
+	if _, ok := boardExtensions[path.Ext(stop)]; ok {
+		return stop[:len(stop)-len(path.Ext(stop))]
+	}
+	return stop
+}
+
+// boardFormat returns the format the board for the request's :stop should be
+// rendered in: "html", "json", "text" or "ical".
+func boardFormat(c *gin.Context) string {
+	if format, ok := boardExtensions[path.Ext(c.Param("stop"))]; ok {
+		return format
+	}
+	offered := c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON, gin.MIMEPlain, mimeCalendar)
+	if offered == "" {
+		return "text"
+	}
+	return boardMediaTypes[offered]
+}
+
+// RenderBoard renders the board for the request's :stop parameter, in the
+// format described above.
+func RenderBoard(c *gin.Context, client MbtaService, defaults Filter) {
+	c.Header("Vary", "Accept")
+	switch format := boardFormat(c); format {
+	case "json":
+		RenderBoardJson(c, client, defaults)
+	case "text", "ical":
+		RenderBoardText(c, client, defaults, format)
+	default:
+		Render(c, client, []BoardDefinition{StopBoard(c, defaults)})
+	}
+}
+
+// RenderBoardText renders the board for the request's :stop parameter as
+// "text", the table written by the "once" subcommand under the board's title
+// and notices, or as an "ical" calendar of its departures.
+func RenderBoardText(c *gin.Context, client MbtaService, defaults Filter, format string) {
+	boards, err := FetchBoards(c, client, []BoardDefinition{StopBoard(c, defaults)})
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	board := boards[0]
+	var buf bytes.Buffer
+	contentType := "text/plain; charset=utf-8"
+	if format == "ical" {
+		contentType = mimeCalendar + "; charset=utf-8"
+		err = WriteCalendar(&buf, board.Title, board.Departures)
+	} else {
+		fmt.Fprintf(&buf, "%s\n\n", board.Title)
+		notices := board.Messages
+		if board.Rotation != nil {
+			notices = append(notices, board.Rotation.Item)
+		}
+		if board.Error != nil {
+			notices = append(notices, "Error: "+board.Error.Error())
+		}
+		if err = WriteMessages(&buf, notices, "text"); err == nil {
+			err = WriteDepartures(&buf, board.Departures, "text")
+		}
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Data(http.StatusOK, contentType, buf.Bytes())
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderBoardFormats(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	LoadTemplates(router, "templates")
+	router.GET("/board/:stop", func(c *gin.Context) {
+		RenderBoard(c, &MbtaServiceTest{"testdata/predictions-backbay.json"}, Filter{})
+	})
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+	browser := "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+
+	for _, c := range []struct {
+		path, accept, contentType string
+	}{
+		{"/board/place-bbsta", "", "text/html"},
+		{"/board/place-bbsta", browser, "text/html"},
+		{"/board/place-bbsta", "application/json", "application/json"},
+		{"/board/place-bbsta", "*/*", "text/plain"},
+		{"/board/place-bbsta", "text/plain", "text/plain"},
+		{"/board/place-bbsta", "text/calendar", "text/calendar"},
+		{"/board/place-bbsta.json", browser, "application/json"},
+		{"/board/place-bbsta.txt", browser, "text/plain"},
+		{"/board/place-bbsta.ics", browser, "text/calendar"},
+	} {
+		w := get(c.path, c.accept)
+		assert.Equal(t, http.StatusOK, w.Code, c.path+" "+c.accept)
+		assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), c.contentType),
+			"%s %s: %s", c.path, c.accept, w.Header().Get("Content-Type"))
+		assert.Equal(t, "Accept", w.Header().Get("Vary"))
+	}
+
+	// The extension isn't part of the stop.
+	var board DepartureBoard
+	assert.NoError(t, json.Unmarshal(get("/board/place-bbsta.json", "").Body.Bytes(), &board))
+	assert.Equal(t, "place-bbsta", board.Title)
+	assert.Len(t, board.Departures, 2)
+
+	text := get("/board/place-bbsta.txt?title=Back+Bay", "").Body.String()
+	assert.True(t, strings.HasPrefix(text, "Back Bay\n\nTIME "), text)
+	assert.Contains(t, text, "5:05PM")
+	assert.Contains(t, text, "Providence")
+
+	ical := get("/board/place-bbsta.ics", "").Body.String()
+	assert.Contains(t, ical, "BEGIN:VCALENDAR\r\n")
+	assert.Contains(t, ical, "SUMMARY:Train to Providence\r\n")
+
+	w := get("/board/place-bbsta.txt?direction=sideways", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,10 @@
+//go:build !dev
+// +build !dev
+
+package main
+
+import "github.com/gin-gonic/gin"
+
+// RegisterFixtureRoutes does nothing: the fixture routes are only built into
+// binaries built with the dev tag, so they can't be enabled in production.
+func RegisterFixtureRoutes(router gin.IRouter, dir string, boards []BoardDefinition) {}
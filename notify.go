@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// Notification is a message sent to the user through a Notifier.
+type Notification struct {
+	Title   string `json:"title"`
+	Message string `json:"message"`
+}
+
+// Notifier is a base interface for delivering notifications.
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+// LogNotifier is a Notifier that writes notifications to the log, used when
+// no other notifier is configured.
+type LogNotifier struct{}
+
+// Notify implements the Notifier interface for LogNotifier.
+func (LogNotifier) Notify(n Notification) error {
+	log.Printf("notification: %s: %s", n.Title, n.Message)
+	return nil
+}
+
+// WebhookNotifier is a Notifier that POSTs each notification as JSON to a URL.
+type WebhookNotifier struct {
+	Url    string
+	client *http.Client
+}
+
+// NewWebhookNotifier creates and returns a new WebhookNotifier for the URL.
+func NewWebhookNotifier(url string, httpClient *http.Client) *WebhookNotifier {
+	return &WebhookNotifier{url, httpClient}
+}
+
+// Notify implements the Notifier interface for WebhookNotifier.
+func (w *WebhookNotifier) Notify(n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Post(w.Url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New("webhook error: " + resp.Status)
+	}
+	return nil
+}
+
+// ConfiguredNotifier returns a WebhookNotifier for url, or a LogNotifier if
+// url is empty.
+func ConfiguredNotifier(url string) Notifier {
+	if url == "" {
+		return LogNotifier{}
+	}
+	return NewWebhookNotifier(url, NewHttpClient())
+}
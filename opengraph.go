@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Open Graph preview images are ogImageWidth by ogImageHeight, the size
+// recommended for link previews, and show the next ogImageRows departures.
+const (
+	ogImageWidth  = 1200
+	ogImageHeight = 630
+	ogImageRows   = 3
+	ogImageScale  = 3
+)
+
+// OpenGraph is the link preview metadata for a page showing a board.
+type OpenGraph struct {
+	Title       string
+	Description string
+	Image       string
+}
+
+// NewOpenGraph returns the link preview metadata for a page whose first board
+// is the given one. The image is the board's /og.png with the same query
+// string, so it shows the same departures, under the tenant's path prefix if
+// the page has one.
+func NewOpenGraph(c *gin.Context, def BoardDefinition, board *DepartureBoard) *OpenGraph {
+	var next []string
+	for i, d := range board.Departures {
+		if i == ogImageRows {
+			break
+		}
+		next = append(next, fmt.Sprintf("%s %s", d.TimeLabel, d.Destination))
+	}
+	description := "No upcoming departures"
+	if len(next) > 0 {
+		description = "Next departures: " + strings.Join(next, ", ")
+	}
+
+	query := c.Request.URL.Query()
+	query.Set("title", def.Title)
+	image := url.URL{
+		Scheme:   "http",
+		Host:     c.Request.Host,
+		Path:     c.GetString("prefix") + "/board/" + url.PathEscape(def.Stop) + "/og.png",
+		RawQuery: query.Encode(),
+	}
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		image.Scheme = "https"
+	}
+	return &OpenGraph{
+		Title:       board.Title,
+		Description: description,
+		Image:       image.String(),
+	}
+}
+
+// drawOgImage draws the board's next departures centered on a link preview
+// sized image.
+func drawOgImage(board *DepartureBoard) image.Image {
+	lines := boardLines(board, ogImageRows)
+	img := image.NewPaletted(image.Rect(0, 0, ogImageWidth, ogImageHeight), boardPalette)
+	drawn := drawBoard(lines, ogImageScale)
+	offset := image.Pt((ogImageWidth-drawn.Rect.Dx())/2, (ogImageHeight-drawn.Rect.Dy())/2)
+	draw.Draw(img, drawn.Rect.Add(offset), drawn, image.Point{}, draw.Src)
+	return img
+}
+
+// RenderBoardOgImage responds with the link preview image for the board for
+// the request's :stop parameter. Previews are cached briefly, since chat apps
+// fetch them for every link shared.
+func RenderBoardOgImage(c *gin.Context, client MbtaService, defaults Filter) {
+	def := StopBoard(c, defaults)
+	filter, err := ParseFilter(c, def.Filter)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	def.Filter = filter
+	board := FetchBoard(c, client, def)
+	c.Header("Content-Type", "image/png")
+	c.Header("Cache-Control", "public, max-age=60")
+	if err := png.Encode(c.Writer, drawOgImage(board)); err != nil {
+		c.Error(err)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenGraph(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	LoadTemplates(router, "templates")
+	service := &MbtaServiceTest{"testdata/predictions-backbay.json"}
+	router.GET("/board/:stop", func(c *gin.Context) {
+		RenderBoard(c, service, Filter{})
+	})
+	router.GET("/board/:stop/og.png", func(c *gin.Context) {
+		RenderBoardOgImage(c, service, Filter{})
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/board/place-bbsta?direction=both&title=Back+Bay", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `<meta property="og:title" content="Back Bay" />`)
+	assert.Contains(t, w.Body.String(),
+		`<meta property="og:description" content="Next departures: 5:05PM Providence, 5:12PM South Station, 5:20PM Worcester" />`)
+	assert.Contains(t, w.Body.String(),
+		`<meta property="og:image" content="http://example.com/board/place-bbsta/og.png?direction=both&amp;title=Back&#43;Bay" />`)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/board/place-bbsta/og.png?direction=both&title=Back+Bay", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	img, err := png.Decode(w.Body)
+	if assert.NoError(t, err) {
+		assert.Equal(t, ogImageWidth, img.Bounds().Dx())
+		assert.Equal(t, ogImageHeight, img.Bounds().Dy())
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"github.com/mattmckeon/splitflap/internal/mbta"
+)
+
+// Outage is an elevator or escalator that's currently out of service.
+type Outage struct {
+	Facility    string `json:"facility"`
+	Description string `json:"description"`
+}
+
+// outageFacilities maps the alert effects we show as outages to the name of
+// the facility that's affected.
+var outageFacilities = map[string]string{
+	"ELEVATOR_CLOSURE":  "Elevator",
+	"ESCALATOR_CLOSURE": "Escalator",
+}
+
+// OutageService is an interface for fetching the current elevator and
+// escalator outages at a stop.
+type OutageService interface {
+	ListOutages(place string) ([]Outage, error)
+}
+
+// ListOutages is an implementation of the OutageService ListOutages method
+// that fetches the stop's currently active accessibility alerts from the MBTA
+// APIv3 alerts endpoint.
+func (s *MbtaServiceImpl) ListOutages(place string) ([]Outage, error) {
+	alerts, err := s.mbta.Alerts(
+		mbta.Filter("stop", place),
+		mbta.Filter("activity", "USING_WHEELCHAIR", "USING_ESCALATOR"),
+		mbta.Filter("datetime", "NOW"))
+	if err != nil {
+		return nil, err
+	}
+	return ExtractOutages(alerts), nil
+}
+
+// ListOutages is an implementation of the OutageService ListOutages method
+// that ignores the provided place and loads test data from this test service's
+// JsonFile.
+func (s *MbtaServiceTest) ListOutages(place string) ([]Outage, error) {
+	var alerts []*mbta.Alert
+	if err := s.load(&alerts); err != nil {
+		return nil, err
+	}
+	return ExtractOutages(alerts), nil
+}
+
+// ExtractOutages returns an Outage for each elevator or escalator closure
+// among the alerts, ignoring any other kind of alert.
+func ExtractOutages(alerts []*mbta.Alert) []Outage {
+	outages := []Outage{}
+	for _, alert := range alerts {
+		facility, ok := outageFacilities[alert.Effect]
+		if !ok {
+			continue
+		}
+		description := alert.ShortHeader
+		if description == "" {
+			description = alert.Header
+		}
+		outages = append(outages, Outage{facility, description})
+	}
+	return outages
+}
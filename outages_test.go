@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// outageTestService serves departures and outages from separate fixtures.
+type outageTestService struct {
+	*MbtaServiceTest
+	outages *MbtaServiceTest
+}
+
+func (s outageTestService) ListOutages(place string) ([]Outage, error) {
+	return s.outages.ListOutages(place)
+}
+
+func TestParseOutages(t *testing.T) {
+	actual, err := (&MbtaServiceTest{"testdata/alerts-outages.json"}).ListOutages("place-north")
+	assert.NoError(t, err)
+
+	expected := []Outage{
+		{"Elevator", "Elevator 816 (Causeway St to lobby) unavailable"},
+		{"Escalator", "North Station Escalator 815 (lobby to Orange Line) unavailable"},
+	}
+	assert.Equal(t, expected, actual)
+}
+
+func TestOutagesOnBoard(t *testing.T) {
+	defer func() { outageStations = map[string]bool{} }()
+	outageStations = map[string]bool{"place-north": true}
+	service := outageTestService{
+		&MbtaServiceTest{"testdata/predictions.json"},
+		&MbtaServiceTest{"testdata/alerts-outages.json"},
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	LoadTemplates(router, "templates")
+	router.GET("/board/:stop", func(c *gin.Context) {
+		RenderBoard(c, service, Filter{})
+	})
+	router.GET("/api/v1/board/:stop", func(c *gin.Context) {
+		RenderBoardJson(c, service, Filter{})
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/board/place-north", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `<span class="outage">Elevator out: Elevator 816 (Causeway St to lobby) unavailable</span>`)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/board/place-north", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var board struct {
+		Title      string
+		Departures []Departure
+		Outages    []Outage
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &board))
+	assert.Equal(t, "place-north", board.Title)
+	assert.Len(t, board.Departures, 6)
+	assert.Len(t, board.Outages, 2)
+
+	// Outages are only fetched for the configured stations.
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/board/place-sstat", nil))
+	assert.NotContains(t, w.Body.String(), "outages")
+}
+
+func TestBoardJsonError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/v1/board/:stop", func(c *gin.Context) {
+		RenderBoardJson(c, &MbtaServiceTest{"testdata/error-429.json"}, Filter{})
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/board/place-north", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{
+		"title": "place-north",
+		"departures": null,
+		"error": "MBTA API error: You have exceeded your allowed usage rate."
+	}`, w.Body.String())
+}
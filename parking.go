@@ -0,0 +1,79 @@
+package main
+
+import (
+	"github.com/mattmckeon/splitflap/internal/mbta"
+)
+
+// Parking is the current availability of a single parking facility.
+type Parking struct {
+	Name      string `json:"name"`
+	Capacity  int    `json:"capacity"`
+	Available int    `json:"available"`
+}
+
+// ParkingService is an interface for fetching parking availability at a stop.
+type ParkingService interface {
+	ListParking(place string) ([]Parking, error)
+}
+
+// ListParking is an implementation of the ParkingService ListParking method
+// that looks up the parking facilities at a stop, then fetches their live
+// data. Facilities with no live data are left out, so stations without
+// real-time parking information return an empty slice.
+func (s *MbtaServiceImpl) ListParking(place string) ([]Parking, error) {
+	rawFacilities, err := s.mbta.Facilities(
+		mbta.Filter("stop", place),
+		mbta.Filter("type", "PARKING_AREA"))
+	if err != nil || len(rawFacilities) == 0 {
+		return nil, err
+	}
+	facilities := map[string]*mbta.Facility{}
+	ids := make([]string, len(rawFacilities))
+	for i, facility := range rawFacilities {
+		facilities[facility.Id] = facility
+		ids[i] = facility.Id
+	}
+
+	rawLive, err := s.mbta.LiveFacilities(mbta.Filter("id", ids...))
+	if err != nil {
+		return nil, err
+	}
+	parking := []Parking{}
+	for _, live := range rawLive {
+		facility, ok := facilities[live.Id]
+		if !ok {
+			continue
+		}
+		properties := facilityProperties(live.Properties)
+		capacity, ok := properties["capacity"].(float64)
+		if !ok {
+			continue
+		}
+		utilization, _ := properties["utilization"].(float64)
+		available := int(capacity - utilization)
+		if available < 0 {
+			available = 0
+		}
+		parking = append(parking, Parking{
+			Name:      facility.LongName,
+			Capacity:  int(capacity),
+			Available: available,
+		})
+	}
+	return parking, nil
+}
+
+// facilityProperties converts the name/value pairs in a facility's properties
+// attribute into a map. Properties with the same name are collapsed, with the
+// last one winning.
+func facilityProperties(raw []interface{}) map[string]interface{} {
+	properties := map[string]interface{}{}
+	for _, p := range raw {
+		if pair, ok := p.(map[string]interface{}); ok {
+			if name, ok := pair["name"].(string); ok {
+				properties[name] = pair["value"]
+			}
+		}
+	}
+	return properties
+}
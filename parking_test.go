@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func TestListParking(t *testing.T) {
+	defer gock.Off()
+
+	gock.New(MbtaApiV3BaseUrl).
+		Get("/facilities").
+		MatchParam("filter[stop]", "place-NHRML-0127").
+		MatchParam("filter[type]", "PARKING_AREA").
+		Reply(200).
+		File("testdata/facilities-parking.json")
+	gock.New(MbtaApiV3BaseUrl).
+		Get("/live_facilities").
+		MatchParam("filter[id]", "park-ER-0115-garage,park-ER-0115-lot").
+		Reply(200).
+		File("testdata/live-facilities.json")
+
+	httpClient := &http.Client{}
+	gock.InterceptClient(httpClient)
+
+	parking, err := NewMbtaServiceImpl(httpClient).ListParking("place-NHRML-0127")
+	assert.NoError(t, err)
+	assert.Equal(t, []Parking{{"Anderson/Woburn Garage", 2010, 510}}, parking)
+	assert.True(t, gock.IsDone())
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mattmckeon/splitflap/internal/mbta"
+)
+
+// The MBTA service day starts at 3AM, so trains running after midnight belong
+// to the previous day. Between the last train and the rollover there's an
+// overnight gap in which the next day's schedule is precomputed.
+const (
+	serviceDayStart   = 3 * time.Hour
+	overnightGapStart = time.Hour
+)
+
+// serviceDay returns midnight at the start of the service day containing t,
+// in serviceTimeZone.
+func serviceDay(t time.Time) time.Time {
+	t = t.In(serviceTimeZone).Add(-serviceDayStart)
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, serviceTimeZone)
+}
+
+// inOvernightGap reports whether t falls in the overnight gap in service.
+func inOvernightGap(t time.Time) bool {
+	t = t.In(serviceTimeZone)
+	y, m, d := t.Date()
+	sinceMidnight := t.Sub(time.Date(y, m, d, 0, 0, 0, 0, serviceTimeZone))
+	return sinceMidnight >= overnightGapStart && sinceMidnight < serviceDayStart
+}
+
+// DatedScheduleService is an interface for fetching a stop's schedule on a
+// given service day.
+type DatedScheduleService interface {
+	ScheduleService
+	SchedulesOn(place string, day time.Time) ([]*mbta.Schedule, error)
+}
+
+// SchedulesOn is an implementation of the DatedScheduleService SchedulesOn
+// method that fetches the schedule from the MBTA APIv3 schedules endpoint.
+func (s *MbtaServiceImpl) SchedulesOn(place string, day time.Time) ([]*mbta.Schedule, error) {
+	return s.mbta.Schedules(
+		mbta.Filter("stop", place),
+		mbta.Filter("date", day.Format("2006-01-02")),
+		mbta.Filter("revenue", "REVENUE"),
+		mbta.Include("route", "trip"),
+		mbta.Sort("departure_time"))
+}
+
+// SchedulesOn is an implementation of the DatedScheduleService SchedulesOn
+// method that ignores the provided place and day and loads test data from
+// this test service's JsonFile.
+func (s *MbtaServiceTest) SchedulesOn(place string, day time.Time) ([]*mbta.Schedule, error) {
+	var schedules []*mbta.Schedule
+	if err := s.load(&schedules); err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// SchedulePrecomputer is a DatedScheduleService that fetches the next service
+// day's schedules for Stops during the overnight gap, so the first schedule
+// pages, spans and boards' last trains of the morning are served without
+// waiting on the API or racing its rollover to the new day. Other stops and
+// days are passed through to the wrapped service.
+type SchedulePrecomputer struct {
+	DatedScheduleService
+	Stops []string
+
+	mu        sync.Mutex
+	day       time.Time
+	schedules map[string][]*mbta.Schedule
+}
+
+// NewSchedulePrecomputer creates and returns a new SchedulePrecomputer for
+// the given stops.
+func NewSchedulePrecomputer(service DatedScheduleService, stops []string) *SchedulePrecomputer {
+	return &SchedulePrecomputer{DatedScheduleService: service, Stops: stops}
+}
+
+// Precompute fetches the next service day's schedules if it's the overnight
+// gap and they haven't been fetched yet. A stop that fails is retried the
+// next time Precompute is called.
+func (p *SchedulePrecomputer) Precompute() {
+	now := clock()
+	if !inOvernightGap(now) {
+		return
+	}
+	next := serviceDay(now).AddDate(0, 0, 1)
+	p.mu.Lock()
+	if !p.day.Equal(next) {
+		p.day, p.schedules = next, map[string][]*mbta.Schedule{}
+	}
+	var missing []string
+	for _, stop := range p.Stops {
+		if _, ok := p.schedules[stop]; !ok {
+			missing = append(missing, stop)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, stop := range missing {
+		schedules, err := p.DatedScheduleService.SchedulesOn(stop, next)
+		if err != nil {
+			log.Printf("precompute: %s on %s: %v", stop, next.Format("2006-01-02"), err)
+			continue
+		}
+		p.mu.Lock()
+		if p.day.Equal(next) {
+			p.schedules[stop] = schedules
+		}
+		p.mu.Unlock()
+	}
+}
+
+// Run precomputes every interval until done is closed.
+func (p *SchedulePrecomputer) Run(interval time.Duration, done <-chan struct{}) {
+	poll(interval, done, p.Precompute)
+}
+
+// precomputed returns the precomputed schedule for the stop on the service
+// day, if there is one.
+func (p *SchedulePrecomputer) precomputed(place string, day time.Time) ([]*mbta.Schedule, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	schedules, ok := p.schedules[place]
+	return schedules, ok && p.day.Equal(day)
+}
+
+// SchedulesOn is an implementation of the DatedScheduleService SchedulesOn
+// method that serves the precomputed schedule for the stop if it's for the
+// given service day.
+func (p *SchedulePrecomputer) SchedulesOn(place string, day time.Time) ([]*mbta.Schedule, error) {
+	if schedules, ok := p.precomputed(place, day); ok {
+		return schedules, nil
+	}
+	return p.DatedScheduleService.SchedulesOn(place, day)
+}
+
+// SpanSchedules is an implementation of the SpanService SpanSchedules method
+// that serves the precomputed schedule for the stop, with all of its routes, if
+// it's for the given service day.
+func (p *SchedulePrecomputer) SpanSchedules(place, route string, day time.Time) ([]*mbta.Schedule, error) {
+	if schedules, ok := p.precomputed(place, day); ok {
+		return schedules, nil
+	}
+	if spans, ok := p.DatedScheduleService.(SpanService); ok {
+		return spans.SpanSchedules(place, route, day)
+	}
+	return p.DatedScheduleService.SchedulesOn(place, day)
+}
+
+// ListSchedules is an implementation of the ScheduleService ListSchedules
+// method that serves the precomputed schedule for the stop if it's for the
+// current service day. Precomputed schedules aren't filtered upstream, so the
+// filter's window is applied here.
+func (p *SchedulePrecomputer) ListSchedules(place string, filter Filter) ([]ScheduleGroup, error) {
+	now := clock()
+	schedules, ok := p.precomputed(place, serviceDay(now))
+	if !ok {
+		return p.DatedScheduleService.ListSchedules(place, filter)
+	}
+	if filter.Window > 0 {
+		cutoff := now.Add(filter.Window)
+		var windowed []*mbta.Schedule
+		for _, schedule := range schedules {
+			t, err := parseServiceTime(schedule.DepartureTime)
+			if err == nil && (t.Before(now) || t.After(cutoff)) {
+				continue
+			}
+			windowed = append(windowed, schedule)
+		}
+		schedules = windowed
+	}
+	return ExtractSchedules(schedules, filter)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattmckeon/splitflap/internal/mbta"
+	"github.com/stretchr/testify/assert"
+)
+
+// datedScheduleService serves the schedules fixture, remembering the days it
+// was asked for and how many times it was asked for today's schedule.
+type datedScheduleService struct {
+	MbtaServiceTest
+	days  []time.Time
+	today int
+}
+
+func (s *datedScheduleService) SchedulesOn(place string, day time.Time) ([]*mbta.Schedule, error) {
+	s.days = append(s.days, day)
+	return s.MbtaServiceTest.SchedulesOn(place, day)
+}
+
+func (s *datedScheduleService) ListSchedules(place string, filter Filter) ([]ScheduleGroup, error) {
+	s.today++
+	return s.MbtaServiceTest.ListSchedules(place, filter)
+}
+
+func TestServiceDay(t *testing.T) {
+	sep10 := time.Date(2018, 9, 10, 0, 0, 0, 0, serviceTimeZone)
+	assert.Equal(t, sep10, serviceDay(time.Date(2018, 9, 10, 6, 0, 0, 0, serviceTimeZone)))
+	assert.Equal(t, sep10, serviceDay(time.Date(2018, 9, 11, 1, 30, 0, 0, serviceTimeZone)))
+
+	assert.False(t, inOvernightGap(time.Date(2018, 9, 10, 0, 30, 0, 0, serviceTimeZone)))
+	assert.True(t, inOvernightGap(time.Date(2018, 9, 10, 1, 30, 0, 0, serviceTimeZone)))
+	assert.False(t, inOvernightGap(time.Date(2018, 9, 10, 3, 0, 0, 0, serviceTimeZone)))
+}
+
+func TestSchedulePrecomputer(t *testing.T) {
+	defer func() { clock = time.Now }()
+	service := &datedScheduleService{MbtaServiceTest: MbtaServiceTest{"testdata/schedules.json"}}
+	p := NewSchedulePrecomputer(service, []string{"place-north"})
+
+	// Nothing is fetched during service.
+	clock = func() time.Time { return time.Date(2018, 9, 9, 22, 0, 0, 0, serviceTimeZone) }
+	p.Precompute()
+	assert.Empty(t, service.days)
+
+	// The next day is fetched once during the overnight gap, but not served
+	// until it starts.
+	clock = func() time.Time { return time.Date(2018, 9, 10, 1, 30, 0, 0, serviceTimeZone) }
+	p.Precompute()
+	p.Precompute()
+	assert.Equal(t, []time.Time{time.Date(2018, 9, 10, 0, 0, 0, 0, serviceTimeZone)}, service.days)
+	_, err := p.ListSchedules("place-north", Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, service.today)
+
+	clock = func() time.Time { return time.Date(2018, 9, 10, 6, 30, 0, 0, serviceTimeZone) }
+	groups, err := p.ListSchedules("place-north", Filter{Window: time.Hour})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, service.today)
+	assert.Equal(t, []ScheduleGroup{
+		{"Haverhill Line", []ScheduledDeparture{{"6:35AM", "Haverhill", false}}},
+		{"Lowell Line", []ScheduledDeparture{{"6:45AM", "Lowell", false}}},
+	}, groups)
+
+	// Other stops are passed through.
+	_, err = p.ListSchedules("place-sstat", Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, service.today)
+
+	// Spans and last trains ask by day, and are served the precomputed day too.
+	day := time.Date(2018, 9, 10, 0, 0, 0, 0, serviceTimeZone)
+	schedules, err := p.SchedulesOn("place-north", day)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, schedules)
+	assert.Len(t, service.days, 1)
+	_, err = p.SchedulesOn("place-north", day.AddDate(0, 0, 1))
+	assert.NoError(t, err)
+	assert.Len(t, service.days, 2)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"expvar"
+	"log"
+	"math"
+	"time"
+)
+
+// DataQuality are indicators of the quality of the MBTA's data for a board,
+// so that upstream regressions show up on the status page rather than just
+// making the board worse: the percentages of its departures without a track
+// and without a schedule and the number of times that couldn't be parsed, as
+// of the last fetch, and the percentage of the departures scheduled in the
+// next coverageWindow that have predictions, as of the last coverage check.
+type DataQuality struct {
+	Departures          int     `json:"departures"`
+	MissingTrackPercent float64 `json:"missing_track_percent"`
+	UnscheduledPercent  float64 `json:"unscheduled_percent"`
+	ParseErrors         int     `json:"parse_errors"`
+	Scheduled           int     `json:"scheduled_next_hour"`
+	CoveragePercent     float64 `json:"schedule_coverage_percent"`
+}
+
+// coverageWindow is how far ahead the coverage check compares the predictions
+// with the schedule.
+const coverageWindow = time.Hour
+
+func init() {
+	// The quality of each board is published with the other expvars at
+	// /debug/vars.
+	expvar.Publish("quality", expvar.Func(func() interface{} {
+		return statusTracker.Quality()
+	}))
+}
+
+// percentOf returns n as a percentage of total, to one decimal place, or 0 if
+// total is.
+func percentOf(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(1000*float64(n)/float64(total)) / 10
+}
+
+// measureQuality sets the indicators of the quality of the board's
+// departures, leaving the coverage as it was.
+func measureQuality(q *DataQuality, board *DepartureBoard) {
+	missingTrack, unscheduled := 0, 0
+	for _, d := range board.Departures {
+		if d.Track == "" || d.Track == "TBD" {
+			missingTrack++
+		}
+		if d.ScheduledTime.IsZero() {
+			unscheduled++
+		}
+	}
+	q.Departures = len(board.Departures)
+	q.MissingTrackPercent = percentOf(missingTrack, q.Departures)
+	q.UnscheduledPercent = percentOf(unscheduled, q.Departures)
+	q.ParseErrors = 0
+	if parseError, ok := board.Error.(*ParseError); ok {
+		q.ParseErrors = len(parseError.Errors)
+	}
+}
+
+// scheduleCoverage returns the number of scheduled departures and how many of
+// them are among the predicted departures, matched by scheduled time and
+// destination.
+func scheduleCoverage(groups []ScheduleGroup, departures []Departure) (scheduled, covered int) {
+	predicted := map[string]bool{}
+	for _, d := range departures {
+		predicted[d.ScheduledLabel()+" "+d.Destination] = true
+	}
+	for _, g := range groups {
+		for _, sd := range g.Departures {
+			scheduled++
+			if predicted[sd.TimeLabel+" "+sd.Destination] {
+				covered++
+			}
+		}
+	}
+	return scheduled, covered
+}
+
+// CoverageChecker compares the departures predicted for the defined boards
+// with their schedules, and records the coverage with the status tracker.
+type CoverageChecker struct {
+	service   MbtaService
+	schedules ScheduleService
+	defs      []BoardDefinition
+	tracker   *StatusTracker
+}
+
+// NewCoverageChecker creates and returns a new CoverageChecker for the
+// defined boards.
+func NewCoverageChecker(service MbtaService, schedules ScheduleService, defs []BoardDefinition, tracker *StatusTracker) *CoverageChecker {
+	return &CoverageChecker{service, schedules, defs, tracker}
+}
+
+// Check fetches the schedule and predictions for the next coverageWindow for
+// each board and records their coverage. Boards that fail are logged and left
+// as they were.
+func (c *CoverageChecker) Check() {
+	for _, def := range c.defs {
+		filter := def.Filter
+		filter.Window = coverageWindow
+		groups, err := c.schedules.ListSchedules(def.Stop, filter)
+		if err != nil {
+			log.Printf("coverage: %s: %v", def.Title, err)
+			continue
+		}
+		departures, err := c.service.ListDepartures(def.Stop, filter)
+		if departures == nil {
+			log.Printf("coverage: %s: %v", def.Title, err)
+			continue
+		}
+		scheduled, covered := scheduleCoverage(groups, departures)
+		c.tracker.RecordCoverage(def.Stop, scheduled, covered)
+	}
+}
+
+// Run checks the coverage every interval until done is closed.
+func (c *CoverageChecker) Run(interval time.Duration, done <-chan struct{}) {
+	poll(interval, done, c.Check)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMeasureQuality(t *testing.T) {
+	board := &DepartureBoard{Title: "Back Bay"}
+	board.setDepartures((&MbtaServiceTest{"testdata/predictions-backbay.json"}).
+		ListDepartures("place-bbsta", Filter{Direction: "both"}))
+	var q DataQuality
+	measureQuality(&q, board)
+	assert.Equal(t, DataQuality{Departures: 4, MissingTrackPercent: 25, UnscheduledPercent: 100}, q)
+
+	board = &DepartureBoard{
+		Departures: []Departure{
+			{Track: "1", ScheduledTime: at("2018-09-10T17:05:00-04:00")},
+			{Track: "", ScheduledTime: at("2018-09-10T17:12:00-04:00")},
+			{Track: "3"},
+		},
+		Error: &ParseError{Errors: []error{errors.New("(Parse Error) soon")}},
+	}
+	q = DataQuality{Scheduled: 4, CoveragePercent: 50}
+	measureQuality(&q, board)
+	assert.Equal(t, DataQuality{Departures: 3, MissingTrackPercent: 33.3, UnscheduledPercent: 33.3,
+		ParseErrors: 1, Scheduled: 4, CoveragePercent: 50}, q)
+}
+
+func TestScheduleCoverage(t *testing.T) {
+	groups := []ScheduleGroup{
+		{"Providence/Stoughton Line", []ScheduledDeparture{
+			{TimeLabel: "5:05PM", Destination: "Providence"},
+			{TimeLabel: "5:31PM", Destination: "South Station"},
+		}},
+		{"Framingham/Worcester Line", []ScheduledDeparture{
+			{TimeLabel: "5:20PM", Destination: "Worcester"},
+		}},
+	}
+	departures := []Departure{
+		{Destination: "Providence", ScheduledTime: at("2018-09-10T17:05:00-04:00"), Time: at("2018-09-10T17:09:00-04:00")},
+		{Destination: "Worcester", Time: at("2018-09-10T17:20:00-04:00")},
+	}
+	scheduled, covered := scheduleCoverage(groups, departures)
+	assert.Equal(t, 3, scheduled)
+	assert.Equal(t, 1, covered)
+}
+
+// coverageTestService serves schedules and predictions from separate
+// fixtures.
+type coverageTestService struct {
+	*MbtaServiceTest
+	schedules *MbtaServiceTest
+}
+
+func (s coverageTestService) ListSchedules(place string, filter Filter) ([]ScheduleGroup, error) {
+	return s.schedules.ListSchedules(place, filter)
+}
+
+func TestCoverageChecker(t *testing.T) {
+	service := coverageTestService{
+		&MbtaServiceTest{"testdata/predictions.json"},
+		&MbtaServiceTest{"testdata/schedules.json"},
+	}
+	tracker := NewStatusTracker()
+	defs := []BoardDefinition{
+		{Title: "North Station", Stop: "place-north"},
+		{Title: "Broken", Stop: "place-north"},
+	}
+	NewCoverageChecker(service, service, defs[:1], tracker).Check()
+	NewCoverageChecker(&MbtaServiceTest{"testdata/error-429.json"}, service, defs[1:], tracker).Check()
+
+	quality := tracker.Quality()
+	assert.Equal(t, 5, quality["place-north"].Scheduled)
+	assert.Len(t, quality, 1)
+
+	// Recording the board keeps the coverage.
+	tracker.RecordBoard("place-north", &DepartureBoard{Title: "North Station", Departures: []Departure{{Track: "2"}}})
+	q := tracker.Quality()["place-north"]
+	assert.Equal(t, 5, q.Scheduled)
+	assert.Equal(t, 1, q.Departures)
+	assert.Equal(t, 100.0, q.UnscheduledPercent)
+
+	assert.NotNil(t, expvar.Get("quality"))
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattmckeon/splitflap/internal/mbta"
+)
+
+// DelayReason is why the trains on a route, or a single trip if Trip is set,
+// are running late, from an active alert.
+type DelayReason struct {
+	Route  string `json:"route"`
+	Trip   string `json:"trip,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// DelayReasonService is an interface for fetching the reasons for the delays
+// on some routes.
+type DelayReasonService interface {
+	DelayReasons(routes []string) ([]DelayReason, error)
+}
+
+// delayEffects are the alert effects whose causes explain late or cancelled
+// trains.
+var delayEffects = map[string]bool{
+	"DELAY":        true,
+	"CANCELLATION": true,
+	"SUSPENSION":   true,
+}
+
+// delayCauses are the short reasons shown for alert causes that don't read
+// well as they are. Other causes are shown in lower case, and UNKNOWN_CAUSE
+// not at all.
+var delayCauses = map[string]string{
+	"MECHANICAL_PROBLEM": "mechanical issue",
+	"POLICE_ACTIVITY":    "police activity",
+	"WEATHER":            "weather",
+	"UNKNOWN_CAUSE":      "",
+}
+
+// delayReasonTtl is how long the reasons for the delays on a set of routes,
+// or the failure to fetch them, are kept before asking the API again.
+const delayReasonTtl = time.Minute
+
+// delayReasonCache holds the recently fetched reasons for the delays on each
+// set of routes, so that every page view of a delayed board doesn't fetch the
+// alerts again.
+type delayReasonCache struct {
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+// DelayReasons is an implementation of the DelayReasonService DelayReasons
+// method that fetches the routes' currently active alerts from the MBTA APIv3
+// alerts endpoint, at most once every delayReasonTtl for the same routes.
+func (s *MbtaServiceImpl) DelayReasons(routes []string) ([]DelayReason, error) {
+	sorted := append([]string(nil), routes...)
+	sort.Strings(sorted)
+	key := strings.Join(sorted, ",")
+	now := clock()
+	s.delayReasons.mu.Lock()
+	if entry, ok := s.delayReasons.entries[key]; ok && now.Before(entry.retryAfter) {
+		s.delayReasons.mu.Unlock()
+		reasons, _ := entry.value.([]DelayReason)
+		return reasons, entry.err
+	}
+	s.delayReasons.mu.Unlock()
+
+	var reasons []DelayReason
+	alerts, err := s.mbta.Alerts(
+		mbta.Filter("route", sorted...),
+		mbta.Filter("datetime", "NOW"))
+	if err == nil {
+		reasons = ExtractDelayReasons(alerts)
+	}
+
+	s.delayReasons.mu.Lock()
+	defer s.delayReasons.mu.Unlock()
+	if s.delayReasons.entries == nil {
+		s.delayReasons.entries = map[string]*cacheEntry{}
+	}
+	// Drop the expired entries, since the sets of routes asked for change
+	// with the delays.
+	for k, entry := range s.delayReasons.entries {
+		if !now.Before(entry.retryAfter) {
+			delete(s.delayReasons.entries, k)
+		}
+	}
+	s.delayReasons.entries[key] = &cacheEntry{value: reasons, fetched: now, err: err, retryAfter: now.Add(delayReasonTtl)}
+	return reasons, err
+}
+
+// ExtractDelayReasons returns a DelayReason for each route or trip informed
+// by the alerts about delays that have a known cause.
+func ExtractDelayReasons(alerts []*mbta.Alert) []DelayReason {
+	reasons := []DelayReason{}
+	for _, alert := range alerts {
+		reason, ok := delayCauses[alert.Cause]
+		if !ok {
+			reason = strings.ToLower(strings.Replace(alert.Cause, "_", " ", -1))
+		}
+		if !delayEffects[alert.Effect] || reason == "" {
+			continue
+		}
+		for _, e := range alert.InformedEntity {
+			entity, _ := e.(map[string]interface{})
+			route, _ := entity["route"].(string)
+			trip, _ := entity["trip"].(string)
+			if route != "" || trip != "" {
+				reasons = append(reasons, DelayReason{route, trip, reason})
+			}
+		}
+	}
+	return reasons
+}
+
+// delayed reports whether a departure is running late or cancelled.
+func delayed(d Departure) bool {
+	switch strings.ToLower(d.Status) {
+	case "delayed", "cancelled":
+		return true
+	}
+	return !d.Time.IsZero() && !d.ScheduledTime.IsZero() &&
+		d.Time.Sub(d.ScheduledTime) >= time.Minute
+}
+
+// delayedRoutes returns the routes of the delayed departures.
+func delayedRoutes(departures []Departure) []string {
+	routes := []string{}
+	seen := map[string]bool{}
+	for _, d := range departures {
+		if delayed(d) && d.Route != "" && !seen[d.Route] {
+			seen[d.Route] = true
+			routes = append(routes, d.Route)
+		}
+	}
+	return routes
+}
+
+// withDelayReasons returns a copy of the departures with the reasons for the
+// delayed ones set. Reasons for a trip take precedence over those for its
+// route.
+func withDelayReasons(departures []Departure, reasons []DelayReason) []Departure {
+	byTrip := map[string]string{}
+	byRoute := map[string]string{}
+	for _, r := range reasons {
+		if r.Trip != "" {
+			byTrip[r.Trip] = r.Reason
+		} else if _, ok := byRoute[r.Route]; !ok {
+			byRoute[r.Route] = r.Reason
+		}
+	}
+	departures = append([]Departure(nil), departures...)
+	for i, d := range departures {
+		if !delayed(d) {
+			continue
+		}
+		if reason, ok := byTrip[d.Trip]; ok && d.Trip != "" {
+			departures[i].DelayReason = reason
+		} else {
+			departures[i].DelayReason = byRoute[d.Route]
+		}
+	}
+	return departures
+}
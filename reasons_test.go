@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mattmckeon/splitflap/internal/mbta"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+// reasonTestService serves departures and the alerts explaining their delays
+// from separate fixtures.
+type reasonTestService struct {
+	*MbtaServiceTest
+	alerts *MbtaServiceTest
+}
+
+func (s reasonTestService) DelayReasons(routes []string) ([]DelayReason, error) {
+	var alerts []*mbta.Alert
+	if err := s.alerts.load(&alerts); err != nil {
+		return nil, err
+	}
+	return ExtractDelayReasons(alerts), nil
+}
+
+func TestExtractDelayReasons(t *testing.T) {
+	reasons, err := reasonTestService{alerts: &MbtaServiceTest{"testdata/alerts-delays.json"}}.
+		DelayReasons([]string{"CR-Providence", "CR-Worcester"})
+	assert.NoError(t, err)
+	assert.Equal(t, []DelayReason{
+		{Route: "CR-Providence", Trip: "t4", Reason: "signal problem"},
+		{Route: "CR-Worcester", Reason: "mechanical issue"},
+	}, reasons)
+}
+
+func TestDelayReasonsRequest(t *testing.T) {
+	defer gock.Off()
+	gock.New(MbtaApiV3BaseUrl).
+		Get("/alerts").
+		MatchParam("filter[route]", "^CR-Providence,CR-Worcester$").
+		MatchParam("filter[datetime]", "^NOW$").
+		Reply(200).
+		File("testdata/alerts-delays.json")
+
+	httpClient := &http.Client{}
+	gock.InterceptClient(httpClient)
+	service := NewMbtaServiceImpl(httpClient)
+	reasons, err := service.DelayReasons([]string{"CR-Providence", "CR-Worcester"})
+	assert.NoError(t, err)
+	assert.Len(t, reasons, 2)
+	assert.True(t, gock.IsDone())
+
+	// The same routes, in any order, are answered from the cache.
+	reasons, err = service.DelayReasons([]string{"CR-Worcester", "CR-Providence"})
+	assert.NoError(t, err)
+	assert.Len(t, reasons, 2)
+}
+
+func TestWithDelayReasons(t *testing.T) {
+	departures := []Departure{
+		{Route: "CR-Providence", Trip: "t1", Status: "Delayed"},
+		{Route: "CR-Providence", Trip: "t2", Status: "Delayed"},
+		{Route: "CR-Providence", Trip: "t3", Status: "On time"},
+		{Route: "CR-Worcester", Trip: "t4", Status: "Cancelled"},
+		{Route: "CR-Worcester", Trip: "t5", Time: at("2018-09-10T17:08:00-04:00"), ScheduledTime: at("2018-09-10T17:05:00-04:00")},
+		{Route: "CR-Lowell", Trip: "t6", Status: "Delayed"},
+	}
+	reasons := []DelayReason{
+		{Route: "CR-Providence", Reason: "signal problem"},
+		{Route: "CR-Providence", Trip: "t2", Reason: "mechanical issue"},
+		{Route: "CR-Worcester", Reason: "weather"},
+	}
+	assert.Equal(t, []string{"CR-Providence", "CR-Worcester", "CR-Lowell"}, delayedRoutes(departures))
+
+	actual := withDelayReasons(departures, reasons)
+	got := []string{}
+	for _, d := range actual {
+		got = append(got, d.DelayReason)
+	}
+	assert.Equal(t, []string{"signal problem", "mechanical issue", "", "weather", "weather", ""}, got)
+	// The departures passed in, which may be cached, are left alone.
+	assert.Equal(t, "", departures[0].DelayReason)
+}
+
+func TestDelayReasonsOnBoard(t *testing.T) {
+	service := reasonTestService{
+		&MbtaServiceTest{"testdata/predictions-backbay.json"},
+		&MbtaServiceTest{"testdata/alerts-delays.json"},
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	LoadTemplates(router, "templates")
+	router.GET("/board/:stop", func(c *gin.Context) {
+		RenderBoard(c, service, Filter{})
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/board/place-bbsta?direction=both", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `<td class="status delayed" title="signal problem">Delayed<span class="delayReason">signal problem</span></td>`)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/board/place-bbsta.json?direction=both", nil))
+	var board DepartureBoard
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &board))
+	reasons := map[string]string{}
+	for _, d := range board.Departures {
+		reasons[d.Trip] = d.DelayReason
+	}
+	assert.Equal(t, map[string]string{"t1": "", "t2": "", "t3": "", "t4": "signal problem"}, reasons)
+}
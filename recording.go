@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Recorder is an MbtaService that writes the departures fetched from another
+// service to a recording, a JSON Lines file of BoardUpdates in the same format
+// as the "stream" subcommand with the filter of each board added. A line is
+// written whenever the departures (or error) for a stop and filter change, or
+// when a board that's one of the maxTrackedBoards most recently fetched comes
+// back. Recordings can be played back with a ReplayService.
+type Recorder struct {
+	MbtaService
+
+	mu     sync.Mutex
+	enc    *json.Encoder
+	last   map[string]*BoardUpdate
+	recent recentKeys
+}
+
+// NewRecorder returns a Recorder that writes the departures from service to
+// out.
+func NewRecorder(service MbtaService, out io.Writer) *Recorder {
+	return &Recorder{
+		MbtaService: service,
+		enc:         json.NewEncoder(out),
+		last:        map[string]*BoardUpdate{},
+		recent:      recentKeys{Max: maxTrackedBoards},
+	}
+}
+
+// Unwrap returns the wrapped service.
+func (r *Recorder) Unwrap() MbtaService {
+	return r.MbtaService
+}
+
+// ListDepartures is an implementation of the MbtaService ListDepartures method
+// that fetches the departures from the wrapped service and records them if
+// they've changed. Failing to write the recording doesn't fail the fetch.
+func (r *Recorder) ListDepartures(place string, filter Filter) ([]Departure, error) {
+	departures, err := r.MbtaService.ListDepartures(place, filter)
+	update := &BoardUpdate{
+		Time:       clock(),
+		Stop:       place,
+		Filter:     &filter,
+		Departures: departures,
+	}
+	if err != nil {
+		update.Error = err.Error()
+	}
+
+	key := recordingKey(place, &filter)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if oldest, ok := r.recent.use(key); ok {
+		delete(r.last, oldest)
+	}
+	if last := r.last[key]; last != nil &&
+		reflect.DeepEqual(last.Departures, update.Departures) && last.Error == update.Error {
+		return departures, err
+	}
+	r.last[key] = update
+	if werr := r.enc.Encode(update); werr != nil {
+		statusTracker.RecordError("recorder", werr)
+	}
+	return departures, err
+}
+
+// recordingKey returns the key of a board's updates in a recording: the stop,
+// and the filter if it was recorded, so that boards for the same stop with
+// different filters don't overwrite each other.
+func recordingKey(place string, filter *Filter) string {
+	if filter == nil {
+		return place
+	}
+	return fmt.Sprintf("%s %+v", place, *filter)
+}
+
+// ReplayService is an MbtaService that plays back a recording. Its clock
+// starts at the time of the first update and runs Speed times faster than
+// real time; each board shows its latest update as of then.
+type ReplayService struct {
+	Speed float64
+
+	updates map[string][]BoardUpdate
+	start   time.Time
+	started time.Time
+	realNow func() time.Time
+}
+
+// LoadReplay reads the recording at path into a ReplayService.
+func LoadReplay(path string, speed float64) (*ReplayService, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return NewReplayService(f, speed)
+}
+
+// NewReplayService reads a recording into a ReplayService whose clock starts
+// now.
+func NewReplayService(in io.Reader, speed float64) (*ReplayService, error) {
+	s := &ReplayService{
+		Speed:   speed,
+		updates: map[string][]BoardUpdate{},
+		started: time.Now(),
+		realNow: time.Now,
+	}
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var update BoardUpdate
+		if err := json.Unmarshal(scanner.Bytes(), &update); err != nil {
+			return nil, fmt.Errorf("recording line %d: %v", line, err)
+		}
+		if s.start.IsZero() || update.Time.Before(s.start) {
+			s.start = update.Time
+		}
+		key := recordingKey(update.Stop, update.Filter)
+		s.updates[key] = append(s.updates[key], update)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(s.updates) == 0 {
+		return nil, errors.New("recording is empty")
+	}
+	for _, updates := range s.updates {
+		sort.SliceStable(updates, func(i, j int) bool {
+			return updates[i].Time.Before(updates[j].Time)
+		})
+	}
+	return s, nil
+}
+
+// Now returns the current time in the recording. Set clock to it so that the
+// rest of the app runs on recorded time too.
+func (s *ReplayService) Now() time.Time {
+	elapsed := s.realNow().Sub(s.started)
+	return s.start.Add(time.Duration(float64(elapsed) * s.Speed))
+}
+
+// ListDepartures is an implementation of the MbtaService ListDepartures method
+// that returns the latest departures recorded for the place and filter,
+// narrowed down by the filter again, as extractDepartures does, as of the
+// recording's time. Recordings
+// without filters only have each place's departures, which were already
+// filtered when they were recorded, so the filter can't bring back any that
+// were left out then.
+func (s *ReplayService) ListDepartures(place string, filter Filter) ([]Departure, error) {
+	now := s.Now()
+	updates, ok := s.updates[recordingKey(place, &filter)]
+	if !ok {
+		updates = s.updates[place]
+	}
+	var current *BoardUpdate
+	for i, update := range updates {
+		if update.Time.After(now) {
+			break
+		}
+		current = &updates[i]
+	}
+	if current == nil {
+		return nil, fmt.Errorf("no recording of %s yet", place)
+	}
+	if current.Error != "" {
+		return nil, errors.New(current.Error)
+	}
+	var cutoff time.Time
+	if filter.Window > 0 {
+		cutoff = now.Add(filter.Window)
+	}
+	departures := []Departure{}
+	for _, d := range current.Departures {
+		if filter.matchesDeparture(d) && (cutoff.IsZero() || !d.Time.After(cutoff)) {
+			departures = append(departures, d)
+		}
+	}
+	return departures, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	defer func() { clock = time.Now }()
+	start := time.Date(2018, 9, 9, 15, 0, 0, 0, time.UTC)
+	now := start
+	clock = func() time.Time { return now }
+
+	upstream := &countingService{fixture: "testdata/predictions.json"}
+	var recording bytes.Buffer
+	recorder := NewRecorder(upstream, &recording)
+	fresh, err := recorder.ListDepartures("place-sstat", Filter{})
+	assert.NoError(t, err)
+
+	// Unchanged departures aren't recorded again.
+	now = now.Add(time.Minute)
+	recorder.ListDepartures("place-sstat", Filter{})
+	now = now.Add(time.Minute)
+	upstream.fixture = "testdata/error-429.json"
+	recorder.ListDepartures("place-sstat", Filter{})
+	assert.Equal(t, 3, upstream.calls)
+	assert.Equal(t, 2, strings.Count(recording.String(), "\n"))
+
+	replay, err := NewReplayService(&recording, 60)
+	if !assert.NoError(t, err) {
+		return
+	}
+	real := time.Date(2018, 9, 1, 12, 0, 0, 0, time.UTC)
+	replay.started = real
+	replay.realNow = func() time.Time { return real }
+	assert.Equal(t, start, replay.Now())
+
+	replayed, err := replay.ListDepartures("place-sstat", Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, fresh, replayed)
+	_, err = replay.ListDepartures("place-north", Filter{})
+	assert.EqualError(t, err, "no recording of place-north yet")
+
+	// A real second is a replayed minute.
+	real = real.Add(time.Second)
+	replayed, err = replay.ListDepartures("place-sstat", Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, fresh, replayed)
+	real = real.Add(time.Second)
+	_, err = replay.ListDepartures("place-sstat", Filter{})
+	assert.EqualError(t, err, "MBTA API error: You have exceeded your allowed usage rate.")
+
+	_, err = NewReplayService(strings.NewReader(""), 1)
+	assert.EqualError(t, err, "recording is empty")
+}
+
+func TestRecordByFilter(t *testing.T) {
+	defer func() { clock = time.Now }()
+	clock = func() time.Time { return time.Date(2018, 9, 9, 15, 0, 0, 0, time.UTC) }
+
+	var recording bytes.Buffer
+	recorder := NewRecorder(&MbtaServiceTest{"testdata/predictions-backbay.json"}, &recording)
+	outbound, _ := recorder.ListDepartures("place-bbsta", Filter{})
+	both, _ := recorder.ListDepartures("place-bbsta", Filter{Direction: "both"})
+	assert.NotEqual(t, len(outbound), len(both))
+	assert.Equal(t, 2, strings.Count(recording.String(), "\n"))
+
+	replay, err := NewReplayService(&recording, 1)
+	if !assert.NoError(t, err) {
+		return
+	}
+	replay.realNow = func() time.Time { return replay.started }
+	replayed, err := replay.ListDepartures("place-bbsta", Filter{Direction: "both"})
+	assert.NoError(t, err)
+	assert.Equal(t, both, replayed)
+	replayed, err = replay.ListDepartures("place-bbsta", Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, outbound, replayed)
+}
+
+func TestReplayGreenLine(t *testing.T) {
+	// Recordings made before filters were recorded have each stop's
+	// departures in both directions.
+	departures, err := (&MbtaServiceTest{"testdata/predictions-kenmore.json"}).
+		ListDepartures("place-kencl", Filter{Direction: "both"})
+	assert.NoError(t, err)
+	var recording bytes.Buffer
+	json.NewEncoder(&recording).Encode(BoardUpdate{
+		Time:       time.Date(2018, 9, 10, 21, 0, 0, 0, time.UTC),
+		Stop:       "place-kencl",
+		Departures: departures,
+	})
+
+	replay, err := NewReplayService(&recording, 1)
+	if !assert.NoError(t, err) {
+		return
+	}
+	replay.realNow = func() time.Time { return replay.started }
+	westbound, err := replay.ListDepartures("place-kencl", Filter{})
+	assert.NoError(t, err)
+	assert.Len(t, westbound, 5)
+	branch, err := replay.ListDepartures("place-kencl", Filter{Direction: "inbound", Branch: "D"})
+	assert.NoError(t, err)
+	if assert.Len(t, branch, 1) {
+		assert.Equal(t, "tg4", branch[0].Trip)
+	}
+}
+
+func TestRecorderIsBounded(t *testing.T) {
+	var recording bytes.Buffer
+	recorder := NewRecorder(&MbtaServiceTest{"testdata/predictions.json"}, &recording)
+	for i := 0; i <= maxTrackedBoards; i++ {
+		recorder.ListDepartures(fmt.Sprintf("place-%d", i), Filter{})
+	}
+	assert.Len(t, recorder.last, maxTrackedBoards)
+	assert.NotContains(t, recorder.last, recordingKey("place-0", &Filter{}))
+}
+
+func TestRecorderBoards(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/", nil)
+
+	// The recorder can't batch predictions, so the boards are fetched one at
+	// a time through it.
+	var recording bytes.Buffer
+	service := NewCachingService(NewRecorder(&MbtaServiceTest{"testdata/predictions.json"}, &recording))
+	boards, err := FetchBoards(c, service, DefaultBoards)
+	assert.NoError(t, err)
+	for _, board := range boards {
+		assert.NoError(t, board.Error)
+		assert.NotEmpty(t, board.Departures)
+	}
+	assert.Equal(t, len(DefaultBoards), strings.Count(recording.String(), "\n"))
+}
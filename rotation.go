@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Rotation is the state of a board's rotating slot, which cycles through
+// Items every Interval. The current item depends only on the time, so every
+// page, API response and output showing the board agrees on it; NextChange
+// tells clients when to move on to the next one.
+type Rotation struct {
+	Items      []string      `json:"items"`
+	Index      int           `json:"index"`
+	Item       string        `json:"item"`
+	Interval   time.Duration `json:"-"`
+	NextChange time.Time     `json:"next_change"`
+	// Wait is how long after the board was fetched the next item is due,
+	// which pages use rather than trusting the browser's clock.
+	Wait time.Duration `json:"-"`
+}
+
+// rotationSources are the kinds of item shown in the rotating slot, set with
+// $ROTATION: "alerts" for elevator and escalator outages, "next" for the next
+// departure, "time" for the time, and "text" for each of rotationText.
+var rotationSources []string
+
+// rotationText are the custom items shown in the rotating slot, set with
+// $ROTATION_TEXT as a list separated by "|".
+var rotationText []string
+
+// rotationInterval is how long each item is shown, set with
+// $ROTATION_INTERVAL.
+var rotationInterval = 10 * time.Second
+
+// ValidateRotationSources returns an error if any of the sources isn't one
+// understood by rotationItems.
+func ValidateRotationSources(sources []string) error {
+	for _, source := range sources {
+		switch source {
+		case "alerts", "next", "time", "text":
+		default:
+			return fmt.Errorf("unknown rotation source %q", source)
+		}
+	}
+	return nil
+}
+
+// rotationItems returns the items to rotate through on the board at now, from
+// rotationSources in order.
+func rotationItems(board *DepartureBoard, now time.Time) []string {
+	var items []string
+	for _, source := range rotationSources {
+		switch source {
+		case "alerts":
+			for _, o := range board.Outages {
+				items = append(items, fmt.Sprintf("%s out: %s", o.Facility, truncate(80, o.Description)))
+			}
+		case "next":
+			for _, d := range board.Departures {
+				if d.Time.IsZero() || d.Time.Before(now) {
+					continue
+				}
+				item := fmt.Sprintf("Next: %s to %s", d.TimeLabel, d.Destination)
+				if d.Track != "TBD" {
+					item += " from track " + d.Track
+				}
+				items = append(items, item)
+				break
+			}
+		case "time":
+			items = append(items, "Time now "+now.In(serviceTimeZone).Format("3:04PM"))
+		case "text":
+			items = append(items, rotationText...)
+		}
+	}
+	return items
+}
+
+// NewRotation returns the state at now of a slot rotating through items every
+// interval, or nil if there are no items.
+func NewRotation(items []string, interval time.Duration, now time.Time) *Rotation {
+	if len(items) == 0 || interval <= 0 {
+		return nil
+	}
+	slot := now.UnixNano() / int64(interval)
+	next := time.Unix(0, (slot+1)*int64(interval)).In(now.Location())
+	index := int(slot % int64(len(items)))
+	return &Rotation{
+		Items:      items,
+		Index:      index,
+		Item:       items[index],
+		Interval:   interval,
+		NextChange: next,
+		Wait:       next.Sub(now),
+	}
+}
+
+// parseRotationText splits $ROTATION_TEXT into items.
+func parseRotationText(text string) []string {
+	var items []string
+	for _, item := range strings.Split(text, "|") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
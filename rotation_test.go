@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotationIsSharedByTime(t *testing.T) {
+	items := []string{"a", "b", "c"}
+	now := time.Unix(1536600000, 0)
+	r := NewRotation(items, 10*time.Second, now.Add(3*time.Second))
+	assert.Equal(t, 0, r.Index)
+	assert.Equal(t, "a", r.Item)
+	assert.True(t, now.Add(10*time.Second).Equal(r.NextChange))
+	assert.Equal(t, 7*time.Second, r.Wait)
+
+	r = NewRotation(items, 10*time.Second, now.Add(25*time.Second))
+	assert.Equal(t, "c", r.Item)
+	r = NewRotation(items, 10*time.Second, now.Add(30*time.Second))
+	assert.Equal(t, "a", r.Item)
+
+	assert.Nil(t, NewRotation(nil, 10*time.Second, now))
+}
+
+func TestRotationItems(t *testing.T) {
+	defer func(sources, text []string) {
+		rotationSources, rotationText = sources, text
+	}(rotationSources, rotationText)
+	rotationSources = []string{"alerts", "next", "text", "time"}
+	rotationText = parseRotationText("Welcome to Back Bay | |Tickets on the app")
+	assert.NoError(t, ValidateRotationSources(rotationSources))
+	assert.EqualError(t, ValidateRotationSources([]string{"weather"}), `unknown rotation source "weather"`)
+
+	board := &DepartureBoard{
+		Outages: []Outage{{Facility: "Elevator", Description: "Back Bay elevator 816"}},
+		Departures: []Departure{
+			{TimeLabel: "5:05PM", Destination: "Providence", Track: "1", Time: at("2018-09-10T17:05:00-04:00")},
+			{TimeLabel: "5:20PM", Destination: "Worcester", Track: "TBD", Time: at("2018-09-10T17:20:00-04:00")},
+		},
+	}
+	assert.Equal(t, []string{
+		"Elevator out: Back Bay elevator 816",
+		"Next: 5:20PM to Worcester",
+		"Welcome to Back Bay",
+		"Tickets on the app",
+		"Time now 5:10PM",
+	}, rotationItems(board, at("2018-09-10T17:10:00-04:00")))
+}
+
+func TestRotationOnBoards(t *testing.T) {
+	defer func() { clock = time.Now }()
+	defer func(sources, text []string) {
+		rotationSources, rotationText = sources, text
+	}(rotationSources, rotationText)
+	rotationSources = []string{"text"}
+	rotationText = []string{"First", "Second"}
+	clock = func() time.Time { return time.Unix(1536600010, 0) }
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	LoadTemplates(router, "templates")
+	router.GET("/board/:stop", func(c *gin.Context) {
+		RenderBoard(c, &MbtaServiceTest{"testdata/predictions.json"}, Filter{})
+	})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/board/place-sstat", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `data-index="1" data-interval="10000" data-wait="10000"`)
+	assert.Contains(t, w.Body.String(), `<span class="rotationItem">Second</span>`)
+	assert.Contains(t, w.Body.String(), `<span class="rotationItem" style="display: none">First</span>`)
+
+	var out bytes.Buffer
+	err := runOnce(&MbtaServiceTest{"testdata/predictions.json"}, nil, &out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "* Second\n\nTIME ")
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// AlertRule is a condition on the departures from a stop that sends a
+// notification to its channels when a departure meets it, e.g. any Providence
+// line departure running more than 10 minutes late, or a track being assigned
+// to train 509. When is the condition:
+//
+//   - "delayed": the departure is predicted to leave at least MinDelay late
+//   - "track_assigned": the departure has a track
+//   - "cancelled": the departure is cancelled
+//
+// Route, Destination and Train, if set, limit the departures the rule applies
+// to. Train is a trip ID or the train number at the end of one. Channels are
+// the names of the notification channels to use, "default" if there are none.
+type AlertRule struct {
+	Name        string   `yaml:"name"`
+	Stop        string   `yaml:"stop"`
+	When        string   `yaml:"when"`
+	MinDelay    string   `yaml:"min_delay"`
+	Route       string   `yaml:"route"`
+	Destination string   `yaml:"destination"`
+	Train       string   `yaml:"train"`
+	Channels    []string `yaml:"channels"`
+
+	minDelay time.Duration
+}
+
+// ChannelConfig is the configuration of a notification channel: a webhook
+// the notifications are POSTed to.
+type ChannelConfig struct {
+	Webhook string `yaml:"webhook"`
+}
+
+// RulesConfig is the contents of a rules file.
+type RulesConfig struct {
+	Channels map[string]ChannelConfig `yaml:"channels"`
+	Rules    []AlertRule              `yaml:"rules"`
+}
+
+// LoadRules reads and validates a YAML file of alert rules and the channels
+// they notify.
+func LoadRules(path string) (RulesConfig, error) {
+	var config RulesConfig
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return config, fmt.Errorf("%s: %v", path, err)
+	}
+	for name, channel := range config.Channels {
+		if name == "default" || name == "log" {
+			return config, fmt.Errorf("%s: channel %q is built in", path, name)
+		}
+		if channel.Webhook == "" {
+			return config, fmt.Errorf("%s: channel %q: webhook is required", path, name)
+		}
+	}
+	for i := range config.Rules {
+		r := &config.Rules[i]
+		if err := r.validate(config.Channels); err != nil {
+			return config, fmt.Errorf("%s: rule %q: %v", path, r.Name, err)
+		}
+	}
+	return config, nil
+}
+
+// validate returns an error if the rule can't be evaluated, and parses its
+// minimum delay.
+func (r *AlertRule) validate(channels map[string]ChannelConfig) error {
+	if r.Name == "" || r.Stop == "" {
+		return errors.New("name and stop are required")
+	}
+	switch r.When {
+	case "delayed":
+		d, err := time.ParseDuration(r.MinDelay)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid min_delay %q", r.MinDelay)
+		}
+		r.minDelay = d
+	case "track_assigned", "cancelled":
+		if r.MinDelay != "" {
+			return errors.New("min_delay is only for delayed")
+		}
+	default:
+		return fmt.Errorf("unknown condition %q", r.When)
+	}
+	for _, name := range r.Channels {
+		if _, ok := channels[name]; !ok && name != "default" && name != "log" {
+			return fmt.Errorf("unknown channel %q", name)
+		}
+	}
+	return nil
+}
+
+// applies reports whether the rule is about the departure.
+func (r *AlertRule) applies(d Departure) bool {
+	return (r.Route == "" || r.Route == d.Route) &&
+		(r.Destination == "" || strings.EqualFold(r.Destination, d.Destination)) &&
+		(r.Train == "" || d.Trip == r.Train || strings.HasSuffix(d.Trip, "-"+r.Train))
+}
+
+// Match returns the notification for the departure if it meets the rule's
+// condition.
+func (r *AlertRule) Match(d Departure) (Notification, bool) {
+	if !r.applies(d) {
+		return Notification{}, false
+	}
+	train := fmt.Sprintf("The %s to %s", d.ScheduledLabel(), d.Destination)
+	if d.ScheduledTime.IsZero() {
+		train = fmt.Sprintf("The %s to %s", d.TimeLabel, d.Destination)
+	}
+	var message string
+	switch r.When {
+	case "delayed":
+		if d.Time.IsZero() || d.ScheduledTime.IsZero() || d.Time.Sub(d.ScheduledTime) < r.minDelay {
+			return Notification{}, false
+		}
+		message = fmt.Sprintf("%s is running %d min late, now departing at %s.",
+			train, int(d.Time.Sub(d.ScheduledTime)/time.Minute), d.TimeLabel)
+	case "track_assigned":
+		if d.Track == "" || d.Track == "TBD" {
+			return Notification{}, false
+		}
+		message = fmt.Sprintf("%s is on track %s.", train, d.Track)
+	case "cancelled":
+		if !strings.EqualFold(d.Status, "Cancelled") {
+			return Notification{}, false
+		}
+		message = train + " is cancelled."
+	}
+	return Notification{Title: r.Name, Message: message}, true
+}
+
+// RuleEngine evaluates the alert rules against the departures on every check
+// and hands the notifications for the departures meeting them to their
+// channels. Each rule notifies each channel about a departure only once a
+// service day, though a channel that fails is tried again on the next check.
+type RuleEngine struct {
+	service  MbtaService
+	rules    []AlertRule
+	channels map[string]Notifier
+
+	mu   sync.Mutex
+	day  time.Time
+	sent map[string]bool
+}
+
+// NewRuleEngine creates and returns a rule engine for the configured rules,
+// with the configured channels as well as "default", delivering through
+// fallback, and "log".
+func NewRuleEngine(service MbtaService, config RulesConfig, fallback Notifier) *RuleEngine {
+	channels := map[string]Notifier{"default": fallback, "log": LogNotifier{}}
+	for name, c := range config.Channels {
+		channels[name] = NewWebhookNotifier(c.Webhook, NewHttpClient())
+	}
+	return &RuleEngine{
+		service:  service,
+		rules:    config.Rules,
+		channels: channels,
+		sent:     map[string]bool{},
+	}
+}
+
+// Check fetches the departures from every stop with a rule and sends the
+// notifications for the rules they meet.
+func (e *RuleEngine) Check() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if day := serviceDay(clock()); !day.Equal(e.day) {
+		e.day, e.sent = day, map[string]bool{}
+	}
+	byStop := map[string][]*AlertRule{}
+	stops := []string{}
+	for i := range e.rules {
+		r := &e.rules[i]
+		if _, ok := byStop[r.Stop]; !ok {
+			stops = append(stops, r.Stop)
+		}
+		byStop[r.Stop] = append(byStop[r.Stop], r)
+	}
+	sort.Strings(stops)
+	for _, stop := range stops {
+		departures, err := e.service.ListDepartures(stop, Filter{Direction: "both"})
+		if departures == nil {
+			log.Printf("rules: %v", err)
+			continue
+		}
+		for _, r := range byStop[stop] {
+			for _, d := range departures {
+				if n, ok := r.Match(d); ok {
+					e.dispatch(r, d, n)
+				}
+			}
+		}
+	}
+}
+
+// dispatch sends the notification to the rule's channels that haven't been
+// sent it already. The caller must hold e.mu.
+func (e *RuleEngine) dispatch(r *AlertRule, d Departure, n Notification) {
+	channels := r.Channels
+	if len(channels) == 0 {
+		channels = []string{"default"}
+	}
+	departure := d.Trip
+	if departure == "" {
+		departure = d.TimeLabel + " " + d.Destination
+	}
+	for _, name := range channels {
+		key := r.Name + "|" + departure + "|" + name
+		if e.sent[key] {
+			continue
+		}
+		if err := e.channels[name].Notify(n); err != nil {
+			log.Printf("rules: %s: %v", name, err)
+			continue
+		}
+		e.sent[key] = true
+	}
+}
+
+// Run checks the rules every interval until done is closed.
+func (e *RuleEngine) Run(interval time.Duration, done <-chan struct{}) {
+	poll(interval, done, e.Check)
+}
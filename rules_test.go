@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// failingNotifier fails to deliver anything.
+type failingNotifier struct{}
+
+func (failingNotifier) Notify(n Notification) error {
+	return errors.New("unreachable")
+}
+
+func TestLoadRules(t *testing.T) {
+	config, err := LoadRules("testdata/rules.yaml")
+	assert.NoError(t, err)
+	assert.Len(t, config.Rules, 2)
+	assert.Equal(t, 10*time.Minute, config.Rules[0].minDelay)
+
+	dir, err := ioutil.TempDir("", "rules")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	for rules, message := range map[string]string{
+		"rules: [{name: a, stop: place-sstat, when: late}]":                         `rule "a": unknown condition "late"`,
+		"rules: [{name: a, stop: place-sstat, when: delayed}]":                      `rule "a": invalid min_delay ""`,
+		"rules: [{name: a, stop: place-sstat, when: cancelled, channels: [pager]}]": `rule "a": unknown channel "pager"`,
+		"rules: [{stop: place-sstat, when: cancelled}]":                             `rule "": name and stop are required`,
+	} {
+		path := filepath.Join(dir, "rules.yaml")
+		ioutil.WriteFile(path, []byte(rules), 0644)
+		_, err := LoadRules(path)
+		assert.EqualError(t, err, path+": "+message)
+	}
+}
+
+func TestRuleEngine(t *testing.T) {
+	defer func() { clock = time.Now }()
+	clock = func() time.Time { return at("2018-09-10T10:30:00-04:00") }
+	config, err := LoadRules("testdata/rules.yaml")
+	if !assert.NoError(t, err) {
+		return
+	}
+	fallback := &recordingNotifier{}
+	ops := &recordingNotifier{}
+	engine := NewRuleEngine(&MbtaServiceTest{"testdata/predictions-delayed.json"}, config, fallback)
+	engine.channels["ops"] = ops
+	// Only the Needham rule is evaluated against these departures.
+	engine.rules = engine.rules[:1]
+
+	engine.Check()
+	assert.Equal(t, []Notification{{
+		Title:   "Needham delays",
+		Message: "The 9:05AM to Needham Heights is running 89 min late, now departing at 10:34AM.",
+	}}, ops.sent)
+	assert.Empty(t, fallback.sent)
+
+	// Each departure is only notified once.
+	engine.Check()
+	assert.Len(t, ops.sent, 1)
+}
+
+func TestRuleEngineRetriesFailedChannels(t *testing.T) {
+	defer func() { clock = time.Now }()
+	clock = func() time.Time { return at("2018-09-10T17:00:00-04:00") }
+	config, err := LoadRules("testdata/rules.yaml")
+	if !assert.NoError(t, err) {
+		return
+	}
+	config.Rules = config.Rules[1:]
+	notifier := &recordingNotifier{}
+	engine := NewRuleEngine(&MbtaServiceTest{"testdata/predictions-backbay.json"}, config, failingNotifier{})
+
+	engine.Check()
+	engine.channels["default"] = notifier
+	engine.Check()
+	engine.Check()
+	assert.Equal(t, []Notification{{
+		Title:   "Train to Providence boarding",
+		Message: "The 5:05PM to Providence is on track 1.",
+	}}, notifier.sent)
+}
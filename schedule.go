@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mattmckeon/splitflap/internal/mbta"
+)
+
+// serviceTimeZone is the time zone that MBTA schedules are expressed in.
+var serviceTimeZone = loadServiceTimeZone()
+
+func loadServiceTimeZone() *time.Location {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		log.Printf("falling back to local time zone: %v", err)
+		return time.Local
+	}
+	return loc
+}
+
+// serviceTimeLayouts are the layouts with offsets accepted by
+// parseServiceTime, most common first. RFC 3339 parsing already accepts
+// fractional seconds of any precision.
+var serviceTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04Z0700",
+	"2006-01-02 15:04:05Z07:00",
+}
+
+// localServiceTimeLayouts are the layouts without offsets accepted by
+// parseServiceTime.
+var localServiceTimeLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+}
+
+// parseServiceTime parses a departure or arrival timestamp from the API. The
+// API documents RFC 3339 timestamps, but some arrive without seconds, with a
+// space instead of a T, or without an offset; the last are taken to be in
+// serviceTimeZone.
+func parseServiceTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range serviceTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range localServiceTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, serviceTimeZone); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q", value)
+}
+
+// serviceTimeLabel formats a time as shown on boards, in serviceTimeZone, so
+// that timestamps given in UTC aren't shown hours off.
+func serviceTimeLabel(t time.Time) string {
+	return t.In(serviceTimeZone).Format("3:04PM")
+}
+
+// ScheduledDeparture is a single row on the schedule preview page.
+// DropOffOnly is set on trains that can't be boarded at the stop.
+type ScheduledDeparture struct {
+	TimeLabel   string `json:"time"`
+	Destination string `json:"destination"`
+	DropOffOnly bool   `json:"drop_off_only,omitempty"`
+}
+
+// ScheduleGroup is the day's scheduled departures from a stop on one line.
+type ScheduleGroup struct {
+	Line       string               `json:"line"`
+	Departures []ScheduledDeparture `json:"departures"`
+}
+
+// ScheduleService is an interface for fetching the day's scheduled
+// departures, grouped by line.
+type ScheduleService interface {
+	ListSchedules(place string, filter Filter) ([]ScheduleGroup, error)
+}
+
+// ListSchedules is an implementation of the ScheduleService ListSchedules
+// method that fetches today's schedule from the MBTA APIv3 schedules endpoint.
+// Unlike predictions, schedules can be filtered by time upstream, so the
+// filter's window is sent with the request, relative to the current service
+// day so that after midnight the window still covers the late-night trains.
+func (s *MbtaServiceImpl) ListSchedules(place string, filter Filter) ([]ScheduleGroup, error) {
+	opts := []mbta.Option{
+		mbta.Filter("stop", place),
+		mbta.Filter("revenue", "REVENUE"),
+		mbta.Include("route", "trip"),
+		mbta.Sort("departure_time"),
+	}
+	if filter.Window > 0 {
+		now := clock().In(serviceTimeZone)
+		day := serviceDay(now)
+		opts = append(opts,
+			mbta.Filter("date", day.Format("2006-01-02")),
+			mbta.Filter("min_time", serviceTimeOfDay(day, now)),
+			mbta.Filter("max_time", serviceTimeOfDay(day, now.Add(filter.Window))))
+	}
+	schedules, err := s.mbta.Schedules(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return ExtractSchedules(schedules, filter)
+}
+
+// ListSchedules is an implementation of the ScheduleService ListSchedules
+// method that ignores the provided place and loads test data from this test
+// service's JsonFile.
+func (s *MbtaServiceTest) ListSchedules(place string, filter Filter) ([]ScheduleGroup, error) {
+	var schedules []*mbta.Schedule
+	if err := s.load(&schedules); err != nil {
+		return nil, err
+	}
+	return ExtractSchedules(schedules, filter)
+}
+
+// serviceTimeOfDay formats t as the HH:MM time of day used by the schedules
+// endpoint, relative to the service day containing day. Times after midnight
+// continue past 24:00, as they do in the schedules themselves.
+func serviceTimeOfDay(day, t time.Time) string {
+	y, m, d := day.Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, day.Location())
+	minutes := int(t.Sub(midnight) / time.Minute)
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+// ExtractSchedules groups the commuter rail departures in the filter's
+// direction by line, in order of departure time. Lines are sorted by name.
+func ExtractSchedules(schedules []*mbta.Schedule, filter Filter) ([]ScheduleGroup, error) {
+	groups := []ScheduleGroup{}
+	lines := map[string]int{}
+	parseError := new(ParseError)
+	for _, schedule := range schedules {
+		direction, ok := mbta.DirectionName(schedule.Route, schedule.Trip)
+		if !ok {
+			log.Printf("payload: skipping incomplete schedule %s", schedule.Id)
+			mbta.PayloadMetrics.Add("incomplete_schedules", 1)
+			continue
+		}
+		// Arrivals at the end of the line have no departure time.
+		if schedule.DepartureTime == "" ||
+			schedule.Route.Type != 2 ||
+			!filter.matchesDirection(direction) {
+			continue
+		}
+		sd := ScheduledDeparture{
+			Destination: schedule.Trip.Headsign,
+			DropOffOnly: !schedule.PickupAllowed(),
+		}
+		st, err := parseServiceTime(schedule.DepartureTime)
+		if err == nil {
+			sd.TimeLabel = serviceTimeLabel(st)
+		} else {
+			err := fmt.Errorf("(Parse Error) %s", schedule.DepartureTime)
+			parseError.Errors = append(parseError.Errors, err)
+			sd.TimeLabel = err.Error()
+		}
+		i, ok := lines[schedule.Route.Id]
+		if !ok {
+			i = len(groups)
+			lines[schedule.Route.Id] = i
+			groups = append(groups, ScheduleGroup{Line: schedule.Route.LongName})
+		}
+		groups[i].Departures = append(groups[i].Departures, sd)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].Line < groups[j].Line
+	})
+	if len(parseError.Errors) > 0 {
+		return groups, parseError
+	}
+	return groups, nil
+}
+
+// RenderSchedule is a helper function that fetches the day's schedule for a
+// stop and outputs the schedule preview page to the gin Context.
+func RenderSchedule(c *gin.Context, client ScheduleService, defaults Filter) {
+	filter, err := ParseFilter(c, defaults)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	groups, err := client.ListSchedules(c.Param("stop"), filter)
+	c.HTML(http.StatusOK, "schedule.tmpl.html", gin.H{
+		"title":  c.DefaultQuery("title", c.Param("stop")) + " Schedule",
+		"groups": groups,
+		"error":  err,
+	})
+}
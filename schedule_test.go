@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func TestParseSchedules(t *testing.T) {
+	actual, err := (&MbtaServiceTest{"testdata/schedules.json"}).
+		ListSchedules("place-north", Filter{})
+	assert.NoError(t, err)
+
+	expected := []ScheduleGroup{
+		{"Haverhill Line", []ScheduledDeparture{
+			{"6:35AM", "Haverhill", false},
+			{"7:40AM", "Reading", false},
+			{"11:55PM", "Haverhill", false},
+		}},
+		{"Lowell Line", []ScheduledDeparture{
+			{"6:45AM", "Lowell", false},
+			{"8:15AM", "Lowell", false},
+		}},
+	}
+	assert.Equal(t, expected, actual)
+}
+
+func TestScheduleWindowIsSentUpstream(t *testing.T) {
+	defer gock.Off()
+	defer func() { clock = time.Now }()
+	clock = func() time.Time {
+		return time.Date(2018, 9, 10, 22, 30, 0, 0, serviceTimeZone)
+	}
+
+	gock.New(MbtaApiV3BaseUrl).
+		Get("/schedules").
+		MatchParam("filter[stop]", "place-north").
+		MatchParam("filter[min_time]", "22:30").
+		MatchParam("filter[max_time]", "24:30").
+		MatchParam("filter[revenue]", "REVENUE").
+		Reply(200).
+		File("testdata/schedules.json")
+
+	httpClient := &http.Client{}
+	gock.InterceptClient(httpClient)
+
+	groups, err := NewMbtaServiceImpl(httpClient).
+		ListSchedules("place-north", Filter{Window: 2 * time.Hour})
+	assert.NoError(t, err)
+	assert.Len(t, groups, 2)
+	assert.True(t, gock.IsDone())
+}
+
+func TestScheduleWindowAfterMidnight(t *testing.T) {
+	defer gock.Off()
+	defer func() { clock = time.Now }()
+	clock = func() time.Time {
+		return time.Date(2018, 9, 11, 0, 30, 0, 0, serviceTimeZone)
+	}
+
+	// Half past midnight is still in the service day that started on the 10th.
+	gock.New(MbtaApiV3BaseUrl).
+		Get("/schedules").
+		MatchParam("filter[stop]", "place-north").
+		MatchParam("filter[date]", "2018-09-10").
+		MatchParam("filter[min_time]", "24:30").
+		MatchParam("filter[max_time]", "26:30").
+		Reply(200).
+		File("testdata/schedules.json")
+
+	httpClient := &http.Client{}
+	gock.InterceptClient(httpClient)
+
+	_, err := NewMbtaServiceImpl(httpClient).
+		ListSchedules("place-north", Filter{Window: 2 * time.Hour})
+	assert.NoError(t, err)
+	assert.True(t, gock.IsDone())
+}
+
+func TestParseServiceTime(t *testing.T) {
+	expected := time.Date(2018, 9, 10, 17, 20, 0, 0, serviceTimeZone)
+	for _, value := range []string{
+		"2018-09-10T17:20:00-04:00",
+		"2018-09-10T17:20:00.000000-04:00",
+		"2018-09-10T21:20:00Z",
+		"2018-09-10T17:20-04:00",
+		"2018-09-10T17:20:00-0400",
+		"2018-09-10 17:20:00-04:00",
+		"2018-09-10T17:20:00",
+		"2018-09-10T17:20",
+		" 2018-09-10T17:20:00-04:00\n",
+	} {
+		actual, err := parseServiceTime(value)
+		if assert.NoError(t, err, value) {
+			assert.True(t, expected.Equal(actual), "%q parsed as %v", value, actual)
+		}
+	}
+
+	_, err := parseServiceTime("5:20PM")
+	assert.EqualError(t, err, `unrecognized time "5:20PM"`)
+}
+
+func TestServiceTimeLabel(t *testing.T) {
+	utc, err := parseServiceTime("2018-09-10T21:20:00Z")
+	assert.NoError(t, err)
+	assert.Equal(t, "5:20PM", serviceTimeLabel(utc))
+}
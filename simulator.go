@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mattmckeon/splitflap/internal/mbta"
+)
+
+// simLine is a commuter rail line run by the Simulator. Trains leave every
+// Headway in each direction.
+type simLine struct {
+	Id        string
+	Name      string
+	Headsigns [2]string
+	Headway   time.Duration
+}
+
+// simLines are the lines the Simulator runs through every stop.
+var simLines = []simLine{
+	{"CR-Providence", "Providence/Stoughton Line", [2]string{"Providence", "South Station"}, 40 * time.Minute},
+	{"CR-Worcester", "Framingham/Worcester Line", [2]string{"Worcester", "South Station"}, 45 * time.Minute},
+	{"CR-Franklin", "Franklin Line", [2]string{"Forge Park/495", "South Station"}, 60 * time.Minute},
+	{"CR-Needham", "Needham Line", [2]string{"Needham Heights", "South Station"}, 75 * time.Minute},
+	{"CR-Lowell", "Lowell Line", [2]string{"Lowell", "North Station"}, 45 * time.Minute},
+	{"CR-Fitchburg", "Fitchburg Line", [2]string{"Wachusett", "North Station"}, 60 * time.Minute},
+}
+
+// Simulator settings. Predictions appear simHorizon before the scheduled
+// departure, delays become known from simDelayNotice before it and build up
+// over simDelayRamp, tracks are announced simTrackNotice before the predicted
+// departure and trains board for the last simBoarding.
+const (
+	simHorizon     = 2 * time.Hour
+	simDelayNotice = 30 * time.Minute
+	simDelayRamp   = 20 * time.Minute
+	simTrackNotice = 15 * time.Minute
+	simBoarding    = 10 * time.Minute
+)
+
+// simTrain is a single scheduled departure in the simulated day, along with
+// what's going to happen to it.
+type simTrain struct {
+	Line      simLine
+	Direction int
+	Number    int
+	Scheduled time.Time
+	Delay     time.Duration
+	Cancelled bool
+	Track     string
+}
+
+// Simulator is an MbtaService that makes up a realistic day of departures
+// rather than calling the MBTA API, for UI work, hardware drivers and demos.
+// Its clock starts at Start and runs Speed times faster than real time. Each
+// stop's timetable, delays and cancellations are random but the same every
+// time for a given stop and day.
+type Simulator struct {
+	Start time.Time
+	Speed float64
+
+	started time.Time
+	realNow func() time.Time
+}
+
+// NewSimulator returns a Simulator whose clock starts now at start.
+func NewSimulator(start time.Time, speed float64) *Simulator {
+	return &Simulator{
+		Start:   start,
+		Speed:   speed,
+		started: time.Now(),
+		realNow: time.Now,
+	}
+}
+
+// Now returns the current simulated time. Set clock to it so that the rest
+// of the app runs on simulated time too.
+func (s *Simulator) Now() time.Time {
+	elapsed := s.realNow().Sub(s.started)
+	return s.Start.Add(time.Duration(float64(elapsed) * s.Speed))
+}
+
+// ListDepartures is an implementation of the MbtaService ListDepartures method
+// that returns the simulated departures from the place.
+func (s *Simulator) ListDepartures(place string, filter Filter) ([]Departure, error) {
+	return ExtractDepartures(s.predictions(place, s.Now()), filter)
+}
+
+// BatchPredictions is an implementation of the PredictionBatcher
+// BatchPredictions method that returns the simulated predictions for each of
+// the places.
+func (s *Simulator) BatchPredictions(places []string) (map[string][]*mbta.Prediction, error) {
+	now := s.Now()
+	batch := map[string][]*mbta.Prediction{}
+	for _, place := range places {
+		batch[place] = s.predictions(place, now)
+	}
+	return batch, nil
+}
+
+// ListSchedules is an implementation of the ScheduleService ListSchedules
+// method that returns the simulated day's timetable for the place.
+func (s *Simulator) ListSchedules(place string, filter Filter) ([]ScheduleGroup, error) {
+	now := s.Now()
+	var schedules []*mbta.Schedule
+	for _, train := range simTimetable(place, now) {
+		if filter.Window > 0 &&
+			(train.Scheduled.Before(now) || train.Scheduled.After(now.Add(filter.Window))) {
+			continue
+		}
+		route, trip := train.resources()
+		schedules = append(schedules, &mbta.Schedule{
+			Id:            trip.Id,
+			DepartureTime: train.Scheduled.Format(time.RFC3339),
+			Route:         route,
+			Trip:          trip,
+		})
+	}
+	return ExtractSchedules(schedules, filter)
+}
+
+// predictions returns the predictions for the place as they stand at now,
+// in order of predicted departure.
+func (s *Simulator) predictions(place string, now time.Time) []*mbta.Prediction {
+	type timed struct {
+		prediction *mbta.Prediction
+		time       time.Time
+	}
+	var upcoming []timed
+	for _, train := range simTimetable(place, now) {
+		if now.Before(train.Scheduled.Add(-simHorizon)) {
+			continue
+		}
+		// The delay builds up as the train gets closer.
+		progress := float64(now.Sub(train.Scheduled.Add(-simDelayNotice))) / float64(simDelayRamp)
+		progress = math.Max(0, math.Min(1, progress))
+		delay := time.Duration(float64(train.Delay) * progress).Round(time.Minute)
+		predicted := train.Scheduled.Add(delay)
+		if train.Cancelled {
+			predicted = train.Scheduled
+		}
+		if !now.Before(predicted.Add(time.Minute)) {
+			continue
+		}
+
+		route, trip := train.resources()
+		prediction := &mbta.Prediction{
+			Id:            "sim-" + trip.Id,
+			DepartureTime: predicted.Format(time.RFC3339),
+			Revenue:       "REVENUE",
+			Route:         route,
+			Trip:          trip,
+			Stop: &mbta.Stop{
+				Id:            place,
+				ParentStation: &mbta.Stop{Id: place},
+			},
+			Schedule: &mbta.Schedule{
+				Id:            "sim-" + trip.Id,
+				DepartureTime: train.Scheduled.Format(time.RFC3339),
+			},
+		}
+		switch {
+		case train.Cancelled:
+			prediction.Status = "Cancelled"
+		case !now.Before(predicted.Add(-simBoarding)):
+			prediction.Status = "Now boarding"
+		case delay == 0:
+			prediction.Status = "On time"
+		}
+		if !train.Cancelled && !now.Before(predicted.Add(-simTrackNotice)) {
+			prediction.Stop.PlatformCode = train.Track
+		}
+		upcoming = append(upcoming, timed{prediction, predicted})
+	}
+	sort.SliceStable(upcoming, func(i, j int) bool {
+		return upcoming[i].time.Before(upcoming[j].time)
+	})
+	predictions := make([]*mbta.Prediction, len(upcoming))
+	for i, u := range upcoming {
+		predictions[i] = u.prediction
+	}
+	return predictions
+}
+
+// resources returns the route and trip the train runs as.
+func (t simTrain) resources() (*mbta.Route, *mbta.Trip) {
+	route := &mbta.Route{
+		Id:             t.Line.Id,
+		Type:           2,
+		LongName:       t.Line.Name,
+		DirectionNames: []string{"Outbound", "Inbound"},
+	}
+	trip := &mbta.Trip{
+		Id:          fmt.Sprintf("%s-%d", t.Line.Id, t.Number),
+		Headsign:    t.Line.Headsigns[t.Direction],
+		DirectionId: t.Direction,
+	}
+	return route, trip
+}
+
+// simTimetable returns the trains leaving the place on the service day
+// containing now, between 5AM and midnight.
+func simTimetable(place string, now time.Time) []simTrain {
+	day := now.In(serviceTimeZone)
+	y, m, d := day.Date()
+	first := time.Date(y, m, d, 5, 0, 0, 0, serviceTimeZone)
+	last := time.Date(y, m, d, 24, 0, 0, 0, serviceTimeZone)
+
+	seed := fnv.New64a()
+	fmt.Fprintf(seed, "%s %d-%d-%d", place, y, m, d)
+	rng := rand.New(rand.NewSource(int64(seed.Sum64())))
+
+	trains := []simTrain{}
+	for _, line := range simLines {
+		for direction := 0; direction < 2; direction++ {
+			offset := time.Duration(rng.Int63n(int64(line.Headway/time.Minute))) * time.Minute
+			for t := first.Add(offset); t.Before(last); t = t.Add(line.Headway) {
+				train := simTrain{
+					Line:      line,
+					Direction: direction,
+					Number:    len(trains),
+					Scheduled: t,
+					Track:     strconv.Itoa(1 + rng.Intn(12)),
+				}
+				switch p := rng.Float64(); {
+				case p < 0.04:
+					train.Cancelled = true
+				case p < 0.3:
+					train.Delay = time.Duration(2+rng.Intn(19)) * time.Minute
+				}
+				trains = append(trains, train)
+			}
+		}
+	}
+	return trains
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulatorClock(t *testing.T) {
+	start := time.Date(2018, 9, 10, 6, 0, 0, 0, serviceTimeZone)
+	real := time.Date(2018, 9, 1, 12, 0, 0, 0, time.UTC)
+	sim := NewSimulator(start, 60)
+	sim.started = real
+	sim.realNow = func() time.Time { return real }
+	assert.Equal(t, start, sim.Now())
+
+	real = real.Add(time.Minute)
+	assert.Equal(t, start.Add(time.Hour), sim.Now())
+}
+
+func TestSimulatorDepartures(t *testing.T) {
+	now := time.Date(2018, 9, 10, 8, 0, 0, 0, serviceTimeZone)
+	sim := NewSimulator(now, 1)
+
+	first := sim.predictions("place-bbsta", now)
+	assert.NotEmpty(t, first)
+	assert.Equal(t, first, sim.predictions("place-bbsta", now), "simulation should be repeatable")
+	departures, err := ExtractDepartures(first, Filter{Direction: "both"})
+	assert.NoError(t, err)
+	for i := 1; i < len(departures); i++ {
+		assert.False(t, departures[i].Time.Before(departures[i-1].Time))
+	}
+	for _, d := range departures {
+		assert.False(t, d.Time.Before(now.Add(-time.Minute)))
+		assert.False(t, d.Time.After(now.Add(simHorizon+time.Hour)))
+	}
+
+	// Follow the next train that isn't cancelled until it leaves.
+	var train *Departure
+	for i, d := range departures {
+		if d.Status != "Cancelled" {
+			train = &departures[i]
+			break
+		}
+	}
+	if !assert.NotNil(t, train) {
+		return
+	}
+	find := func(at time.Time) *Departure {
+		departures, _ := ExtractDepartures(sim.predictions("place-bbsta", at), Filter{Direction: "both"})
+		for i, d := range departures {
+			if d.Destination == train.Destination && d.ScheduledTime.Equal(train.ScheduledTime) {
+				return &departures[i]
+			}
+		}
+		return nil
+	}
+	boarding := find(train.Time.Add(-5 * time.Minute))
+	if assert.NotNil(t, boarding) {
+		assert.Equal(t, "Now boarding", boarding.Status)
+		assert.NotEqual(t, "TBD", boarding.Track)
+	}
+	assert.Nil(t, find(train.Time.Add(2*time.Minute)))
+}
+
+func TestSimulatorSchedules(t *testing.T) {
+	sim := NewSimulator(time.Date(2018, 9, 10, 8, 0, 0, 0, serviceTimeZone), 1)
+	groups, err := sim.ListSchedules("place-bbsta", Filter{})
+	assert.NoError(t, err)
+	assert.Len(t, groups, len(simLines))
+}
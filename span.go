@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mattmckeon/splitflap/internal/mbta"
+)
+
+// LineSpan is the first and last scheduled departures from a stop on a line
+// in one direction.
+type LineSpan struct {
+	Route     string    `json:"route"`
+	Line      string    `json:"line"`
+	Direction string    `json:"direction"`
+	First     time.Time `json:"first"`
+	FirstTrip string    `json:"first_trip"`
+	Last      time.Time `json:"last"`
+	LastTrip  string    `json:"last_trip"`
+}
+
+// ServiceSpan is the LineSpans of a stop for a service day.
+type ServiceSpan struct {
+	Date  string     `json:"date"`
+	Lines []LineSpan `json:"lines"`
+}
+
+// SpanService is an interface for fetching the schedules of a stop's commuter
+// rail lines on a given service day, optionally only on one route. The
+// schedules may include other routes, which are ignored.
+type SpanService interface {
+	SpanSchedules(place, route string, day time.Time) ([]*mbta.Schedule, error)
+}
+
+// errNoSpans is returned by wrappers' SpanSchedules methods when the service
+// they wrap isn't a SpanService.
+var errNoSpans = errors.New("service can't fetch spans")
+
+// SpanSchedules is an implementation of the SpanService SpanSchedules method
+// that fetches only the route's schedule, or the commuter rail's if route is
+// empty, from the MBTA APIv3 schedules endpoint.
+func (s *MbtaServiceImpl) SpanSchedules(place, route string, day time.Time) ([]*mbta.Schedule, error) {
+	opts := []mbta.Option{
+		mbta.Filter("stop", place),
+		mbta.Filter("date", day.Format("2006-01-02")),
+		mbta.Filter("revenue", "REVENUE"),
+		mbta.Include("route", "trip"),
+		mbta.Sort("departure_time"),
+	}
+	if route != "" {
+		opts = append(opts, mbta.Filter("route", route))
+	} else {
+		opts = append(opts, mbta.Filter("route_type", "2"))
+	}
+	return s.mbta.Schedules(opts...)
+}
+
+// SpanSchedules is an implementation of the SpanService SpanSchedules method
+// that ignores the provided place, route and day and loads test data from this
+// test service's JsonFile.
+func (s *MbtaServiceTest) SpanSchedules(place, route string, day time.Time) ([]*mbta.Schedule, error) {
+	return s.SchedulesOn(place, day)
+}
+
+// Spans returns the first and last departures in the schedules on each line
+// in each direction, sorted by line and direction. Arrivals at the end of the
+// line, which have no departure time, and stops where the train can't be
+// boarded are skipped.
+func Spans(schedules []*mbta.Schedule) []LineSpan {
+	type line struct {
+		route     string
+		direction int
+	}
+	spans := map[line]*LineSpan{}
+	for _, schedule := range schedules {
+		direction, ok := mbta.DirectionName(schedule.Route, schedule.Trip)
+		if !ok || schedule.DepartureTime == "" || !schedule.PickupAllowed() {
+			continue
+		}
+		t, err := parseServiceTime(schedule.DepartureTime)
+		if err != nil {
+			continue
+		}
+		l := line{schedule.Route.Id, schedule.Trip.DirectionId}
+		span, ok := spans[l]
+		if !ok {
+			span = &LineSpan{
+				Route:     schedule.Route.Id,
+				Line:      schedule.Route.LongName,
+				Direction: direction,
+				First:     t,
+				FirstTrip: schedule.Trip.Id,
+			}
+			spans[l] = span
+		}
+		if t.Before(span.First) {
+			span.First, span.FirstTrip = t, schedule.Trip.Id
+		}
+		if !t.Before(span.Last) {
+			span.Last, span.LastTrip = t, schedule.Trip.Id
+		}
+	}
+	lines := []line{}
+	for l := range spans {
+		lines = append(lines, l)
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		a, b := spans[lines[i]], spans[lines[j]]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return lines[i].direction < lines[j].direction
+	})
+	sorted := make([]LineSpan, len(lines))
+	for i, l := range lines {
+		sorted[i] = *spans[l]
+	}
+	return sorted
+}
+
+// RenderSpan outputs the first and last departures from the ?stop= on each
+// commuter rail line, optionally only the ?route=, for today's and tomorrow's
+// service days.
+func RenderSpan(c *gin.Context, service SpanService) {
+	stop, route := c.Query("stop"), c.Query("route")
+	if stop == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "stop is required"})
+		return
+	}
+	today := serviceDay(clock())
+	days := []ServiceSpan{}
+	for _, day := range []time.Time{today, today.AddDate(0, 0, 1)} {
+		schedules, err := service.SpanSchedules(stop, route, day)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			return
+		}
+		wanted := []*mbta.Schedule{}
+		for _, schedule := range schedules {
+			if schedule.Route != nil && schedule.Route.Type == 2 &&
+				(route == "" || schedule.Route.Id == route) {
+				wanted = append(wanted, schedule)
+			}
+		}
+		days = append(days, ServiceSpan{Date: day.Format("2006-01-02"), Lines: Spans(wanted)})
+	}
+	c.JSON(http.StatusOK, gin.H{"stop": stop, "days": days})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mattmckeon/splitflap/internal/mbta"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func TestSpans(t *testing.T) {
+	schedules, err := (&MbtaServiceTest{"testdata/schedules-backbay.json"}).
+		SchedulesOn("place-bbsta", time.Time{})
+	assert.NoError(t, err)
+
+	spans := Spans(schedules)
+	assert.Len(t, spans, 4)
+	assert.Equal(t, LineSpan{
+		Route:     "CR-Worcester",
+		Line:      "Framingham/Worcester Line",
+		Direction: "Outbound",
+		First:     at("2018-09-10T17:20:00-04:00"),
+		FirstTrip: "t3",
+		Last:      at("2018-09-10T22:20:00-04:00"),
+		LastTrip:  "t9",
+	}, spans[0])
+	assert.Equal(t, "Inbound", spans[1].Direction)
+	assert.Equal(t, "Providence/Stoughton Line", spans[2].Line)
+	assert.Equal(t, "t4", spans[3].LastTrip)
+}
+
+func TestRenderSpan(t *testing.T) {
+	defer func() { clock = time.Now }()
+	clock = func() time.Time {
+		return time.Date(2018, 9, 11, 1, 0, 0, 0, serviceTimeZone)
+	}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/v1/span", func(c *gin.Context) {
+		RenderSpan(c, &MbtaServiceTest{"testdata/schedules-backbay.json"})
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/span?stop=place-bbsta&route=CR-Providence", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var span struct {
+		Stop string
+		Days []ServiceSpan
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &span))
+	assert.Equal(t, "place-bbsta", span.Stop)
+	// It's still the 10th's service day after midnight.
+	assert.Len(t, span.Days, 2)
+	assert.Equal(t, "2018-09-10", span.Days[0].Date)
+	assert.Equal(t, "2018-09-11", span.Days[1].Date)
+	assert.Len(t, span.Days[0].Lines, 2)
+	assert.Equal(t, "CR-Providence", span.Days[0].Lines[0].Route)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/span", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// orangeLineSpans serves the Back Bay schedules fixture along with an Orange
+// Line departure, as the precomputed schedules of the stop would.
+type orangeLineSpans struct {
+	MbtaServiceTest
+}
+
+func (s *orangeLineSpans) SpanSchedules(place, route string, day time.Time) ([]*mbta.Schedule, error) {
+	schedules, err := s.MbtaServiceTest.SpanSchedules(place, route, day)
+	return append(schedules, &mbta.Schedule{
+		Id:            "orange",
+		DepartureTime: "2018-09-10T17:00:00-04:00",
+		Route:         &mbta.Route{Id: "Orange", Type: 1, LongName: "Orange Line", DirectionNames: []string{"South", "North"}},
+		Trip:          &mbta.Trip{Id: "o1", Headsign: "Forest Hills"},
+	}), err
+}
+
+func TestRenderSpanOnlyShowsCommuterRail(t *testing.T) {
+	defer func() { clock = time.Now }()
+	clock = func() time.Time {
+		return time.Date(2018, 9, 10, 12, 0, 0, 0, serviceTimeZone)
+	}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/v1/span", func(c *gin.Context) {
+		RenderSpan(c, &orangeLineSpans{MbtaServiceTest{"testdata/schedules-backbay.json"}})
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/span?stop=place-bbsta", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var span struct {
+		Days []ServiceSpan
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &span))
+	assert.Len(t, span.Days[0].Lines, 4)
+	for _, line := range span.Days[0].Lines {
+		assert.NotEqual(t, "Orange", line.Route)
+	}
+}
+
+func TestSpanSchedulesAreFilteredAndCached(t *testing.T) {
+	defer gock.Off()
+	defer func() { clock = time.Now }()
+	clock = func() time.Time {
+		return time.Date(2018, 9, 10, 12, 0, 0, 0, serviceTimeZone)
+	}
+	day := time.Date(2018, 9, 10, 0, 0, 0, 0, serviceTimeZone)
+
+	// Only the route's schedule is fetched, or the commuter rail's without
+	// one, and a failure is repeated without asking again.
+	gock.New(MbtaApiV3BaseUrl).
+		Get("/schedules").
+		MatchParam("filter[stop]", "place-bbsta").
+		MatchParam("filter[date]", "2018-09-10").
+		MatchParam("filter[route]", "CR-Providence").
+		Reply(200).
+		File("testdata/schedules-backbay.json")
+	gock.New(MbtaApiV3BaseUrl).
+		Get("/schedules").
+		MatchParam("filter[route_type]", "2").
+		Reply(429).
+		File("testdata/error-429.json")
+
+	httpClient := &http.Client{}
+	gock.InterceptClient(httpClient)
+	cache := NewCachingService(NewMbtaServiceImpl(httpClient))
+
+	schedules, err := cache.SpanSchedules("place-bbsta", "CR-Providence", day)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, schedules)
+	for i := 0; i < 2; i++ {
+		_, err = cache.SpanSchedules("place-bbsta", "", day)
+		assert.Error(t, err)
+	}
+	assert.Equal(t, CacheStats{Requests: 3, Hits: 1}, cache.Stats())
+	assert.True(t, gock.IsDone())
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mattmckeon/splitflap/internal/mbta"
+)
+
+// onTimeThreshold is how late a departure can be and still count as on time,
+// the MBTA's standard for the commuter rail.
+const onTimeThreshold = 5 * time.Minute
+
+// statsDays is the number of service days of delays kept, today included.
+const statsDays = 7
+
+// DelayRecord is the delay of one trip on a service day, from the latest
+// prediction seen for it. Early departures have a negative Delay.
+type DelayRecord struct {
+	Day   string        `json:"day"`
+	Route string        `json:"route"`
+	Line  string        `json:"line"`
+	Trip  string        `json:"trip"`
+	Delay time.Duration `json:"delay"`
+}
+
+// DelayHistory keeps the delays of the trips seen on boards over the last
+// statsDays service days. If it has a path, Save writes them there so they
+// survive restarts.
+type DelayHistory struct {
+	path string
+
+	mu      sync.Mutex
+	records map[string]DelayRecord
+	dirty   bool
+}
+
+// NewDelayHistory creates and returns a delay history saved at path, loading
+// any delays already there. An empty path keeps the delays in memory.
+func NewDelayHistory(path string) (*DelayHistory, error) {
+	h := &DelayHistory{path: path, records: map[string]DelayRecord{}}
+	if path == "" {
+		return h, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var records []DelayRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, r := range records {
+		h.records[r.Day+"/"+r.Trip] = r
+	}
+	return h, nil
+}
+
+// delayHistory keeps the delays of the departures shown, saved to
+// $STATS_FILE if it's set.
+var delayHistory, _ = NewDelayHistory("")
+
+// Observe records the delay of a trip on the route, predicted to leave at
+// predicted rather than scheduled. Later observations of the same trip replace
+// earlier ones, so the delay recorded is the one closest to its departure.
+func (h *DelayHistory) Observe(route *mbta.Route, trip string, scheduled, predicted time.Time) {
+	if h == nil || route == nil {
+		return
+	}
+	r := DelayRecord{
+		Day:   serviceDay(scheduled).Format("2006-01-02"),
+		Route: route.Id,
+		Line:  route.LongName,
+		Trip:  trip,
+		Delay: predicted.Sub(scheduled),
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := r.Day + "/" + r.Trip
+	if h.records[key] != r {
+		h.records[key] = r
+		h.dirty = true
+	}
+}
+
+// ObserveBoard records the delays of the departures on a freshly fetched board
+// that have both a predicted and a scheduled time. Departures that aren't MBTA
+// trips, such as those of other providers on merged boards, are skipped even
+// if their feed gives them MBTA route IDs.
+func (h *DelayHistory) ObserveBoard(board *DepartureBoard) {
+	if board.Error != nil {
+		return
+	}
+	for _, d := range board.Departures {
+		if d.Route == "" || d.Trip == "" || (d.Provider != "" && d.Provider != mbtaProvider) ||
+			d.Time.IsZero() || d.ScheduledTime.IsZero() {
+			continue
+		}
+		h.Observe(&mbta.Route{Id: d.Route, LongName: d.Line}, d.Trip, d.ScheduledTime, d.Time)
+	}
+}
+
+// Save drops the delays older than statsDays and, if the history has a path
+// and has changed, writes it there. Failures are logged, leaving the delays in
+// memory.
+func (h *DelayHistory) Save() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	oldest := serviceDay(clock()).AddDate(0, 0, 1-statsDays).Format("2006-01-02")
+	for key, r := range h.records {
+		if r.Day < oldest {
+			delete(h.records, key)
+			h.dirty = true
+		}
+	}
+	if h.path == "" || !h.dirty {
+		return
+	}
+	records := []DelayRecord{}
+	for _, r := range h.records {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Day != records[j].Day {
+			return records[i].Day < records[j].Day
+		}
+		return records[i].Trip < records[j].Trip
+	})
+	data, err := json.Marshal(records)
+	if err == nil {
+		err = writeFileAtomic(h.path, append(data, '\n'))
+	}
+	if err != nil {
+		log.Printf("stats: %v", err)
+		return
+	}
+	h.dirty = false
+}
+
+// RouteStats are the statistics of a route's departures: the number of trips
+// and their average delay today, in minutes and not counting early
+// departures, and the number of trips and the percentage on time over the
+// last statsDays service days.
+type RouteStats struct {
+	Route             string  `json:"route"`
+	Line              string  `json:"line"`
+	TripsToday        int     `json:"trips_today"`
+	AverageDelayToday float64 `json:"average_delay_minutes_today"`
+	TripsThisWeek     int     `json:"trips_this_week"`
+	OnTimeThisWeek    float64 `json:"on_time_percent_this_week"`
+}
+
+// Stats returns the statistics of the given routes at now, or of every route
+// with recorded delays if there are none, sorted by line.
+func (h *DelayHistory) Stats(routes []string, now time.Time) []RouteStats {
+	wanted := map[string]bool{}
+	for _, r := range routes {
+		wanted[r] = true
+	}
+	today := serviceDay(now).Format("2006-01-02")
+	oldest := serviceDay(now).AddDate(0, 0, 1-statsDays).Format("2006-01-02")
+	type totals struct {
+		stats  RouteStats
+		delay  time.Duration
+		onTime int
+	}
+	byRoute := map[string]*totals{}
+	h.mu.Lock()
+	for _, r := range h.records {
+		if r.Day < oldest || r.Day > today || (len(wanted) > 0 && !wanted[r.Route]) {
+			continue
+		}
+		t := byRoute[r.Route]
+		if t == nil {
+			t = &totals{stats: RouteStats{Route: r.Route, Line: r.Line}}
+			byRoute[r.Route] = t
+		}
+		t.stats.TripsThisWeek++
+		if r.Delay < onTimeThreshold {
+			t.onTime++
+		}
+		if r.Day == today {
+			t.stats.TripsToday++
+			if r.Delay > 0 {
+				t.delay += r.Delay
+			}
+		}
+	}
+	h.mu.Unlock()
+
+	stats := []RouteStats{}
+	for _, t := range byRoute {
+		if t.stats.TripsToday > 0 {
+			minutes := t.delay.Minutes() / float64(t.stats.TripsToday)
+			t.stats.AverageDelayToday = math.Round(minutes*10) / 10
+		}
+		percent := 100 * float64(t.onTime) / float64(t.stats.TripsThisWeek)
+		t.stats.OnTimeThisWeek = math.Round(percent*10) / 10
+		stats = append(stats, t.stats)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Line < stats[j].Line
+	})
+	return stats
+}
+
+// boardRoutes returns the routes of the board's departures.
+func boardRoutes(board *DepartureBoard) []string {
+	routes := []string{}
+	seen := map[string]bool{}
+	for _, d := range board.Departures {
+		if d.Route != "" && !seen[d.Route] {
+			seen[d.Route] = true
+			routes = append(routes, d.Route)
+		}
+	}
+	return routes
+}
+
+// RenderStats responds with the statistics of the routes in the request's
+// ?route= parameters, or of every route seen if there are none.
+func RenderStats(c *gin.Context, history *DelayHistory) {
+	c.JSON(http.StatusOK, history.Stats(c.QueryArray("route"), clock()))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mattmckeon/splitflap/internal/mbta"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelayStats(t *testing.T) {
+	worcester := &mbta.Route{Id: "CR-Worcester", LongName: "Framingham/Worcester Line"}
+	providence := &mbta.Route{Id: "CR-Providence", LongName: "Providence/Stoughton Line"}
+	h, _ := NewDelayHistory("")
+	scheduled := at("2018-09-10T17:00:00-04:00")
+	h.Observe(worcester, "t1", scheduled, scheduled.Add(10*time.Minute))
+	// The latest prediction for a trip replaces earlier ones.
+	h.Observe(worcester, "t2", scheduled, scheduled.Add(10*time.Minute))
+	h.Observe(worcester, "t2", scheduled, scheduled.Add(2*time.Minute))
+	// Early departures are on time, but don't make the average delay shorter.
+	h.Observe(worcester, "t3", scheduled, scheduled.Add(-time.Minute))
+	// Earlier in the week.
+	h.Observe(worcester, "t1", scheduled.AddDate(0, 0, -2), scheduled.AddDate(0, 0, -2))
+	// Too long ago to count.
+	h.Observe(worcester, "t1", scheduled.AddDate(0, 0, -7), scheduled.AddDate(0, 0, -7).Add(time.Hour))
+	h.Observe(providence, "p1", scheduled, scheduled)
+
+	now := at("2018-09-10T18:00:00-04:00")
+	assert.Equal(t, []RouteStats{
+		{Route: "CR-Worcester", Line: "Framingham/Worcester Line", TripsToday: 3,
+			AverageDelayToday: 4, TripsThisWeek: 4, OnTimeThisWeek: 75},
+	}, h.Stats([]string{"CR-Worcester"}, now))
+	stats := h.Stats(nil, now)
+	assert.Len(t, stats, 2)
+	assert.Equal(t, "Framingham/Worcester Line", stats[0].Line)
+	assert.Equal(t, "Providence/Stoughton Line", stats[1].Line)
+}
+
+func TestDelayHistorySurvivesRestarts(t *testing.T) {
+	defer func() { clock = time.Now }()
+	clock = func() time.Time { return at("2018-09-10T18:00:00-04:00") }
+	dir, err := ioutil.TempDir("", "stats")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "stats.json")
+
+	h, err := NewDelayHistory(path)
+	assert.NoError(t, err)
+	route := &mbta.Route{Id: "CR-Worcester", LongName: "Framingham/Worcester Line"}
+	scheduled := at("2018-09-10T17:00:00-04:00")
+	h.Observe(route, "t1", scheduled, scheduled.Add(6*time.Minute))
+	h.Observe(route, "t1", scheduled.AddDate(0, 0, -7), scheduled.AddDate(0, 0, -7))
+	h.Save()
+
+	h, err = NewDelayHistory(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []RouteStats{
+		{Route: "CR-Worcester", Line: "Framingham/Worcester Line", TripsToday: 1,
+			AverageDelayToday: 6, TripsThisWeek: 1, OnTimeThisWeek: 0},
+	}, h.Stats(nil, clock()))
+	assert.Len(t, h.records, 1)
+}
+
+func TestFetchedBoardsRecordDelays(t *testing.T) {
+	defer func(h *DelayHistory) { delayHistory = h }(delayHistory)
+	delayHistory, _ = NewDelayHistory("")
+	now := at("2018-09-10T10:30:00-04:00")
+	service := &MbtaServiceTest{"testdata/predictions-delayed.json"}
+
+	// Listing departures doesn't record their delays; fetching a board does.
+	_, err := service.ListDepartures("place-sstat", Filter{})
+	assert.NoError(t, err)
+	assert.Empty(t, delayHistory.Stats(nil, now))
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/", nil)
+	FetchBoard(c, service, BoardDefinition{Title: "South Station", Stop: "place-sstat"})
+	assert.NotEmpty(t, delayHistory.Stats(nil, now))
+}
+
+func TestPerformanceFootnote(t *testing.T) {
+	defer func(h *DelayHistory) { delayHistory = h }(delayHistory)
+	defer func() { clock = time.Now }()
+	delayHistory, _ = NewDelayHistory("")
+	clock = func() time.Time { return at("2018-09-10T10:30:00-04:00") }
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	LoadTemplates(router, "templates")
+	router.GET("/board/:stop", func(c *gin.Context) {
+		RenderBoard(c, &MbtaServiceTest{"testdata/predictions-delayed.json"}, Filter{})
+	})
+	router.GET("/api/v1/stats", func(c *gin.Context) {
+		RenderStats(c, delayHistory)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/board/place-sstat", nil))
+	assert.NotContains(t, w.Body.String(), `class="performance"`)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/board/place-sstat?performance=1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `class="performance"`)
+	assert.Contains(t, w.Body.String(), "min late on average today")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/stats", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"on_time_percent_this_week"`)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"crypto/subtle"
+	"expvar"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mattmckeon/splitflap/internal/mbta"
+)
+
+// maxRecentErrors is the number of errors kept for the status page.
+const maxRecentErrors = 20
+
+// maxTrackedBoards is the number of stops whose boards are kept for the
+// status page. Any stop can be asked for, so the least recently fetched are
+// dropped past this.
+const maxTrackedBoards = 100
+
+// recentKeys keeps the keys of a map in the order they were last used, so
+// that the least recently used can be dropped once there are more than Max.
+type recentKeys struct {
+	Max  int
+	keys []string
+}
+
+// use makes key the most recently used, and returns the least recently used
+// key and true if there are now more than Max.
+func (r *recentKeys) use(key string) (string, bool) {
+	for i, k := range r.keys {
+		if k == key {
+			r.keys = append(r.keys[:i], r.keys[i+1:]...)
+			break
+		}
+	}
+	r.keys = append(r.keys, key)
+	if len(r.keys) <= r.Max {
+		return "", false
+	}
+	oldest := r.keys[0]
+	r.keys = r.keys[1:]
+	return oldest, true
+}
+
+// BoardStatus is the state of a stop's board as of the last time it was
+// fetched, and the quality of its data. Title is the title it was last shown
+// with.
+type BoardStatus struct {
+	Stop        string      `json:"stop"`
+	Title       string      `json:"title"`
+	LastSuccess time.Time   `json:"last_success,omitzero"`
+	LastError   string      `json:"last_error,omitempty"`
+	StaleSince  time.Time   `json:"stale_since,omitzero"`
+	Quality     DataQuality `json:"quality"`
+}
+
+// StatusError is an error recorded for the status page. Source says what
+// failed, e.g. the title of a board.
+type StatusError struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	Error  string    `json:"error"`
+}
+
+// StatusTracker keeps the board states, by stop, and recent errors shown on
+// the status page. It's safe for concurrent use.
+type StatusTracker struct {
+	mu     sync.Mutex
+	boards map[string]*BoardStatus
+	recent recentKeys
+	errors []StatusError
+}
+
+// NewStatusTracker returns an empty StatusTracker that keeps the boards of
+// the maxTrackedBoards most recently fetched stops.
+func NewStatusTracker() *StatusTracker {
+	return &StatusTracker{
+		boards: map[string]*BoardStatus{},
+		recent: recentKeys{Max: maxTrackedBoards},
+	}
+}
+
+// statusTracker records the status of the boards fetched by the web server.
+var statusTracker = NewStatusTracker()
+
+// RecordBoard records the outcome of fetching the board for a stop, and the
+// quality of its departures if they're fresh.
+func (t *StatusTracker) RecordBoard(stop string, board *DepartureBoard) {
+	if board.Error != nil {
+		t.RecordError(board.Title, board.Error)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status := t.board(stop)
+	status.Title = board.Title
+	status.StaleSince = board.StaleSince
+	if board.Departures != nil && board.StaleSince.IsZero() {
+		measureQuality(&status.Quality, board)
+	}
+	switch {
+	case board.Error != nil:
+		status.LastError = board.Error.Error()
+	case board.StaleSince.IsZero():
+		status.LastSuccess = clock()
+		status.LastError = ""
+	}
+}
+
+// RecordCoverage records how many of the departures scheduled for a stop's
+// board soon have predictions.
+func (t *StatusTracker) RecordCoverage(stop string, scheduled, covered int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	q := &t.board(stop).Quality
+	q.Scheduled = scheduled
+	q.CoveragePercent = percentOf(covered, scheduled)
+}
+
+// board returns the status of the stop's board, adding it if it's new and
+// dropping the least recently used if there are then too many. The caller
+// must hold t.mu.
+func (t *StatusTracker) board(stop string) *BoardStatus {
+	status, ok := t.boards[stop]
+	if !ok {
+		status = &BoardStatus{Stop: stop, Title: stop}
+		t.boards[stop] = status
+	}
+	if oldest, ok := t.recent.use(stop); ok {
+		delete(t.boards, oldest)
+	}
+	return status
+}
+
+// Quality returns the data quality of each board, by stop.
+func (t *StatusTracker) Quality() map[string]DataQuality {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	quality := map[string]DataQuality{}
+	for stop, status := range t.boards {
+		quality[stop] = status.Quality
+	}
+	return quality
+}
+
+// RecordError adds an error to the recent errors, dropping the oldest if
+// there are more than maxRecentErrors.
+func (t *StatusTracker) RecordError(source string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.errors = append(t.errors, StatusError{Time: clock(), Source: source, Error: err.Error()})
+	if len(t.errors) > maxRecentErrors {
+		t.errors = t.errors[len(t.errors)-maxRecentErrors:]
+	}
+}
+
+// Boards returns the status of each board being tracked, sorted by title.
+func (t *StatusTracker) Boards() []BoardStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	boards := []BoardStatus{}
+	for _, status := range t.boards {
+		boards = append(boards, *status)
+	}
+	sort.Slice(boards, func(i, j int) bool {
+		return boards[i].Title < boards[j].Title
+	})
+	return boards
+}
+
+// RecentErrors returns the recent errors, newest first.
+func (t *StatusTracker) RecentErrors() []StatusError {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	errors := make([]StatusError, len(t.errors))
+	for i, e := range t.errors {
+		errors[len(errors)-1-i] = e
+	}
+	return errors
+}
+
+// RateLimitReporter is an interface for services that know how much of the
+// MBTA API's rate limit is left.
+type RateLimitReporter interface {
+	RateLimit() (mbta.RateLimit, bool)
+}
+
+// RateLimit is an implementation of the RateLimitReporter RateLimit method
+// that returns the budget reported with the last API response.
+func (s *MbtaServiceImpl) RateLimit() (mbta.RateLimit, bool) {
+	return s.mbta.RateLimit()
+}
+
+// StatusReport is the content of the status page.
+type StatusReport struct {
+	Boards       []BoardStatus   `json:"boards"`
+	Cache        *CacheStats     `json:"cache,omitempty"`
+	CacheHitRate float64         `json:"cache_hit_rate"`
+	RateLimit    *mbta.RateLimit `json:"rate_limit,omitempty"`
+	Displays     []DisplayStatus `json:"displays,omitempty"`
+	RecentErrors []StatusError   `json:"recent_errors"`
+}
+
+// NewStatusReport returns the current status of the boards fetched from the
+// given service, along with its cache and rate limit if it has them and the
+// connections of the remote displays if any are configured.
+func NewStatusReport(tracker *StatusTracker, service MbtaService) StatusReport {
+	report := StatusReport{
+		Boards:       tracker.Boards(),
+		RecentErrors: tracker.RecentErrors(),
+	}
+	if cache, ok := service.(*CachingService); ok {
+		stats := cache.Stats()
+		report.Cache = &stats
+		report.CacheHitRate = stats.HitRate()
+	}
+	if reporter, ok := unwrapService(service).(RateLimitReporter); ok {
+		if rateLimit, ok := reporter.RateLimit(); ok {
+			report.RateLimit = &rateLimit
+		}
+	}
+	if displayHub != nil {
+		report.Displays = displayHub.Statuses()
+	}
+	return report
+}
+
+// RegisterStatusRoutes adds the status page, its JSON equivalent and the
+// expvars at /debug/vars to the router. Requests must pass the token, either
+// as a bearer token in the Authorization header or as ?token=.
+func RegisterStatusRoutes(router gin.IRouter, token string, service MbtaService) {
+	status := router.Group("", requireToken(token))
+	status.GET("/status", func(c *gin.Context) {
+		c.HTML(http.StatusOK, "status.tmpl.html", gin.H{
+			"report": NewStatusReport(statusTracker, service),
+		})
+	})
+	status.GET("/api/v1/status", func(c *gin.Context) {
+		c.JSON(http.StatusOK, NewStatusReport(statusTracker, service))
+	})
+	status.GET("/debug/vars", gin.WrapH(expvar.Handler()))
+}
+
+// requireToken returns middleware that rejects requests that don't pass the
+// token.
+func requireToken(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		given := c.Query("token")
+		if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			given = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusTracker(t *testing.T) {
+	defer func() { clock = time.Now }()
+	now := time.Date(2018, 9, 9, 15, 0, 0, 0, time.UTC)
+	clock = func() time.Time { return now }
+
+	tracker := NewStatusTracker()
+	tracker.RecordBoard("place-sstat", &DepartureBoard{Title: "South Station"})
+	now = now.Add(time.Minute)
+	tracker.RecordBoard("place-sstat", &DepartureBoard{Title: "South Station", Error: errors.New("boom")})
+	tracker.RecordBoard("place-north", &DepartureBoard{Title: "North Station"})
+
+	assert.Equal(t, []BoardStatus{
+		{Stop: "place-north", Title: "North Station", LastSuccess: now},
+		{Stop: "place-sstat", Title: "South Station", LastSuccess: now.Add(-time.Minute), LastError: "boom"},
+	}, tracker.Boards())
+
+	// Only the most recently fetched stops are kept.
+	for i := 0; i < maxTrackedBoards; i++ {
+		tracker.RecordBoard(fmt.Sprintf("stop-%d", i), &DepartureBoard{Title: "Anything"})
+		if i == 0 {
+			tracker.RecordBoard("place-north", &DepartureBoard{Title: "North Station"})
+		}
+	}
+	boards := tracker.Boards()
+	assert.Len(t, boards, maxTrackedBoards)
+	assert.NotContains(t, boards, BoardStatus{Stop: "place-sstat", Title: "South Station",
+		LastSuccess: now.Add(-time.Minute), LastError: "boom"})
+	assert.Contains(t, tracker.Quality(), "place-north")
+
+	for i := 0; i < maxRecentErrors+5; i++ {
+		tracker.RecordError("test", fmt.Errorf("error %d", i))
+	}
+	errs := tracker.RecentErrors()
+	assert.Len(t, errs, maxRecentErrors)
+	assert.Equal(t, fmt.Sprintf("error %d", maxRecentErrors+4), errs[0].Error)
+}
+
+func TestStatusRoutes(t *testing.T) {
+	defer func() { displayHub = nil }()
+	displays, err := LoadDisplays("testdata/displays.yaml")
+	assert.NoError(t, err)
+	displayHub = NewDisplayHub(&MbtaServiceTest{"testdata/predictions.json"}, displays)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	LoadTemplates(router, "templates")
+	service := NewCachingService(&MbtaServiceTest{"testdata/predictions.json"})
+	RegisterStatusRoutes(router, "secret", service)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/status", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/status?token=secret", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<caption>Recent Errors</caption>")
+	assert.Contains(t, w.Body.String(), "<caption>Data Quality</caption>")
+	assert.Contains(t, w.Body.String(), "<caption>Displays</caption>")
+	assert.Contains(t, w.Body.String(), "Disconnected")
+
+	service.ListDepartures("place-sstat", Filter{})
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/v1/status", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var report StatusReport
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, &CacheStats{Requests: 1}, report.Cache)
+	assert.Nil(t, report.RateLimit)
+	assert.Equal(t, []DisplayStatus{{Name: "lobby"}, {Name: "platform"}}, report.Displays)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/debug/vars", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/debug/vars?token=secret", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"load"`)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TemplateFuncs are the helper functions available to all templates, so
+// custom templates can format boards the same way without any Go changes.
+var TemplateFuncs = template.FuncMap{
+	"relativeTime": relativeTime,
+	"plural":       plural,
+	"statusClass":  statusClass,
+	"truncate":     truncate,
+	"boardText":    boardText,
+	"dict":         dict,
+}
+
+// LoadTemplates registers TemplateFuncs with the router and loads the HTML
+// templates from dir.
+func LoadTemplates(router *gin.Engine, dir string) {
+	router.SetFuncMap(TemplateFuncs)
+	router.LoadHTMLGlob(dir + "/*.tmpl.html")
+}
+
+// relativeTime describes t relative to now, e.g. "in 5 min" or "2 min ago".
+// It returns an empty string for the zero time.
+func relativeTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	minutes := int(t.Sub(clock()).Round(time.Minute) / time.Minute)
+	switch {
+	case minutes == 0:
+		return "now"
+	case minutes > 0:
+		return fmt.Sprintf("in %d min", minutes)
+	default:
+		return fmt.Sprintf("%d min ago", -minutes)
+	}
+}
+
+// plural returns n followed by singular if n is 1, or by pluralForm otherwise,
+// e.g. {{plural .Bikes "bike" "bikes"}}.
+func plural(n int, singular, pluralForm string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, singular)
+	}
+	return fmt.Sprintf("%d %s", n, pluralForm)
+}
+
+// statusClass returns the CSS classes for a departure's status cell.
+func statusClass(status string) string {
+	switch strings.ToLower(status) {
+	case "delayed":
+		return "status delayed"
+	case "now boarding", "all aboard":
+		return "status boarding"
+	case "cancelled":
+		return "status cancelled"
+	default:
+		return "status"
+	}
+}
+
+// truncate shortens s to at most n characters, e.g. {{.Destination |
+// truncate 18}}.
+func truncate(n int, s string) string {
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[:n])
+	}
+	return s
+}
+
+// boardText formats s the way split-flap displays show text.
+func boardText(s string) string {
+	return strings.ToUpper(s)
+}
+
+// dict builds a map from alternating keys and values, so partials can be
+// passed more than one value, e.g. {{template "x" dict "Board" . "Row" $row}}.
+func dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errors.New("dict needs an even number of arguments")
+	}
+	m := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict key %v is not a string", pairs[i])
+		}
+		m[key] = pairs[i+1]
+	}
+	return m, nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelativeTime(t *testing.T) {
+	defer func() { clock = time.Now }()
+	now := at("2018-09-10T10:00:00-04:00")
+	clock = func() time.Time { return now }
+
+	assert.Equal(t, "", relativeTime(time.Time{}))
+	assert.Equal(t, "now", relativeTime(now.Add(20*time.Second)))
+	assert.Equal(t, "in 5 min", relativeTime(now.Add(5*time.Minute)))
+	assert.Equal(t, "2 min ago", relativeTime(now.Add(-2*time.Minute)))
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	tmpl := template.Must(template.New("test").Funcs(TemplateFuncs).Parse(
+		`{{plural 1 "bike" "bikes"}}|{{plural 0 "bike" "bikes"}}|` +
+			`{{statusClass "Delayed"}}|{{statusClass "Now boarding"}}|{{statusClass "On time"}}|` +
+			`{{"Forge Park/495" | truncate 10}}|{{boardText "Readville"}}|` +
+			`{{with dict "a" 1 "b" "two"}}{{.a}}{{.b}}{{end}}`))
+	var out bytes.Buffer
+	assert.NoError(t, tmpl.Execute(&out, nil))
+	assert.Equal(t, "1 bike|0 bikes|status delayed|status boarding|status|"+
+		"Forge Park|READVILLE|1two", out.String())
+
+	_, err := dict("a")
+	assert.Error(t, err)
+}
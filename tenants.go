@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v2"
+)
+
+// TenantConfig is the configuration of one tenant of a shared deployment,
+// such as a building with its own lobby boards. Its boards are served under
+// Prefix (e.g. "/north"), or at the root of Host if that's set instead, with
+// the stylesheet at Theme added to its pages. ApiKey, if set, replaces
+// $API_KEY for its requests to the MBTA API.
+type TenantConfig struct {
+	Name   string              `yaml:"name"`
+	Prefix string              `yaml:"prefix"`
+	Host   string              `yaml:"host"`
+	Theme  string              `yaml:"theme"`
+	ApiKey string              `yaml:"api_key"`
+	Boards []TenantBoardConfig `yaml:"boards"`
+}
+
+// TenantBoardConfig is the configuration of one of a tenant's boards.
+type TenantBoardConfig struct {
+	Title     string `yaml:"title"`
+	Stop      string `yaml:"stop"`
+	Direction string `yaml:"direction"`
+	Window    string `yaml:"window"`
+	Fares     string `yaml:"fares"`
+	Layout    string `yaml:"layout"`
+}
+
+// LoadTenants reads and validates a YAML file with a list of tenants.
+func LoadTenants(path string) ([]TenantConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		Tenants []TenantConfig `yaml:"tenants"`
+	}
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	seen := map[string]bool{}
+	for _, t := range config.Tenants {
+		if err := t.validate(); err != nil {
+			return nil, fmt.Errorf("%s: tenant %q: %v", path, t.Name, err)
+		}
+		if key := t.Host + t.Prefix; seen[key] {
+			return nil, fmt.Errorf("%s: tenant %q: %s is already used", path, t.Name, key)
+		} else {
+			seen[key] = true
+		}
+	}
+	return config.Tenants, nil
+}
+
+// validate returns an error if the tenant can't be served.
+func (t TenantConfig) validate() error {
+	if t.Name == "" {
+		return errors.New("name is required")
+	}
+	if (t.Prefix == "") == (t.Host == "") {
+		return errors.New("exactly one of prefix and host is required")
+	}
+	if t.Prefix != "" && (!strings.HasPrefix(t.Prefix, "/") || strings.HasSuffix(t.Prefix, "/")) {
+		return fmt.Errorf("prefix %q must start and not end with /", t.Prefix)
+	}
+	if len(t.Boards) == 0 {
+		return errors.New("at least one board is required")
+	}
+	_, err := t.Definitions()
+	return err
+}
+
+// Definitions returns the definitions of the tenant's boards.
+func (t TenantConfig) Definitions() ([]BoardDefinition, error) {
+	defs := []BoardDefinition{}
+	for _, b := range t.Boards {
+		if b.Stop == "" {
+			return nil, errors.New("every board needs a stop")
+		}
+		def := BoardDefinition{Title: b.Title, Stop: b.Stop, Fares: b.Fares, Layout: b.Layout}
+		if def.Title == "" {
+			def.Title = b.Stop
+		}
+		switch b.Direction {
+		case "", "outbound", "inbound", "both":
+			def.Filter.Direction = b.Direction
+		default:
+			return nil, fmt.Errorf("invalid direction %q", b.Direction)
+		}
+		if b.Window != "" {
+			d, err := time.ParseDuration(b.Window)
+			if err != nil {
+				return nil, fmt.Errorf("invalid window %q", b.Window)
+			}
+			def.Filter.Window = d
+		}
+		if !validFares(def.Fares) {
+			return nil, fmt.Errorf("invalid fares %q", def.Fares)
+		}
+		if !validLayout(def.Layout) {
+			return nil, fmt.Errorf("invalid layout %q", def.Layout)
+		}
+		defs = append(defs, def)
+	}
+	return defs, nil
+}
+
+// NewTenantRouter returns the router serving a tenant's boards from the given
+// service, as NewBoardRouter does for the main boards and sharing the load
+// shedder, with the tenant's theme and path prefix set for its pages. The
+// schedule and trip pages the boards link to are served from the same
+// service, so they use the tenant's API key.
+func NewTenantRouter(t TenantConfig, service MbtaService, shedder *LoadShedder) *gin.Engine {
+	defs, _ := t.Definitions()
+	router, pages := NewBoardRouter(service, defs, Filter{}, shedder, func(c *gin.Context) {
+		c.Set("theme", t.Theme)
+		c.Set("prefix", t.Prefix)
+	})
+	schedules, _ := unwrapService(service).(ScheduleService)
+	trips, _ := unwrapService(service).(TripService)
+	registerTripRoutes(pages, schedules, trips, Filter{})
+	return router
+}
+
+// TenantMux sends requests to the router of the tenant whose host or prefix
+// they match, and all others to Default.
+type TenantMux struct {
+	Default http.Handler
+	hosts   map[string]http.Handler
+	tenants []tenantPrefix
+}
+
+// tenantPrefix is a tenant served under a path prefix.
+type tenantPrefix struct {
+	prefix  string
+	handler http.Handler
+}
+
+// NewTenantMux returns a TenantMux for the tenants, each with its router.
+func NewTenantMux(fallback http.Handler, tenants []TenantConfig, routers []http.Handler) *TenantMux {
+	m := &TenantMux{Default: fallback, hosts: map[string]http.Handler{}}
+	for i, t := range tenants {
+		if t.Host != "" {
+			m.hosts[strings.ToLower(t.Host)] = routers[i]
+		} else {
+			m.tenants = append(m.tenants,
+				tenantPrefix{t.Prefix, http.StripPrefix(t.Prefix, routers[i])})
+		}
+	}
+	return m
+}
+
+// ServeHTTP implements the http.Handler interface for TenantMux. A request
+// for a prefix without the trailing slash is redirected to it, so relative
+// links on the tenant's pages work.
+func (m *TenantMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if handler, ok := m.hosts[strings.ToLower(host)]; ok {
+		handler.ServeHTTP(w, r)
+		return
+	}
+	for _, t := range m.tenants {
+		if r.URL.Path == t.prefix {
+			http.Redirect(w, r, t.prefix+"/", http.StatusMovedPermanently)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, t.prefix+"/") {
+			t.handler.ServeHTTP(w, r)
+			return
+		}
+	}
+	m.Default.ServeHTTP(w, r)
+}
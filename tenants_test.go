@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func TestLoadTenants(t *testing.T) {
+	tenants, err := LoadTenants("testdata/tenants.yaml")
+	assert.NoError(t, err)
+	assert.Len(t, tenants, 2)
+	assert.Equal(t, "back-bay-key", tenants[1].ApiKey)
+
+	defs, err := tenants[0].Definitions()
+	assert.NoError(t, err)
+	assert.Equal(t, []BoardDefinition{{
+		Title:  "Trains from North Station",
+		Stop:   "place-north",
+		Filter: Filter{Window: 2 * time.Hour},
+	}}, defs)
+
+	dir, err := ioutil.TempDir("", "splitflap")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for config, expected := range map[string]string{
+		"tenants:\n- name: a\n  boards: [{stop: place-north}]\n":                                                 `tenant "a": exactly one of prefix and host is required`,
+		"tenants:\n- name: a\n  prefix: /a/\n  boards: [{stop: place-north}]\n":                                  `tenant "a": prefix "/a/" must start and not end with /`,
+		"tenants:\n- name: a\n  prefix: /a\n":                                                                    `tenant "a": at least one board is required`,
+		"tenants:\n- name: a\n  prefix: /a\n  boards: [{stop: x, direction: up}]\n":                              `tenant "a": invalid direction "up"`,
+		"tenants:\n- {name: a, prefix: /a, boards: [{stop: x}]}\n- {name: b, prefix: /a, boards: [{stop: x}]}\n": `tenant "b": /a is already used`,
+	} {
+		path := filepath.Join(dir, "tenants.yaml")
+		assert.NoError(t, ioutil.WriteFile(path, []byte(config), 0644))
+		_, err := LoadTenants(path)
+		assert.EqualError(t, err, path+": "+expected)
+	}
+}
+
+func TestTenantMux(t *testing.T) {
+	tenants, err := LoadTenants("testdata/tenants.yaml")
+	assert.NoError(t, err)
+	gin.SetMode(gin.TestMode)
+	// The tenants share a load shedder, as they do in main.
+	shedder := NewLoadShedder(1)
+	shedder.Wait = 10 * time.Millisecond
+	routers := []http.Handler{
+		NewTenantRouter(tenants[0], &MbtaServiceTest{"testdata/predictions.json"}, shedder),
+		NewTenantRouter(tenants[1], &MbtaServiceTest{"testdata/predictions-backbay.json"}, shedder),
+	}
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	mux := NewTenantMux(fallback, tenants, routers)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/north/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<caption>Trains from North Station</caption>")
+	assert.Contains(t, w.Body.String(), `href="/static/themes/north.css"`)
+	assert.Contains(t, w.Header().Get("Cache-Control"), "s-maxage=")
+	assert.Contains(t, w.Body.String(), `/north/board/place-north/og.png`)
+	assert.Contains(t, w.Body.String(), `<a href="/north/train/CR-Sunday-Spring-18-2761">`)
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/north/board/place-north/og.png", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/north/schedule/place-north", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/north", nil))
+	assert.Equal(t, http.StatusMovedPermanently, w.Code)
+	assert.Equal(t, "/north/", w.Header().Get("Location"))
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Host = "BackBay.example.com:8080"
+	mux.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<caption>Back Bay</caption>")
+	assert.Contains(t, w.Body.String(), "<th>Zone</th>")
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/northern", nil))
+	assert.Equal(t, http.StatusTeapot, w.Code)
+
+	// Under load, each tenant is served its own last response, headers
+	// included.
+	shedder.slots <- struct{}{}
+	defer func() { <-shedder.slots }()
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/north/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<caption>Trains from North Station</caption>")
+	assert.Contains(t, w.Header().Get("Cache-Control"), "s-maxage=")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<caption>Back Bay</caption>")
+}
+
+func TestTenantTripRoutes(t *testing.T) {
+	defer gock.Off()
+	mockTrip(1)
+	httpClient := &http.Client{}
+	gock.InterceptClient(httpClient)
+
+	tenants, err := LoadTenants("testdata/tenants.yaml")
+	assert.NoError(t, err)
+	gin.SetMode(gin.TestMode)
+	router := NewTenantRouter(tenants[1], NewCachingService(NewMbtaServiceImpl(httpClient)), NewLoadShedder(32))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/train/t1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Providence/Stoughton Line")
+	assert.True(t, gock.IsDone())
+}
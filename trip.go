@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mattmckeon/splitflap/internal/mbta"
+)
+
+// TripStop is one of the stops a train has yet to make, with its predicted
+// departure time, or arrival time at the end of the line.
+type TripStop struct {
+	Name      string    `json:"name"`
+	Track     string    `json:"track,omitempty"`
+	TimeLabel string    `json:"time"`
+	Time      time.Time `json:"predicted_time"`
+	Status    string    `json:"status,omitempty"`
+}
+
+// TrainLocation is where the train running a trip was last seen. Status
+// describes it, e.g. "Stopped at Back Bay".
+type TrainLocation struct {
+	Label     string    `json:"label"`
+	Status    string    `json:"status"`
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
+}
+
+// TripProgress is how far a train has got along its trip: the stops it has
+// left to make and, if the train is reporting its position, where it is.
+type TripProgress struct {
+	Trip        string         `json:"trip"`
+	Line        string         `json:"line"`
+	Destination string         `json:"destination"`
+	Train       *TrainLocation `json:"train,omitempty"`
+	Stops       []TripStop     `json:"stops"`
+}
+
+// TripService is an interface for following a single trip.
+type TripService interface {
+	TripProgress(trip string) (*TripProgress, error)
+}
+
+// errUnknownTrip is returned by TripProgress for trips with no predictions,
+// which have either finished or never existed.
+var errUnknownTrip = errors.New("no predictions for this train, it may have finished its trip")
+
+// vehicleStatuses describe a vehicle's current_status relative to its stop.
+var vehicleStatuses = map[string]string{
+	"INCOMING_AT":   "Arriving at",
+	"STOPPED_AT":    "Stopped at",
+	"IN_TRANSIT_TO": "On the way to",
+}
+
+// TripProgress is an implementation of the TripService TripProgress method
+// that fetches the trip's predictions and its vehicle from the MBTA APIv3.
+// Failing to fetch the vehicle is logged, and the stops returned without it.
+func (s *MbtaServiceImpl) TripProgress(trip string) (*TripProgress, error) {
+	predictions, err := s.mbta.Predictions(
+		mbta.Filter("trip", trip),
+		mbta.Include("route", "stop", "trip"),
+		mbta.Sort("stop_sequence"))
+	if err != nil {
+		return nil, err
+	}
+	progress, err := extractTripProgress(trip, predictions)
+	if err != nil {
+		return nil, err
+	}
+	vehicles, err := s.mbta.Vehicles(
+		mbta.Filter("trip", trip),
+		mbta.Include("stop"))
+	if err != nil {
+		log.Printf("vehicles: %v", err)
+	} else if len(vehicles) > 0 {
+		progress.Train = trainLocation(vehicles[0])
+	}
+	return progress, nil
+}
+
+// extractTripProgress returns the progress of the trip from its predictions,
+// leaving out stops the train won't make.
+func extractTripProgress(trip string, predictions []*mbta.Prediction) (*TripProgress, error) {
+	predictions = append([]*mbta.Prediction(nil), predictions...)
+	sort.SliceStable(predictions, func(i, j int) bool {
+		return predictions[i].StopSequence < predictions[j].StopSequence
+	})
+	progress := &TripProgress{Trip: trip, Stops: []TripStop{}}
+	for _, p := range predictions {
+		if p.Route != nil {
+			progress.Line = p.Route.LongName
+		}
+		if p.Trip != nil {
+			progress.Destination = p.Trip.Headsign
+		}
+		value := p.DepartureTime
+		if value == "" {
+			value = p.ArrivalTime
+		}
+		t, err := parseServiceTime(value)
+		if err != nil || p.Stop == nil {
+			continue
+		}
+		progress.Stops = append(progress.Stops, TripStop{
+			Name:      p.Stop.Name,
+			Track:     p.Stop.PlatformCode,
+			TimeLabel: serviceTimeLabel(t),
+			Time:      t,
+			Status:    p.Status,
+		})
+	}
+	if len(progress.Stops) == 0 {
+		return nil, errUnknownTrip
+	}
+	return progress, nil
+}
+
+// trainLocation returns the location of the vehicle.
+func trainLocation(v *mbta.Vehicle) *TrainLocation {
+	location := &TrainLocation{
+		Label:     v.Label,
+		Status:    strings.ToLower(strings.Replace(v.CurrentStatus, "_", " ", -1)),
+		Latitude:  v.Latitude,
+		Longitude: v.Longitude,
+	}
+	if status, ok := vehicleStatuses[v.CurrentStatus]; ok && v.Stop != nil && v.Stop.Name != "" {
+		location.Status = status + " " + v.Stop.Name
+	}
+	if t, err := parseServiceTime(v.UpdatedAt); err == nil {
+		location.UpdatedAt = t
+	}
+	return location
+}
+
+// RenderTrip renders the progress of the request's :trip, as JSON if the
+// request is for the API.
+func RenderTrip(c *gin.Context, client TripService, api bool) {
+	progress, err := client.TripProgress(c.Param("trip"))
+	status := http.StatusOK
+	if err == errUnknownTrip {
+		status = http.StatusNotFound
+	} else if err != nil {
+		status = http.StatusBadGateway
+	}
+	if api {
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(status, progress)
+		}
+		return
+	}
+	c.HTML(status, "train.tmpl.html", gin.H{
+		"trip":  progress,
+		"error": err,
+	})
+}
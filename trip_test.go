@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/h2non/gock.v1"
+)
+
+func mockTrip(times int) {
+	gock.New(MbtaApiV3BaseUrl).
+		Get("/predictions").
+		MatchParam("filter[trip]", "^t1$").
+		MatchParam("sort", "stop_sequence").
+		Times(times).
+		Reply(200).
+		File("testdata/predictions-trip.json")
+	gock.New(MbtaApiV3BaseUrl).
+		Get("/vehicles").
+		MatchParam("filter[trip]", "^t1$").
+		Times(times).
+		Reply(200).
+		File("testdata/vehicles-trip.json")
+}
+
+func TestTripProgress(t *testing.T) {
+	defer gock.Off()
+	mockTrip(1)
+
+	httpClient := &http.Client{}
+	gock.InterceptClient(httpClient)
+
+	progress, err := NewMbtaServiceImpl(httpClient).TripProgress("t1")
+	assert.NoError(t, err)
+	assert.Equal(t, &TripProgress{
+		Trip:        "t1",
+		Line:        "Providence/Stoughton Line",
+		Destination: "Providence",
+		Train: &TrainLocation{
+			Label:     "1712",
+			Status:    "Stopped at Back Bay",
+			Latitude:  42.34735,
+			Longitude: -71.075727,
+			UpdatedAt: at("2018-09-10T17:04:12-04:00"),
+		},
+		Stops: []TripStop{
+			{Name: "Back Bay", Track: "1", TimeLabel: "5:05PM", Time: at("2018-09-10T17:05:00-04:00"), Status: "Now boarding"},
+			{Name: "Ruggles", Track: "1", TimeLabel: "5:09PM", Time: at("2018-09-10T17:09:30-04:00")},
+			{Name: "Forest Hills", Track: "5", TimeLabel: "5:14PM", Time: at("2018-09-10T17:14:30-04:00")},
+			// The end of the line only has an arrival time.
+			{Name: "Providence", Track: "3", TimeLabel: "6:10PM", Time: at("2018-09-10T18:10:00-04:00")},
+		},
+	}, progress)
+	assert.True(t, gock.IsDone())
+}
+
+func TestRenderTrip(t *testing.T) {
+	defer gock.Off()
+	mockTrip(2)
+	gock.New(MbtaApiV3BaseUrl).
+		Get("/predictions").
+		MatchParam("filter[trip]", "^t9$").
+		Reply(200).
+		JSON(map[string]interface{}{"data": []interface{}{}})
+
+	httpClient := &http.Client{}
+	gock.InterceptClient(httpClient)
+	service := NewMbtaServiceImpl(httpClient)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	LoadTemplates(router, "templates")
+	router.GET("/train/:trip", func(c *gin.Context) {
+		RenderTrip(c, service, false)
+	})
+	router.GET("/api/v1/train/:trip", func(c *gin.Context) {
+		RenderTrip(c, service, true)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/train/t1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Providence/Stoughton Line to Providence")
+	assert.Contains(t, w.Body.String(), "Train 1712: Stopped at Back Bay")
+	assert.Contains(t, w.Body.String(), `<td class="destination">Forest Hills</td>`)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/train/t1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"Stopped at Back Bay"`)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/train/t9", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.True(t, gock.IsDone())
+}
+
+func TestBoardRowsLinkToTrains(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	LoadTemplates(router, "templates")
+	router.GET("/board/:stop", func(c *gin.Context) {
+		RenderBoard(c, &MbtaServiceTest{"testdata/predictions-backbay.json"}, Filter{})
+	})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/board/place-bbsta", nil))
+	assert.Contains(t, w.Body.String(), `<a href="/train/t1">Providence</a>`)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This is a minimal implementation of the server side of the WebSocket
+// protocol (RFC 6455), enough for display clients: unfragmented text
+// messages, pings and closes. Messages from clients are limited to
+// maxWsMessage bytes, and must be masked.
+
+// wsGuid is appended to the client's key to make the handshake's accept key.
+const wsGuid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const maxWsMessage = 64 * 1024
+
+// WebSocket opcodes.
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xa
+)
+
+// wsProtocolError is the close status sent to peers that break the protocol.
+const wsProtocolError = 1002
+
+// errWsClosed is returned by wsConn.ReadMessage when the peer closes the
+// connection.
+var errWsClosed = errors.New("websocket closed")
+
+// errWsUnmasked is returned by wsConn.ReadMessage on the server side when the
+// client sends a frame without masking it, as RFC 6455 section 5.1 requires.
+var errWsUnmasked = errors.New("unmasked WebSocket frame from client")
+
+// wsConn is a WebSocket connection. Writes are safe for concurrent use, but
+// only one goroutine may read.
+type wsConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+	// client is set on the client side of a connection, which masks the
+	// frames it sends. Only tests act as clients.
+	client bool
+
+	mu sync.Mutex
+}
+
+// upgradeWebsocket completes the WebSocket handshake for the request and
+// returns the connection. If the request isn't a WebSocket handshake, an
+// error response is written and an error returned.
+func upgradeWebsocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != "GET" || key == "" ||
+		!strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		!headerContains(r.Header.Get("Connection"), "upgrade") {
+		http.Error(w, "expected a WebSocket handshake", http.StatusBadRequest)
+		return nil, errors.New("not a WebSocket handshake")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSockets aren't supported", http.StatusInternalServerError)
+		return nil, errors.New("response can't be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, rw: rw}, nil
+}
+
+// wsAcceptKey returns the Sec-WebSocket-Accept header for a client's key.
+func wsAcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + wsGuid))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerContains reports whether the comma-separated header value contains
+// token, ignoring case.
+func headerContains(value, token string) bool {
+	for _, v := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(v), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadMessage returns the next text message from the peer, answering pings
+// along the way. It returns errWsClosed when the peer closes the connection.
+func (c *wsConn) ReadMessage() ([]byte, error) {
+	for {
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case wsText:
+			return payload, nil
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return nil, err
+			}
+		case wsClose:
+			c.writeFrame(wsClose, nil)
+			return nil, errWsClosed
+		}
+	}
+}
+
+// readFrame reads a single frame. Fragmented messages aren't supported. On
+// the server side, an unmasked frame closes the connection with a protocol
+// error.
+func (c *wsConn) readFrame() (byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.rw, header[:]); err != nil {
+		return 0, nil, err
+	}
+	if header[0]&0x80 == 0 {
+		return 0, nil, errors.New("fragmented WebSocket messages aren't supported")
+	}
+	opcode := header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	if !masked && !c.client {
+		var status [2]byte
+		binary.BigEndian.PutUint16(status[:], wsProtocolError)
+		c.writeFrame(wsClose, status[:])
+		c.Close()
+		return 0, nil, errWsUnmasked
+	}
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > maxWsMessage {
+		return 0, nil, errors.New("WebSocket message too long")
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.rw, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.rw, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return opcode, payload, nil
+}
+
+// WriteMessage sends a text message to the peer.
+func (c *wsConn) WriteMessage(data []byte) error {
+	return c.writeFrame(wsText, data)
+}
+
+// writeFrame sends a single, final frame, masked if this is the client side.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	header := []byte{0x80 | opcode, 0}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = append(header, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header[1] = 127
+		header = append(header, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		header[1] |= 0x80
+		header = append(header, mask[:]...)
+		masked := make([]byte, len(payload))
+		for i := range payload {
+			masked[i] = payload[i] ^ mask[i%4]
+		}
+		payload = masked
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := c.rw.Write(header); err != nil {
+		return err
+	}
+	if _, err := c.rw.Write(payload); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
+
+// Close closes the underlying connection.
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebsocketAcceptKey(t *testing.T) {
+	// The example from RFC 6455.
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", wsAcceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestUpgradeWebsocketRejectsPlainRequests(t *testing.T) {
+	w := httptest.NewRecorder()
+	conn, err := upgradeWebsocket(w, httptest.NewRequest("GET", "/display", nil))
+	assert.Nil(t, conn)
+	assert.Error(t, err)
+	assert.Equal(t, 400, w.Code)
+}
+
+func TestUnmaskedClientFrameIsRejected(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := &wsConn{conn: server, rw: bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server))}
+	result := make(chan error, 1)
+	go func() {
+		_, err := conn.ReadMessage()
+		result <- err
+	}()
+
+	// An unmasked text frame saying "hi".
+	client.Write([]byte{0x81, 2, 'h', 'i'})
+	peer := &wsConn{conn: client, rw: bufio.NewReadWriter(bufio.NewReader(client), bufio.NewWriter(client)), client: true}
+	opcode, payload, err := peer.readFrame()
+	assert.NoError(t, err)
+	assert.Equal(t, byte(wsClose), opcode)
+	if assert.Len(t, payload, 2) {
+		assert.Equal(t, uint16(wsProtocolError), binary.BigEndian.Uint16(payload))
+	}
+	assert.Equal(t, errWsUnmasked, <-result)
+}